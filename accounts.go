@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// allAccountsName replaces the account in the cache file name of runs over all accounts
+const allAccountsName = "_all"
+
+// fetchingAccounts is set while stats of each account are collected by fetchAccounts,
+// so that stats of the previous run are looked up under the account in the cache
+var fetchingAccounts bool
+
+// allAccounts tells whether stats of all configured accounts are collected as -user is empty
+func allAccounts() bool {
+	return *userArg == "" || fetchingAccounts
+}
+
+// accountNames returns configured accounts in order
+func (c *config) accountNames() []string {
+	names := make([]string, 0, len(c.Accounts))
+	for acc := range c.Accounts {
+		names = append(names, acc)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fetchAccounts collects and postprocesses stats of each configured account keyed by account.
+// Accounts are logged in with their login options. An account that fails reports the error
+// under its _error key while others are still collected. Summaries sum up stats of all accounts.
+func fetchAccounts(cfg *config, filter *statsFilter, fetch func(*config) (stats, error)) (stats, error) {
+	accounts := cfg.accountNames()
+	if len(accounts) == 0 {
+		return nil, errors.New("no accounts configured: set -user or list accounts in config")
+	}
+	origUser := *userArg
+	defer func() { *userArg, fetchingAccounts = origUser, false }()
+	fetchingAccounts = true
+
+	res := stats{}
+	collected := results{}
+	failed := 0
+	var lastErr error
+	for _, acc := range accounts {
+		*userArg = acc
+		st, err := fetch(cfg)
+		if err == nil {
+			// postprocessing filters copies, collected stats stay whole
+			collected.add(acc, st)
+			st, err = postprocessStats(cfg, st, filter)
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+			warnf("failed to fetch %s: %s", acc, err)
+			res[acc] = stats{outputErrorKey: err.Error()}
+			continue
+		}
+		res[acc] = st
+	}
+	if failed == len(accounts) {
+		return nil, lastErr
+	}
+	cfg.addSummaries(res, collected)
+	return res, nil
+}
+
+// loginCfg is the server address and credentials of an account given under its login key.
+// Options an account omits are taken from -addr, the account name, -pass, -oauth-token and -starttls.
+// Those flags given on the command line override options of accounts.
+type loginCfg struct {
+	Addr string `yaml:"addr"`
+	User string `yaml:"user"`
+	// Pass is a file with the password like -pass
+	Pass string `yaml:"pass"`
+	// PassEnv is an environment variable with the password like -pass-env; it takes precedence over Pass
+	PassEnv string `yaml:"pass_env"`
+	// PassCommand is a shell command printing the password like -pass-command; it takes precedence over others
+	PassCommand string `yaml:"pass_command"`
+	// OAuthToken is an OAuth2 access token source like -oauth-token used instead of the password
+	OAuthToken string `yaml:"oauth_token"`
+	// StartTLS upgrades a plain text connection like -starttls
+	StartTLS bool `yaml:"starttls"`
+}
+
+// loginKey is the key of login options of an account next to its mailboxes
+const loginKey = "login"
+
+// loginOptions are keys of login options
+var loginOptions = map[string]bool{
+	"addr":         true,
+	"user":         true,
+	"pass":         true,
+	"pass_env":     true,
+	"pass_command": true,
+	"oauth_token":  true,
+	"starttls":     true,
+}
+
+// passwordFlags override every password option of accounts if any of them is given
+var passwordFlags = []string{"pass", "pass-env", "pass-command", "secrets"}
+
+// logins are login options of configured accounts
+var logins map[string]loginCfg
+
+// flagGiven tells whether a flag was set on the command line rather than left by default
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == name
+	})
+	return given
+}
+
+// accountLogin returns login options of an account with omitted ones and ones
+// overridden on the command line taken from flags. Passwords of accounts are
+// left out if password flags are given, so that readPassword uses the flags.
+func accountLogin(account string) loginCfg {
+	l := logins[account]
+	if l.Addr == "" || flagGiven("addr") {
+		l.Addr = *addrArg
+	}
+	if l.User == "" {
+		l.User = account
+	}
+	for _, name := range passwordFlags {
+		if flagGiven(name) {
+			l.Pass, l.PassEnv, l.PassCommand = "", "", ""
+		}
+	}
+	if l.OAuthToken == "" || flagGiven("oauth-token") {
+		l.OAuthToken = *oauthTokenArg
+	}
+	if flagGiven("starttls") {
+		l.StartTLS = *starttlsArg
+	}
+	return l
+}
+
+// takeLogins removes login keys from accounts of the YAML tree and returns their options by account
+func takeLogins(doc *yaml.Node) (map[string]loginCfg, error) {
+	root := doc
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	accounts := mappingValue(root, "accounts")
+	if accounts == nil || accounts.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	res := map[string]loginCfg{}
+	for i := 0; i+1 < len(accounts.Content); i += 2 {
+		account, mboxes := accounts.Content[i].Value, accounts.Content[i+1]
+		if mboxes.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(mboxes.Content); j += 2 {
+			if mboxes.Content[j].Value != loginKey {
+				continue
+			}
+			options := mboxes.Content[j+1]
+			location := fmt.Sprintf("accounts.%s.%s", account, loginKey)
+			if options.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%w: %s: must be a mapping", errBadConfig, location)
+			}
+			for k := 0; k+1 < len(options.Content); k += 2 {
+				key, value := options.Content[k].Value, options.Content[k+1]
+				if !loginOptions[key] {
+					return nil, fmt.Errorf("%w: %s: unknown option %s", errBadConfig, location, key)
+				}
+				if value.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%w: %s.%s: must be a string", errBadConfig, location, key)
+				}
+			}
+			var l loginCfg
+			if err := options.Decode(&l); err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", errBadConfig, location, err)
+			}
+			res[account] = l
+			mboxes.Content = append(mboxes.Content[:j:j], mboxes.Content[j+2:]...)
+			break
+		}
+	}
+	return res, nil
+}
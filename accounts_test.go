@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fetchAccountsShouldKeyStatsByAccount(t *testing.T) {
+	withReport(t, "")
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		"foo@bar.com":    {"INBOX": {}},
+		"other@bar.com":  {"INBOX": {}},
+		"broken@bar.com": {"INBOX": {}},
+	}}
+	filter, err := parseFilter("*_count>1")
+	require.NoError(t, err)
+
+	var fetched []string
+	st, err := fetchAccounts(cfg, filter, func(*config) (stats, error) {
+		fetched = append(fetched, *userArg)
+		assert.True(t, allAccounts())
+		if *userArg == "broken@bar.com" {
+			return nil, errors.New("boom")
+		}
+		return stats{"unseen_count": len(fetched) - 1}, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"broken@bar.com", "foo@bar.com", "other@bar.com"}, fetched)
+	assert.Equal(t, stats{
+		"broken@bar.com": stats{outputErrorKey: "boom"},
+		"foo@bar.com":    stats{},
+		"other@bar.com":  stats{"unseen_count": 2},
+	}, st)
+	assert.Equal(t, "", *userArg)
+	assert.Len(t, report.Warnings, 1)
+}
+
+func Test_fetchAccountsShouldFailIfEveryAccountFails(t *testing.T) {
+	withReport(t, "")
+	cfg := &config{Accounts: map[string]map[string]statsConfig{"foo@bar.com": {"INBOX": {}}}}
+
+	_, err := fetchAccounts(cfg, nil, func(*config) (stats, error) { return nil, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+
+	_, err = fetchAccounts(&config{}, nil, func(*config) (stats, error) { return stats{}, nil })
+	assert.Error(t, err)
+}
+
+func Test_cacheFilenameShouldNotUseEmptyAccount(t *testing.T) {
+	defer func(user string) { *userArg = user }(*userArg)
+
+	*userArg = ""
+	assert.Equal(t, filepath.Join(profileCacheDir(), "_all.INBOX"), cacheFilename())
+	*userArg = "foo@bar.com"
+	assert.Equal(t, filepath.Join(profileCacheDir(), "foo@bar.com.INBOX"), cacheFilename())
+}
+
+func Test_flatStatsShouldPrefixKeysWithAccount(t *testing.T) {
+	defer func(user string) { *userArg = user }(*userArg)
+	*userArg = ""
+
+	actual := flatStats(stats{
+		"foo@bar.com":   stats{"unseen_count": 1},
+		"other@bar.com": map[string]interface{}{"unseen_count": 2.0},
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"foo@bar.com.INBOX.unseen_count":   1,
+		"other@bar.com.INBOX.unseen_count": 2.0,
+	}, actual)
+}
+
+func Test_loadConfigShouldTakeLoginsOfAccounts(t *testing.T) {
+	cfg, err := loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    login:
+      addr: imap.bar.com:993
+      user: foo
+      pass: /run/secrets/foo
+    INBOX:
+      boss_count:
+  other@baz.com:
+    INBOX:
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]loginCfg{
+		"foo@bar.com": {Addr: "imap.bar.com:993", User: "foo", Pass: "/run/secrets/foo"},
+	}, cfg.Logins)
+	assert.Equal(t, map[string]statsConfig{"INBOX": {"boss_count": nil}}, cfg.Accounts["foo@bar.com"])
+
+	_, err = loadConfigFrom(t, "accounts:\n  foo@bar.com:\n    login:\n      addr: [imap.bar.com]\n")
+	assert.EqualError(t, err, "bad config: accounts.foo@bar.com.login.addr: must be a string")
+
+	_, err = loadConfigFrom(t, "accounts:\n  foo@bar.com:\n    login:\n      host: imap.bar.com\n")
+	assert.EqualError(t, err, "bad config: accounts.foo@bar.com.login: unknown option host")
+
+	_, err = loadConfigFrom(t, "accounts:\n  foo@bar.com:\n    login: imap.bar.com\n")
+	assert.EqualError(t, err, "bad config: accounts.foo@bar.com.login: must be a mapping")
+}
+
+func Test_accountLoginShouldFallBackToFlags(t *testing.T) {
+	defer func(l map[string]loginCfg, addr, pass string) {
+		logins, *addrArg, *passwordArg = l, addr, pass
+	}(logins, *addrArg, *passwordArg)
+	logins = map[string]loginCfg{"foo@bar.com": {Addr: "imap.bar.com:993", User: "foo"}}
+	*addrArg, *passwordArg = "imap.gmail.com:993", "/tmp/pass"
+
+	assert.Equal(t, loginCfg{Addr: "imap.bar.com:993", User: "foo"}, accountLogin("foo@bar.com"))
+	assert.Equal(t, loginCfg{Addr: "imap.gmail.com:993", User: "other@baz.com"}, accountLogin("other@baz.com"))
+}
+
+// withGivenFlags sets flags as given on the command line of a fresh flag set sharing values with flag.CommandLine
+func withGivenFlags(t *testing.T, given map[string]string) {
+	orig := flag.CommandLine
+	fs := flag.NewFlagSet(orig.Name(), flag.ContinueOnError)
+	values := map[string]string{}
+	orig.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+		values[f.Name] = f.Value.String()
+	})
+	flag.CommandLine = fs
+	t.Cleanup(func() {
+		for name := range given {
+			require.NoError(t, fs.Set(name, values[name]))
+		}
+		flag.CommandLine = orig
+	})
+	for name, value := range given {
+		require.NoError(t, fs.Set(name, value))
+	}
+}
+
+func Test_accountLoginShouldBeOverriddenByGivenFlags(t *testing.T) {
+	defer func(l map[string]loginCfg) { logins = l }(logins)
+	logins = map[string]loginCfg{"foo@bar.com": {Addr: "imap.bar.com:993", User: "foo", PassEnv: "FOO_PASS", StartTLS: true}}
+
+	withGivenFlags(t, map[string]string{"addr": "localhost:993", "pass": "/tmp/pass", "starttls": "false"})
+
+	assert.Equal(t, loginCfg{Addr: "localhost:993", User: "foo"}, accountLogin("foo@bar.com"))
+}
+
+func Test_readPasswordShouldPreferPassOfAccount(t *testing.T) {
+	defer func(l map[string]loginCfg) { logins = l }(logins)
+	path := filepath.Join(t.TempDir(), "pass")
+	require.NoError(t, ioutil.WriteFile(path, []byte("s3cret\n"), 0600))
+	withSecrets(t, "foo@bar.com: secret1\n", 0600)
+	logins = map[string]loginCfg{"foo@bar.com": {Pass: path}}
+
+	actual, err := readPassword("foo@bar.com")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", actual)
+}
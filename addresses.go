@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	groupByFrom = "from"
+
+	// values of from_address picking addresses of multi-From messages
+	fromFirst = "first"
+	fromLast  = "last"
+	fromAll   = "all"
+)
+
+// normalizeAddress returns a lower case mailbox@host address; empty for group syntax entries
+func normalizeAddress(a *imap.Address) string {
+	if a == nil || a.MailboxName == "" || a.HostName == "" {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(a.MailboxName) + "@" + strings.TrimSpace(a.HostName))
+}
+
+// fromAddresses returns normalized From addresses of m picked by from_address, first by default
+func (cr *criteriaCfg) fromAddresses(m *imap.Message) []string {
+	return cr.pickFrom(m, normalizeAddress)
+}
+
+// fromSenders returns From senders of m like Name <mailbox@host> picked by from_address
+func (cr *criteriaCfg) fromSenders(m *imap.Message) []string {
+	return cr.pickFrom(m, formatSender)
+}
+
+// pickFrom formats From addresses of m with format and picks them by from_address.
+// Addresses format returns empty for are skipped.
+func (cr *criteriaCfg) pickFrom(m *imap.Message, format func(*imap.Address) string) []string {
+	var all []string
+	if m.Envelope != nil {
+		for _, a := range m.Envelope.From {
+			if addr := format(a); addr != "" {
+				all = append(all, addr)
+			}
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	switch cr.FromAddress {
+	case fromAll:
+		return all
+	case fromLast:
+		return all[len(all)-1:]
+	}
+	return all[:1]
+}
+
+// formatSender returns the normalized address of a with its display name if any,
+// e.g. Boss <boss@corp.com>
+func formatSender(a *imap.Address) string {
+	addr := normalizeAddress(a)
+	if addr == "" {
+		return ""
+	}
+	name := strings.TrimSpace(a.PersonalName)
+	if name == "" {
+		return addr
+	}
+	return name + " <" + addr + ">"
+}
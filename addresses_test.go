@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fromAddressesShouldPickBySelectionMode(t *testing.T) {
+	given := &imap.Message{Envelope: &imap.Envelope{From: []*imap.Address{
+		{PersonalName: "Boss", MailboxName: "Boss", HostName: "Corp.com"},
+		{MailboxName: "undisclosed-recipients"}, // group syntax
+		{MailboxName: " assistant", HostName: "corp.com "},
+	}}}
+	var tests = []struct {
+		mode     string
+		expected []string
+	}{
+		{"", []string{"boss@corp.com"}},
+		{fromFirst, []string{"boss@corp.com"}},
+		{fromLast, []string{"assistant@corp.com"}},
+		{fromAll, []string{"boss@corp.com", "assistant@corp.com"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.mode, func(t *testing.T) {
+			cr := &criteriaCfg{FromAddress: tt.mode}
+			assert.Equal(t, tt.expected, cr.fromAddresses(given))
+		})
+	}
+	assert.Empty(t, (&criteriaCfg{}).fromAddresses(&imap.Message{Envelope: &imap.Envelope{}}))
+}
+
+func Test_newLetterShouldListPickedFromAddresses(t *testing.T) {
+	given := &imap.Message{Envelope: &imap.Envelope{
+		Subject: "hi",
+		From: []*imap.Address{
+			{MailboxName: "a", HostName: "foo.com"},
+			{MailboxName: "B", HostName: "foo.com"},
+		},
+	}}
+
+	assert.Equal(t, "a@foo.com", newLetter(given, &criteriaCfg{}).From)
+	assert.Equal(t, "b@foo.com", newLetter(given, &criteriaCfg{FromAddress: fromLast}).From)
+	assert.Equal(t, "a@foo.com, b@foo.com", newLetter(given, &criteriaCfg{FromAddress: fromAll}).From)
+}
+
+func Test_newLetterShouldFormatFromWithName(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+		given    []*imap.Address
+	}{
+		{"name",
+			"Boss <boss@corp.com>",
+			[]*imap.Address{{PersonalName: " Boss ", MailboxName: "Boss", HostName: "corp.com"}}},
+		{"group syntax skipped",
+			"a@foo.com",
+			[]*imap.Address{{PersonalName: "team"}, {MailboxName: "a", HostName: "foo.com"}}},
+		{"no from",
+			"",
+			nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			given := &imap.Message{Envelope: &imap.Envelope{From: tt.given}}
+			assert.Equal(t, tt.expected, newLetter(given, &criteriaCfg{}).From)
+		})
+	}
+}
+
+func Test_collectStatsShouldGroupByFrom(t *testing.T) {
+	c := newTestClient(t, "foo")
+	msg := "From: Boss <boss@corp.com>, foo@BAR.com\r\nSubject: bar\r\n\r\nhello"
+	require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)))
+	_, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+
+	st, err := collectStats(c, statsConfig{
+		"first_count": &criteriaCfg{GroupBy: groupByFrom},
+		"all_count":   &criteriaCfg{GroupBy: groupByFrom, FromAddress: fromAll},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"foo@bar.com": 1, "boss@corp.com": 1}, st["first_count_by_from"])
+	assert.Equal(t, map[string]int{"foo@bar.com": 2, "boss@corp.com": 1}, st["all_count_by_from"])
+}
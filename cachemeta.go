@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheMetaKey keeps what the next run needs to know of cached stats of a mailbox
+// next to them. It is kept in the cache only and never output.
+const cacheMetaKey = "_cache"
+
+// cachedCriterion tells until when stats of a criterion with its own ttl are fresh
+type cachedCriterion struct {
+	TTL     string    `json:"ttl"`
+	Expires time.Time `json:"expires"`
+	// Keys are stats collected for the criterion
+	Keys []string `json:"keys"`
+}
+
+// cacheMeta is kept in the cache next to stats of a mailbox
+type cacheMeta struct {
+	// Criteria are criteria that have their own ttl by key
+	Criteria map[string]*cachedCriterion `json:"criteria,omitempty"`
+	// UIDValidity is UIDVALIDITY of the mailbox the stats were collected with
+	UIDValidity uint32 `json:"uid_validity,omitempty"`
+}
+
+// recordCriterion keeps the ttl of criterion k next to stats collected for it,
+// so that runs with -write-cache reuse them while fresh and -read-cache finds them stale
+func recordCriterion(collected stats, k string, cr *criteriaCfg) {
+	if cr.TTL <= 0 {
+		return
+	}
+	cached := &cachedCriterion{TTL: cr.TTL.String(), Expires: now().Add(cr.TTL)}
+	for key := range collected {
+		if key != newestKey {
+			cached.Keys = append(cached.Keys, key)
+		}
+	}
+	sort.Strings(cached.Keys)
+	collected[cacheMetaKey] = &cacheMeta{Criteria: map[string]*cachedCriterion{k: cached}}
+}
+
+// mergeCacheMeta adds meta of collected stats to meta kept in st
+func mergeCacheMeta(st stats, meta *cacheMeta) {
+	res, _ := st[cacheMetaKey].(*cacheMeta)
+	if res == nil {
+		res = &cacheMeta{}
+		st[cacheMetaKey] = res
+	}
+	if meta.UIDValidity != 0 {
+		res.UIDValidity = meta.UIDValidity
+	}
+	for k, cached := range meta.Criteria {
+		if res.Criteria == nil {
+			res.Criteria = map[string]*cachedCriterion{}
+		}
+		res.Criteria[k] = cached
+	}
+}
+
+// metaOf returns meta kept in stats of a mailbox, either collected or decoded from the cache
+func metaOf(st stats) (*cacheMeta, error) {
+	switch v := st[cacheMetaKey].(type) {
+	case nil:
+		return &cacheMeta{}, nil
+	case *cacheMeta:
+		return v, nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		meta := &cacheMeta{}
+		err = json.Unmarshal(b, meta)
+		return meta, err
+	}
+}
+
+// staleCriteria returns account/mailbox/key of criteria in cached stats that outlived their own ttl
+func staleCriteria(st stats) ([]string, error) {
+	var res []string
+	var err error
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		meta, metaErr := metaOf(st)
+		if metaErr != nil {
+			err = metaErr
+			return
+		}
+		for k, cached := range meta.Criteria {
+			if !now().Before(cached.Expires) {
+				res = append(res, strings.Join([]string{account, mailbox, k}, "/"))
+			}
+		}
+	})
+	sort.Strings(res)
+	return res, err
+}
+
+// liveUIDValidity is UIDVALIDITY of mailboxes by account as last fetched by this process
+var liveUIDValidity = struct {
+	sync.Mutex
+	accounts map[string]map[string]uint32
+}{accounts: map[string]map[string]uint32{}}
+
+// knowUIDValidity records live UIDVALIDITY of a mailbox
+func knowUIDValidity(account string, mailbox string, live uint32) {
+	liveUIDValidity.Lock()
+	defer liveUIDValidity.Unlock()
+	if liveUIDValidity.accounts[account] == nil {
+		liveUIDValidity.accounts[account] = map[string]uint32{}
+	}
+	liveUIDValidity.accounts[account][mailbox] = live
+}
+
+// changedUIDValidity returns account/mailbox of cached stats collected with other
+// UIDVALIDITY than a live one known to this process. Bad meta is left to staleCriteria.
+func changedUIDValidity(st stats) []string {
+	liveUIDValidity.Lock()
+	defer liveUIDValidity.Unlock()
+	var res []string
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		meta, err := metaOf(st)
+		if err != nil || meta.UIDValidity == 0 {
+			return
+		}
+		live, known := liveUIDValidity.accounts[account][mailbox]
+		if known && live != meta.UIDValidity {
+			res = append(res, account+"/"+mailbox)
+		}
+	})
+	sort.Strings(res)
+	return res
+}
+
+// cachedMailbox is stats of a mailbox the previous run kept in the cache
+type cachedMailbox map[string]json.RawMessage
+
+// readCachedMailbox reads stats of a mailbox from a cache file; a missing cache has none.
+// Stats nested in the cache are found by the keys they are nested under like account or mailbox.
+func readCachedMailbox(filename string, nested ...string) (cachedMailbox, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, key := range nested {
+		var parent map[string]json.RawMessage
+		if err := json.Unmarshal(b, &parent); err != nil {
+			warnf("can not read cache %s: %s", filename, err)
+			return nil, nil
+		}
+		if b = parent[key]; b == nil {
+			return nil, nil
+		}
+	}
+	var res cachedMailbox
+	if err := json.Unmarshal(b, &res); err != nil {
+		warnf("can not read cache %s: %s", filename, err)
+		return nil, nil
+	}
+	return res, nil
+}
+
+func (m cachedMailbox) meta() *cacheMeta {
+	meta := &cacheMeta{}
+	if b, found := m[cacheMetaKey]; found {
+		if err := json.Unmarshal(b, meta); err != nil {
+			warnf("ignoring bad cache meta: %s", err)
+			return &cacheMeta{}
+		}
+	}
+	return meta
+}
+
+// checkUIDValidity returns m unless it was cached with other UIDVALIDITY than the live one.
+// Messages may have got other UIDs since, so nothing cached for the mailbox is valid then.
+func (m cachedMailbox) checkUIDValidity(mailbox string, live uint32) cachedMailbox {
+	cached := m.meta().UIDValidity
+	if cached == 0 || cached == live {
+		return m
+	}
+	warnf("%s: UIDVALIDITY changed from %d to %d; ignoring cached stats", mailbox, cached, live)
+	return nil
+}
+
+// reuse returns cached stats of criteria of cfg that have their own ttl and are still fresh
+// along with cfg of the remaining criteria to collect
+func (m cachedMailbox) reuse(cfg statsConfig) (stats, statsConfig) {
+	reused, rest := stats{}, statsConfig{}
+	meta, newest := m.meta(), m.newest()
+	for k, cr := range cfg {
+		cached := meta.Criteria[k]
+		if cr == nil || cr.TTL <= 0 || cached == nil || cached.TTL != cr.TTL.String() || !now().Before(cached.Expires) {
+			rest[k] = cr
+			continue
+		}
+		st, err := m.decode(cached.Keys)
+		if err != nil {
+			warnf("collecting %s again: bad cached stats: %s", k, err)
+			rest[k] = cr
+			continue
+		}
+		debugf("%s: reusing cached stats until %s", k, cached.Expires.Format(time.RFC3339))
+		if d, found := newest[k]; found {
+			mergeNewest(st, map[string]time.Time{k: d})
+		}
+		st[cacheMetaKey] = &cacheMeta{Criteria: map[string]*cachedCriterion{k: cached}}
+		mergeStats(reused, st)
+	}
+	return reused, rest
+}
+
+// decode restores types of cached stats of keys as far as the output needs them
+func (m cachedMailbox) decode(keys []string) (stats, error) {
+	st := stats{}
+	for _, k := range keys {
+		b, found := m[k]
+		if !found {
+			return nil, fmt.Errorf("%s: not cached", k)
+		}
+		v, err := decodeCachedValue(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		st[k] = v
+	}
+	return st, nil
+}
+
+func decodeCachedValue(b json.RawMessage) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	switch val := v.(type) {
+	case float64:
+		if val == math.Trunc(val) {
+			return int(val), nil
+		}
+		return val, nil
+	case []interface{}:
+		var letters []*letter
+		err := json.Unmarshal(b, &letters)
+		return letters, err
+	case map[string]interface{}:
+		if _, found := val["messages"]; found {
+			var fetched fetchedStat
+			err := json.Unmarshal(b, &fetched)
+			return &fetched, err
+		}
+		var counts map[string]int
+		if err := json.Unmarshal(b, &counts); err == nil {
+			return counts, nil
+		}
+	}
+	return v, nil
+}
+
+// cacheOnlyKeys are kept in the cache for the next run but not output
+var cacheOnlyKeys = map[string]bool{cacheMetaKey: true, newestKey: true}
+
+// withoutCacheOnly returns a copy of st without cacheOnlyKeys, nested stats included
+func withoutCacheOnly(st stats) stats {
+	res := make(stats, len(st))
+	for k, v := range st {
+		if cacheOnlyKeys[k] {
+			continue
+		}
+		switch nested := v.(type) {
+		case stats:
+			v = withoutCacheOnly(nested)
+		case map[string]interface{}:
+			v = map[string]interface{}(withoutCacheOnly(nested))
+		}
+		res[k] = v
+	}
+	return res
+}
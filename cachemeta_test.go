@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_recordCriterionShouldKeepOnlyCriteriaWithTTL(t *testing.T) {
+	defer func() { now = time.Now }()
+	collectedAt := time.Date(2021, 6, 10, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return collectedAt }
+
+	st := stats{}
+	for k, cr := range map[string]*criteriaCfg{
+		"unseen_count":  {},
+		"archive_count": {TTL: time.Hour},
+	} {
+		collected := stats{k: 1, k + "_messages": []*letter{}}
+		recordCriterion(collected, k, cr)
+		mergeStats(st, collected)
+	}
+
+	assert.Equal(t, &cacheMeta{Criteria: map[string]*cachedCriterion{
+		"archive_count": {
+			TTL:     "1h0m0s",
+			Expires: collectedAt.Add(time.Hour),
+			Keys:    []string{"archive_count", "archive_count_messages"},
+		},
+	}}, st[cacheMetaKey])
+}
+
+func Test_cachedMailboxShouldReuseFreshCriteriaWithTTL(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func(user, mbox string) {
+		*writeCacheArg, *quietArg, *userArg, *mboxArg = false, false, user, mbox
+		now = time.Now
+	}(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+	collectedAt := time.Date(2021, 6, 10, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return collectedAt }
+	cfg := statsConfig{
+		"archive_count": &criteriaCfg{TTL: time.Hour},
+		"unseen_count":  &criteriaCfg{},
+	}
+
+	archived := stats{
+		"archive_count":          2,
+		"archive_count_messages": []*letter{{Subject: "foo"}},
+		"archive_count_by_from":  map[string]int{"a@b.com": 2},
+		newestKey:                map[string]time.Time{"archive_count": collectedAt},
+	}
+	recordCriterion(archived, "archive_count", cfg["archive_count"])
+	st := stats{"unseen_count": 1}
+	mergeStats(st, archived)
+	require.NoError(t, writeStats(st))
+
+	now = func() time.Time { return collectedAt.Add(time.Minute) }
+	cached, err := readCachedMailbox(cacheFilename())
+	require.NoError(t, err)
+	reused, rest := cached.reuse(cfg)
+	assert.Equal(t, archived, reused)
+	assert.Equal(t, statsConfig{"unseen_count": cfg["unseen_count"]}, rest)
+
+	reused, rest = cached.reuse(statsConfig{"archive_count": &criteriaCfg{TTL: 2 * time.Hour}})
+	assert.Empty(t, reused, "ttl changed since")
+	assert.Len(t, rest, 1)
+
+	now = func() time.Time { return collectedAt.Add(2 * time.Hour) }
+	reused, rest = cached.reuse(cfg)
+	assert.Empty(t, reused)
+	assert.Equal(t, cfg, rest)
+}
+
+func Test_copyCacheShouldFailOnStaleCriteriaWithoutConfig(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func(user, mbox string) {
+		*writeCacheArg, *quietArg, *userArg, *mboxArg = false, false, user, mbox
+		now = time.Now
+	}(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+	collectedAt := time.Date(2021, 6, 10, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return collectedAt }
+
+	st := stats{"unseen_count": 1}
+	recordCriterion(st, "unseen_count", &criteriaCfg{TTL: time.Minute})
+	require.NoError(t, writeStats(st))
+
+	var buf bytes.Buffer
+	require.NoError(t, copyCache(&buf, ttlInfinite, true))
+	assert.Equal(t, "{\"unseen_count\":1}\n", buf.String())
+
+	now = func() time.Time { return collectedAt.Add(time.Minute) }
+	err := copyCache(&bytes.Buffer{}, ttlInfinite, true)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "foo@bar.com/INBOX/unseen_count")
+	assert.NoError(t, copyCache(&bytes.Buffer{}, ttlInfinite, false))
+}
+
+func withLiveUIDValidity(t *testing.T, account string, mailbox string, live uint32) {
+	orig := liveUIDValidity.accounts
+	liveUIDValidity.accounts = map[string]map[string]uint32{}
+	t.Cleanup(func() { liveUIDValidity.accounts = orig })
+	knowUIDValidity(account, mailbox, live)
+}
+
+func Test_cachedMailboxShouldBeIgnoredOnUIDValidityChange(t *testing.T) {
+	withReport(t, "")
+	cached := cachedMailbox{"unseen_count": []byte("1"), cacheMetaKey: []byte(`{"uid_validity": 42}`)}
+
+	assert.Equal(t, cached, cached.checkUIDValidity("INBOX", 42))
+	assert.Nil(t, cached.checkUIDValidity("INBOX", 43))
+	assert.Len(t, report.Warnings, 1)
+
+	unknown := cachedMailbox{"unseen_count": []byte("1")}
+	assert.Equal(t, unknown, unknown.checkUIDValidity("INBOX", 43))
+}
+
+func Test_copyCacheShouldFailOnChangedLiveUIDValidity(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func(user, mbox string) {
+		*writeCacheArg, *quietArg, *userArg, *mboxArg = false, false, user, mbox
+	}(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+	mbox := imap.NewMailboxStatus("INBOX", nil)
+	mbox.UidValidity = 42
+
+	st := stats{"unseen_count": 1}
+	addUIDValidity(st, mbox, statsConfig{})
+	require.NoError(t, writeStats(st))
+
+	withLiveUIDValidity(t, "other@bar.com", "INBOX", 43)
+	var buf bytes.Buffer
+	require.NoError(t, copyCache(&buf, ttlInfinite, false), "unknown live UIDVALIDITY")
+	assert.JSONEq(t, `{"unseen_count": 1, "uid_validity": 42}`, buf.String())
+
+	withLiveUIDValidity(t, "foo@bar.com", "INBOX", 42)
+	require.NoError(t, copyCache(&bytes.Buffer{}, ttlInfinite, false))
+
+	withLiveUIDValidity(t, "foo@bar.com", "INBOX", 43)
+	err := copyCache(&bytes.Buffer{}, ttlInfinite, false)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "foo@bar.com/INBOX")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// rawSuffix marks the exact value of a capped stat
+const rawSuffix = "_raw"
+
+// capCount returns n if it does not exceed max and a string like 99+ otherwise
+func capCount(n int, max int) interface{} {
+	if n > max {
+		return fmt.Sprintf("%d+", max)
+	}
+	return n
+}
+
+// applyCap caps the count of stat k if cr has cap set, keeping the exact count under <k>_raw
+func applyCap(st stats, k string, cr *criteriaCfg) {
+	if cr.Cap <= 0 {
+		return
+	}
+	n, ok := st[k].(int)
+	if !ok {
+		return
+	}
+	st[k+rawSuffix] = n
+	st[k] = capCount(n, cr.Cap)
+}
+
+// rawStat returns the exact value of stat k even if it is capped
+func rawStat(st stats, k string) (interface{}, bool) {
+	if v, found := st[k+rawSuffix]; found {
+		return v, true
+	}
+	v, found := st[k]
+	return v, found
+}
+
+// mailboxLister lists mailboxes along with their attributes
+type mailboxLister interface {
+	List(ref string, name string, ch chan *imap.MailboxInfo) error
+}
+
+// isJunk tells whether the server marks a mailbox with SPECIAL-USE \Junk attribute
+func isJunk(c mailboxLister, name string) (bool, error) {
+	ch := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", name, ch)
+	}()
+	junk := false
+	for mbox := range ch {
+		if mbox.Name != name {
+			continue
+		}
+		for _, attr := range mbox.Attributes {
+			if strings.EqualFold(attr, imap.JunkAttr) {
+				junk = true
+			}
+		}
+	}
+	return junk, <-done
+}
+
+// junkCapped returns cfg of a mailbox with criteria without cap capped by -junk-cap
+// if the mailbox is the \Junk one, as its counts are rarely interesting exactly
+func junkCapped(c mailboxLister, name string, cfg statsConfig) statsConfig {
+	if *junkCapArg <= 0 {
+		return cfg
+	}
+	junk, err := isJunk(c, name)
+	if err != nil {
+		warnf("%s: not capping counts: can not tell if it is the junk mailbox: %s", name, err)
+		return cfg
+	}
+	if !junk {
+		return cfg
+	}
+	res := statsConfig{}
+	for k, cr := range cfg {
+		if cr != nil && cr.Cap == 0 {
+			capped := *cr
+			capped.Cap = *junkCapArg
+			cr = &capped
+		}
+		res[k] = cr
+	}
+	return res
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_capCount(t *testing.T) {
+	var tests = []struct {
+		given    int
+		max      int
+		expected interface{}
+	}{
+		{0, 99, 0},
+		{98, 99, 98},
+		{99, 99, 99},
+		{100, 99, "99+"},
+		{123456, 999, "999+"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprint(tt.given, "/", tt.max), func(t *testing.T) {
+			assert.Equal(t, tt.expected, capCount(tt.given, tt.max))
+		})
+	}
+}
+
+func Test_collectStatsShouldCapCounts(t *testing.T) {
+	underTest := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		if sc.Header.Get("Subject") == "few" {
+			return []uint32{1}, nil
+		}
+		return []uint32{1, 2, 3, 4, 5}, nil
+	}}
+	cfg := &config{Computed: map[string]string{"spam_total": "spam_count + few_count"}}
+
+	st, err := collectStats(underTest, statsConfig{
+		"spam_count": &criteriaCfg{Cap: 3},
+		"few_count":  &criteriaCfg{Cap: 3, Headers: map[string]string{"Subject": "few"}},
+	}, nil)
+	require.NoError(t, err)
+	cfg.compute(st)
+
+	assert.Equal(t, stats{
+		"spam_count":     "3+",
+		"spam_count_raw": 5,
+		"few_count":      1,
+		"few_count_raw":  1,
+		"spam_total":     float64(6),
+	}, st)
+}
+
+// fakeLister lists mailboxes with given attributes by name
+type fakeLister map[string][]string
+
+func (l fakeLister) List(ref string, name string, ch chan *imap.MailboxInfo) error {
+	defer close(ch)
+	for mbox, attrs := range l {
+		if mbox == name {
+			ch <- &imap.MailboxInfo{Name: mbox, Attributes: attrs}
+		}
+	}
+	return nil
+}
+
+func Test_junkCappedShouldCapCriteriaOfJunkMailbox(t *testing.T) {
+	defer func(orig int) { *junkCapArg = orig }(*junkCapArg)
+	lister := fakeLister{"Spam": {imap.JunkAttr}, "INBOX": {}}
+	own := &criteriaCfg{Cap: 10}
+	cfg := statsConfig{"unseen_count": &criteriaCfg{}, "own_count": own}
+
+	actual := junkCapped(lister, "Spam", cfg)
+	assert.Equal(t, statsConfig{"unseen_count": &criteriaCfg{Cap: defaultJunkCap}, "own_count": own}, actual)
+	assert.Zero(t, cfg["unseen_count"].Cap, "config is kept")
+
+	assert.Equal(t, cfg, junkCapped(lister, "INBOX", cfg))
+
+	*junkCapArg = 0
+	assert.Equal(t, cfg, junkCapped(lister, "Spam", cfg))
+}
+
+func Test_collectStatsShouldReportJunkCountsCapped(t *testing.T) {
+	defer func(orig int) { *junkCapArg = orig }(*junkCapArg)
+	*junkCapArg = 3
+	underTest := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		return []uint32{1, 2, 3, 4, 5}, nil
+	}}
+
+	st, err := collectStats(underTest,
+		junkCapped(fakeLister{"Junk": {`\junk`}}, "Junk", statsConfig{"spam_count": &criteriaCfg{AnySeen: true}}), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, stats{"spam_count": "3+", "spam_count_raw": 5}, st)
+}
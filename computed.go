@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var errDivisionByZero = errors.New("division by zero")
+
+// expr is a parsed arithmetic expression over stat keys
+type expr interface {
+	eval(st stats) (float64, error)
+}
+
+type number float64
+
+func (n number) eval(stats) (float64, error) {
+	return float64(n), nil
+}
+
+type statRef string
+
+func (r statRef) eval(st stats) (float64, error) {
+	v, found := rawStat(st, string(r))
+	if !found {
+		return 0, fmt.Errorf("unknown stat: %s", r)
+	}
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case uint32:
+		return float64(val), nil
+	}
+	n, ok := count(v)
+	if !ok {
+		return 0, fmt.Errorf("stat is not a number: %s", r)
+	}
+	return float64(n), nil
+}
+
+type binaryOp struct {
+	op          byte
+	left, right expr
+}
+
+func (b *binaryOp) eval(st stats) (float64, error) {
+	l, err := b.left.eval(st)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.right.eval(st)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	}
+	if r == 0 {
+		return 0, errDivisionByZero
+	}
+	return l / r, nil
+}
+
+// parseExpr parses expressions like (a + b) / total * 100 where identifiers refer to stat keys
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{src: s}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos], p.pos)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// next returns the next operator char without consuming it; 0 if there is none
+func (p *exprParser) next() byte {
+	p.skipSpaces()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryOp{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryOp{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseOperand() (expr, error) {
+	c := p.next()
+	switch {
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return e, nil
+	case c == '-':
+		p.pos++
+		e, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryOp{'-', number(0), e}, nil
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	tok := p.src[start:p.pos]
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected %q at %d", c, start)
+	}
+	if unicode.IsDigit(rune(tok[0])) {
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", tok, start)
+		}
+		return number(n), nil
+	}
+	return statRef(tok), nil
+}
+
+func isIdentChar(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// compute adds computed stats to st. A stat that can not be computed,
+// e.g. because of division by zero, is reported as <name>_error instead.
+func (c *config) compute(st stats) {
+	names := make([]string, 0, len(c.Computed))
+	for name := range c.Computed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e, err := parseExpr(c.Computed[name])
+		if err == nil {
+			var v float64
+			if v, err = e.eval(st); err == nil {
+				st[name] = v
+				continue
+			}
+		}
+		warnf("computed %s: %s", name, err)
+		st[name+"_error"] = err.Error()
+	}
+}
+
+// lintComputed reports computed stats with expressions that do not parse
+func (c *config) lintComputed() []configProblem {
+	problems := []configProblem{}
+	for name, src := range c.Computed {
+		if strings.TrimSpace(src) == "" {
+			problems = append(problems, configProblem{location: "computed." + name, msg: "expression must not be empty"})
+			continue
+		}
+		if _, err := parseExpr(src); err != nil {
+			problems = append(problems, configProblem{location: "computed." + name, msg: err.Error()})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].location < problems[j].location })
+	return problems
+}
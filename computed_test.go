@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseExprShouldEvaluate(t *testing.T) {
+	given := stats{
+		"unseen_count":    3,
+		"total_count":     12,
+		"important_count": &fetchedStat{Count: 2},
+		"empty_count":     0,
+	}
+	var tests = []struct {
+		expr     string
+		expected float64
+	}{
+		{"unseen_count / total_count", 0.25},
+		{"unseen_count + important_count * 2", 7},
+		{"(unseen_count + important_count) * 2", 10},
+		{"100 * unseen_count / total_count", 25},
+		{"total_count - unseen_count - 1", 8},
+		{"-unseen_count + 1.5", -1.5},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := parseExpr(tt.expr)
+			require.NoError(t, err)
+			actual, err := e.eval(given)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_parseExprShouldFailOnSyntaxErrors(t *testing.T) {
+	var tests = []struct {
+		given    string
+		expected string
+	}{
+		{"a +", "unexpected end of expression"},
+		{"(a + b", "missing ) at 6"},
+		{"a b", `unexpected 'b' at 2`},
+		{"a % b", `unexpected '%' at 2`},
+		{"1.2.3", `bad number "1.2.3" at 0`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			_, err := parseExpr(tt.given)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func Test_configComputeShouldAddComputedStats(t *testing.T) {
+	withReport(t, "")
+	cfg := &config{Computed: map[string]string{
+		"unread_ratio": "unseen_count / total_count",
+		"empty_ratio":  "unseen_count / empty_count",
+		"bad":          "no_such_count + 1",
+	}}
+	st := stats{"unseen_count": 3, "total_count": 12, "empty_count": 0}
+
+	cfg.compute(st)
+
+	assert.Equal(t, stats{
+		"unseen_count":      3,
+		"total_count":       12,
+		"empty_count":       0,
+		"unread_ratio":      0.25,
+		"empty_ratio_error": "division by zero",
+		"bad_error":         "unknown stat: no_such_count",
+	}, st)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// collectStatsConcurrently spreads criteria over up to workers connections.
+// The first worker uses c and redial like collectStats, others open their own
+// connections with dial. A worker that can not connect leaves criteria to others.
+// The first error cancels criteria that are not started yet.
+func collectStatsConcurrently(c imapClient, cfg statsConfig, redial func() (imapClient, error),
+	dial func() (imapClient, error), workers int) (stats, error) {
+	if workers > len(cfg) {
+		workers = len(cfg)
+	}
+	if workers <= 1 || dial == nil {
+		return collectStats(c, cfg, redial)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() { firstErr = err })
+		cancel()
+	}
+	st := stats{}
+	keys := queueKeys(cfg)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(first bool) {
+			defer wg.Done()
+			conn, connRedial := c, redial
+			if !first {
+				if len(keys) == 0 {
+					// others took all criteria while this worker was starting
+					return
+				}
+				var err error
+				if conn, err = dial(); err != nil {
+					// others still collect all criteria
+					warnf("worker can not connect, collecting with fewer connections: %s", err)
+					return
+				}
+				connRedial = dial
+			}
+			if err := collectKeys(ctx, conn, cfg, keys, connRedial, st, &mu); err != nil {
+				fail(err)
+			}
+		}(i == 0)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return st, nil
+}
+
+// workers returns the number of connections to collect stats with according to
+// -concurrency bounded by -max-connections. Connections kept between cycles
+// are one per account, so -daemon and -serve collect over that one only rather
+// than logging workers in every cycle.
+func workers() int {
+	if keptConns != nil {
+		return 1
+	}
+	n := *concurrencyArg
+	if *maxConnectionsArg > 0 && n > *maxConnectionsArg {
+		n = *maxConnectionsArg
+	}
+	return n
+}
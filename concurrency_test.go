@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowClient answers searches after a delay with as many ids as the Subject criteria has characters
+func slowClient(searches *int32, fail string) *fakeClient {
+	return &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		atomic.AddInt32(searches, 1)
+		time.Sleep(20 * time.Millisecond)
+		subj := sc.Header.Get("Subject")
+		if subj == fail {
+			return nil, errors.New("boom")
+		}
+		return make([]uint32, len(subj)), nil
+	}}
+}
+
+func subjectCriteria(n int) statsConfig {
+	cfg := statsConfig{}
+	for i := 0; i < n; i++ {
+		subj := fmt.Sprintf("%0*d", i+1, 0)
+		cfg[fmt.Sprintf("k%d", i+1)] = &criteriaCfg{Headers: map[string]string{"Subject": subj}}
+	}
+	return cfg
+}
+
+func Test_collectStatsConcurrentlyShouldMergeStatsOfAllWorkers(t *testing.T) {
+	var searches, dials int32
+	dial := func() (imapClient, error) {
+		atomic.AddInt32(&dials, 1)
+		return slowClient(&searches, ""), nil
+	}
+
+	started := time.Now()
+	st, err := collectStatsConcurrently(slowClient(&searches, ""), subjectCriteria(8), nil, dial, 4)
+	require.NoError(t, err)
+
+	assert.Equal(t, stats{"k1": 1, "k2": 2, "k3": 3, "k4": 4, "k5": 5, "k6": 6, "k7": 7, "k8": 8}, st)
+	assert.Equal(t, int32(8), searches)
+	assert.Equal(t, int32(3), dials)
+	assert.Less(t, int64(time.Since(started)), int64(8*20*time.Millisecond))
+}
+
+func Test_collectStatsConcurrentlyShouldStopOnFirstError(t *testing.T) {
+	var searches int32
+	// every search fails as keys are taken in no particular order
+	failing := func() *fakeClient {
+		return &fakeClient{search: func(*imap.SearchCriteria) ([]uint32, error) {
+			atomic.AddInt32(&searches, 1)
+			time.Sleep(20 * time.Millisecond)
+			return nil, errors.New("boom")
+		}}
+	}
+	dial := func() (imapClient, error) { return failing(), nil }
+
+	st, err := collectStatsConcurrently(failing(), subjectCriteria(20), nil, dial, 2)
+	assert.EqualError(t, err, "boom")
+	assert.Nil(t, st)
+	assert.LessOrEqual(t, searches, int32(2))
+}
+
+func Test_collectStatsConcurrentlyShouldFallBackToFewerWorkersIfWorkerCanNotConnect(t *testing.T) {
+	var searches int32
+	dial := func() (imapClient, error) { return nil, errors.New("no connection") }
+
+	st, err := collectStatsConcurrently(slowClient(&searches, ""), subjectCriteria(4), nil, dial, 2)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"k1": 1, "k2": 2, "k3": 3, "k4": 4}, st)
+}
+
+func Test_collectStatsConcurrentlyShouldUseOneConnectionForOneWorker(t *testing.T) {
+	var searches int32
+	dial := func() (imapClient, error) {
+		t.Fatal("unexpected dial")
+		return nil, nil
+	}
+
+	st, err := collectStatsConcurrently(slowClient(&searches, ""), subjectCriteria(2), nil, dial, 1)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"k1": 1, "k2": 2}, st)
+}
+
+func Test_workersShouldBeBoundedByMaxConnections(t *testing.T) {
+	defer func(concurrency, max int) {
+		*concurrencyArg, *maxConnectionsArg = concurrency, max
+	}(*concurrencyArg, *maxConnectionsArg)
+
+	*concurrencyArg, *maxConnectionsArg = 4, 0
+	assert.Equal(t, 4, workers())
+
+	*maxConnectionsArg = 2
+	assert.Equal(t, 2, workers())
+
+	*maxConnectionsArg = 8
+	assert.Equal(t, 4, workers())
+}
+
+func Test_workersShouldBeOneIfConnectionsAreKept(t *testing.T) {
+	defer func(concurrency int) { *concurrencyArg, keptConns = concurrency, nil }(*concurrencyArg)
+
+	*concurrencyArg = 4
+	keptConns = map[string]*client.Client{}
+	assert.Equal(t, 1, workers())
+}
+
+// latencyConn delays every response of the server as if it came over a slow link
+type latencyConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *latencyConn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(b)
+}
+
+type latencyListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l *latencyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &latencyConn{Conn: conn, delay: l.delay}, nil
+}
+
+// searchLatency delays every write of server responses in benchmarks like a link to a remote server
+const searchLatency = 5 * time.Millisecond
+
+// Benchmark_searchesOverConnections searches 8 criteria over a link with searchLatency
+// one after another and over 4 connections like -concurrency 4 does. Pipelined searches
+// could at best save the round trips that parallel connections save already:
+//
+//	Benchmark_searchesOverConnections/concurrency=1    20    84ms/op
+//	Benchmark_searchesOverConnections/concurrency=4    20    22ms/op
+func Benchmark_searchesOverConnections(b *testing.B) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	s := server.New(testBackend{memory.New()})
+	s.AllowInsecureAuth = true
+	go s.Serve(&latencyListener{Listener: l, delay: searchLatency})
+	defer s.Close()
+
+	login := func() *client.Client {
+		c, err := client.Dial(l.Addr().String())
+		require.NoError(b, err)
+		require.NoError(b, c.Login("username", "password"))
+		_, err = c.Select("INBOX", false)
+		require.NoError(b, err)
+		return c
+	}
+	cfg := subjectCriteria(8)
+	for _, concurrency := range []int{1, 4} {
+		concurrency := concurrency
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			// connections are dialed once like fetchStats keeps them for every mailbox
+			var conns []*client.Client
+			for i := 0; i < concurrency; i++ {
+				conns = append(conns, login())
+			}
+			defer func() {
+				for _, c := range conns {
+					c.Logout()
+				}
+			}()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// workers dial concurrently
+				idle := make(chan *client.Client, len(conns))
+				for _, c := range conns[1:] {
+					idle <- c
+				}
+				dial := func() (imapClient, error) { return <-idle, nil }
+				_, err := collectStatsConcurrently(conns[0], cfg, nil, dial, concurrency)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envRef is a reference to an environment variable in login options
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv substitutes ${VAR} in login options of accounts with environment variables,
+// e.g. to keep hosts, users and credentials out of version control. Other strings
+// like search criteria and keys are left as is, so $ needs no escaping there.
+func expandEnv(doc *yaml.Node) error {
+	root := doc
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	accounts := mappingValue(root, "accounts")
+	if accounts == nil || accounts.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 1; i < len(accounts.Content); i += 2 {
+		mboxes := accounts.Content[i]
+		if mboxes.Kind != yaml.MappingNode {
+			continue
+		}
+		options := mappingValue(mboxes, loginKey)
+		if options == nil || options.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 1; j < len(options.Content); j += 2 {
+			if err := expandEnvValue(options.Content[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func expandEnvValue(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		return nil
+	}
+	var missing string
+	n.Value = envRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		v, found := os.LookupEnv(name)
+		if !found && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return fmt.Errorf("%w: line %d: environment variable %s is not set", errBadConfig, n.Line, missing)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadConfigShouldExpandEnvironmentVariables(t *testing.T) {
+	os.Setenv("IMAPSTATS_TEST_USER", "foo")
+	os.Setenv("IMAPSTATS_TEST_HOST", "bar.com")
+	defer os.Unsetenv("IMAPSTATS_TEST_USER")
+	defer os.Unsetenv("IMAPSTATS_TEST_HOST")
+
+	cfg, err := loadConfigFrom(t, `
+templates:
+  from_sender:
+    headers:
+      From: ${sender}
+accounts:
+  foo@bar.com:
+    login:
+      addr: imap.${IMAPSTATS_TEST_HOST}:993
+      user: ${IMAPSTATS_TEST_USER}
+      pass_command: echo $IMAPSTATS_TEST_USER
+    INBOX:
+      price_count:
+        body: [$100, "${IMAPSTATS_TEST_HOST}"]
+      boss_count:
+        use: from_sender
+        args:
+          sender: boss@${IMAPSTATS_TEST_HOST}
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, loginCfg{
+		Addr:        "imap.bar.com:993",
+		User:        "foo",
+		PassCommand: "echo $IMAPSTATS_TEST_USER",
+	}, cfg.Logins["foo@bar.com"])
+	inbox := cfg.Accounts["foo@bar.com"]["INBOX"]
+	assert.Equal(t, []string{"$100", "${IMAPSTATS_TEST_HOST}"}, inbox["price_count"].Body)
+	assert.Equal(t, map[string]string{"sender": "boss@${IMAPSTATS_TEST_HOST}"}, inbox["boss_count"].Args)
+}
+
+func Test_loadConfigShouldFailOnUnsetEnvironmentVariables(t *testing.T) {
+	os.Unsetenv("IMAPSTATS_TEST_MISSING")
+
+	_, err := loadConfigFrom(t, "accounts:\n  foo@bar.com:\n    login:\n      addr: ${IMAPSTATS_TEST_MISSING}:993\n")
+	assert.EqualError(t, err, "bad config: line 4: environment variable IMAPSTATS_TEST_MISSING is not set")
+}
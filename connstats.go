@@ -0,0 +1,37 @@
+package main
+
+import "sync"
+
+// connection counters reported under _meta.connections
+const (
+	connOpened   = "opened"
+	connReused   = "reused"
+	connLogins   = "logins"
+	connSearches = "searches"
+)
+
+var connStats = struct {
+	sync.Mutex
+	byAccount map[string]map[string]int
+}{byAccount: map[string]map[string]int{}}
+
+// countConn increments a connection counter of an account
+func countConn(account string, counter string) {
+	connStats.Lock()
+	defer connStats.Unlock()
+	if connStats.byAccount[account] == nil {
+		connStats.byAccount[account] = map[string]int{}
+	}
+	connStats.byAccount[account][counter]++
+}
+
+// connCounters returns a copy of all connection counters of an account
+func connCounters(account string) map[string]int {
+	connStats.Lock()
+	defer connStats.Unlock()
+	res := map[string]int{}
+	for _, counter := range []string{connOpened, connReused, connLogins, connSearches} {
+		res[counter] = connStats.byAccount[account][counter]
+	}
+	return res
+}
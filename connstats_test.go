@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetConnStats clears connection counters left by other tests
+func resetConnStats(t *testing.T) {
+	connStats.Lock()
+	defer connStats.Unlock()
+	connStats.byAccount = map[string]map[string]int{}
+}
+
+func Test_connCountersShouldCountReusedConnections(t *testing.T) {
+	resetConnStats(t)
+	c := &fakeClient{search: func(*imap.SearchCriteria) ([]uint32, error) { return []uint32{1}, nil }}
+
+	_, err := collectStats(c, statsConfig{
+		"foo_count": &criteriaCfg{Headers: map[string]string{"Subject": "foo"}},
+		"bar_count": &criteriaCfg{Headers: map[string]string{"Subject": "bar"}},
+		"baz_count": &criteriaCfg{Headers: map[string]string{"Subject": "baz"}},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]int{"opened": 0, "reused": 2, "logins": 0, "searches": 3}, connCounters(*userArg))
+	assert.Equal(t, map[string]int{"opened": 0, "reused": 0, "logins": 0, "searches": 0}, connCounters("other@bar.com"))
+}
+
+func Test_fetchStatsShouldReportConnectionCountersInMeta(t *testing.T) {
+	resetConnStats(t)
+	defer func(maildir, mbox string, meta bool, concurrency int) {
+		*maildirArg, *mboxArg, *metaArg, *concurrencyArg = maildir, mbox, meta, concurrency
+	}(*maildirArg, *mboxArg, *metaArg, *concurrencyArg)
+	*maildirArg, *mboxArg, *metaArg, *concurrencyArg = "testdata/maildir", "INBOX", true, 1
+
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		*userArg: {"INBOX": {
+			"seen_count":    &criteriaCfg{Seen: true},
+			"flagged_count": &criteriaCfg{WithFlags: []string{imap.FlaggedFlag}},
+		}},
+	}}
+	for i := 0; i < 2; i++ {
+		_, err := fetchStats(cfg)
+		require.NoError(t, err)
+	}
+	st, err := fetchStats(cfg)
+	require.NoError(t, err)
+
+	// unseen_count comes from STATUS, so two searches per run
+	assert.Equal(t, map[string]int{"opened": 3, "reused": 6, "logins": 3, "searches": 6},
+		st[metaKey].(map[string]interface{})["connections"])
+}
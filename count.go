@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+const esearchCap = "ESEARCH"
+
+// rawCommander is implemented by clients able to run arbitrary commands, e.g. *client.Client
+type rawCommander interface {
+	Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error)
+	Support(cap string) (bool, error)
+	State() imap.ConnState
+}
+
+// countMatches counts messages matching sc and extension keys without keeping their ids in memory.
+// ESEARCH COUNT is used where supported so that the server sends only the number.
+func countMatches(c imapClient, sc *imap.SearchCriteria, keys []interface{}) (int, error) {
+	rc, ok := c.(rawCommander)
+	if !ok {
+		ids, err := c.Search(sc)
+		return len(ids), err
+	}
+	esearch, err := rc.Support(esearchCap)
+	if err != nil {
+		return 0, err
+	}
+	h := &countHandler{}
+	cmd := &searchCommand{criteria: sc, keys: keys, returnCount: esearch}
+	if err := executeSearch(rc, cmd, h); err != nil {
+		return 0, err
+	}
+	if h.err != nil {
+		return 0, h.err
+	}
+	return h.count, nil
+}
+
+// searchIDs is like imapClient.Search but also sends search keys of server extensions
+func searchIDs(c imapClient, sc *imap.SearchCriteria, keys []interface{}) ([]uint32, error) {
+	rc, ok := c.(rawCommander)
+	if !ok || len(keys) == 0 {
+		return c.Search(sc)
+	}
+	h := &responses.Search{}
+	if err := executeSearch(rc, &searchCommand{criteria: sc, keys: keys}, h); err != nil {
+		return nil, err
+	}
+	return h.Ids, nil
+}
+
+// charsetCommand is a command searching messages with criteria in a charset
+type charsetCommand interface {
+	imap.Commander
+	setCharset(charset string)
+}
+
+// executeSearch runs cmd the way go-imap runs searches
+func executeSearch(rc rawCommander, cmd charsetCommand, h responses.Handler) error {
+	if rc.State() != imap.SelectedState {
+		return client.ErrNoMailboxSelected
+	}
+	cmd.setCharset("UTF-8")
+	status, err := rc.Execute(cmd, h)
+	if status != nil && status.Code == imap.CodeBadCharset {
+		// Some servers don't support UTF-8
+		cmd.setCharset("US-ASCII")
+		status, err = rc.Execute(cmd, h)
+	}
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// searchCommand is a SEARCH command with raw keys of server extensions,
+// asking for COUNT only if returnCount is set
+type searchCommand struct {
+	criteria    *imap.SearchCriteria
+	keys        []interface{}
+	returnCount bool
+	charset     string
+}
+
+func (cmd *searchCommand) setCharset(charset string) {
+	cmd.charset = charset
+}
+
+func (cmd *searchCommand) Command() *imap.Command {
+	var args []interface{}
+	if cmd.returnCount {
+		args = append(args, imap.RawString("RETURN"), []interface{}{imap.RawString("COUNT")})
+	}
+	args = append(args, imap.RawString("CHARSET"), imap.RawString(cmd.charset))
+	args = append(args, cmd.criteria.Format()...)
+	args = append(args, cmd.keys...)
+	return &imap.Command{Name: "SEARCH", Arguments: args}
+}
+
+// countHandler counts ids of SEARCH responses and reads COUNT of ESEARCH ones
+type countHandler struct {
+	count int
+	err   error
+}
+
+func (h *countHandler) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok {
+		return responses.ErrUnhandled
+	}
+	switch name {
+	case "SEARCH":
+		h.count += len(fields)
+	case esearchCap:
+		h.handleESearch(fields)
+	default:
+		return responses.ErrUnhandled
+	}
+	return nil
+}
+
+func (h *countHandler) handleESearch(fields []interface{}) {
+	for i := 0; i < len(fields); i++ {
+		name, ok := fields[i].(string)
+		if !ok || !strings.EqualFold(name, "COUNT") || i+1 >= len(fields) {
+			// tag correlator, UID indicator or other return data
+			continue
+		}
+		n, err := imap.ParseNumber(fields[i+1])
+		if err != nil {
+			h.err = err
+			return
+		}
+		h.count = int(n)
+		return
+	}
+}
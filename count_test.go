@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func searchResp(name string, fields ...interface{}) *imap.DataResp {
+	return &imap.DataResp{Tag: "*", Fields: append([]interface{}{name}, fields...)}
+}
+
+func Test_countHandlerShouldCount(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected int
+		given    []imap.Resp
+	}{
+		{"search", 3, []imap.Resp{searchResp("SEARCH", "1", "5", "7")}},
+		{"empty search", 0, []imap.Resp{searchResp("SEARCH")}},
+		{"split search", 4, []imap.Resp{searchResp("SEARCH", "1", "2"), searchResp("SEARCH", "3", "4")}},
+		{"esearch", 42, []imap.Resp{searchResp("ESEARCH", []interface{}{"TAG", "A1"}, "COUNT", "42")}},
+		{"uid esearch", 7, []imap.Resp{searchResp("ESEARCH", []interface{}{"TAG", "A1"}, "UID", "COUNT", "7")}},
+		{"esearch without matches", 0, []imap.Resp{searchResp("ESEARCH", []interface{}{"TAG", "A1"})}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			underTest := &countHandler{}
+			for _, resp := range tt.given {
+				require.NoError(t, underTest.Handle(resp))
+			}
+			require.NoError(t, underTest.err)
+			assert.Equal(t, tt.expected, underTest.count)
+		})
+	}
+}
+
+func Test_countHandlerShouldNotRetainIDs(t *testing.T) {
+	allocsToCount := func(n int) float64 {
+		ids := make([]interface{}, n)
+		for i := range ids {
+			ids[i] = "12345"
+		}
+		resp := searchResp("SEARCH", ids...)
+
+		underTest := &countHandler{}
+		var err error
+		allocs := testing.AllocsPerRun(10, func() {
+			underTest.count = 0
+			err = underTest.Handle(resp)
+		})
+		require.NoError(t, err)
+		require.Equal(t, n, underTest.count)
+		return allocs
+	}
+
+	assert.Equal(t, allocsToCount(10), allocsToCount(500000))
+}
+
+func Test_searchCommandShouldAskForCountOnlyWithESearch(t *testing.T) {
+	sc := &imap.SearchCriteria{WithoutFlags: []string{imap.SeenFlag}}
+
+	cmd := (&searchCommand{criteria: sc, returnCount: true, charset: "UTF-8"}).Command()
+	assert.Equal(t, []interface{}{
+		imap.RawString("RETURN"), []interface{}{imap.RawString("COUNT")},
+		imap.RawString("CHARSET"), imap.RawString("UTF-8"),
+	}, cmd.Arguments[:4])
+
+	cmd = (&searchCommand{criteria: sc, charset: "UTF-8"}).Command()
+	assert.Equal(t, imap.RawString("CHARSET"), cmd.Arguments[0])
+}
+
+func Test_countMatchesShouldCountWithoutESearch(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+
+	n, err := countMatches(c, &imap.SearchCriteria{WithoutFlags: []string{imap.SeenFlag}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = countMatches(c, &imap.SearchCriteria{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
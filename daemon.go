@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// keptConns are connections of -daemon and -serve kept logged in between cycles by account; nil otherwise
+var keptConns map[string]*client.Client
+
+// connect returns the connection kept for -user if it is still alive, otherwise logs in
+func connect() (*client.Client, error) {
+	if c := keptConns[*userArg]; c != nil {
+		delete(keptConns, *userArg)
+		if c.State()&imap.AuthenticatedState != 0 && c.Noop() == nil {
+			return c, nil
+		}
+		log.Printf("connection of %s dropped, logging in again", *userArg)
+		delete(connChanges, c)
+		c.Logout()
+	}
+	return login()
+}
+
+// release keeps c for the next cycle of -daemon unless it failed, otherwise logs it out.
+// Maildir connections are not kept as they serve messages read at login.
+func release(c *client.Client, err error) {
+	if keptConns == nil || err != nil || *maildirArg != "" {
+		c.Logout()
+		return
+	}
+	keptConns[*userArg] = c
+}
+
+// runDaemon refreshes the cache like -write-cache every -interval, and on changes with -idle,
+// until SIGINT or SIGTERM. A signal received during a cycle stops the daemon once the cycle is done.
+func runDaemon(cfg *config, filter *statsFilter) error {
+	interval, err := parseDurationArg(*intervalArg)
+	if err != nil {
+		return err
+	}
+	if err := validateIdle(); err != nil {
+		return err
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	daemonLoop(cfg, filter, ticker.C, stop)
+	return nil
+}
+
+// daemonLoop runs a cycle at once and then on every tick or change until stopped,
+// logging out kept connections once done
+func daemonLoop(cfg *config, filter *statsFilter, tick <-chan time.Time, stop <-chan os.Signal) {
+	origWrite := *writeCacheArg
+	*writeCacheArg = true
+	keptConns, connChanges, noIdleLogged = map[string]*client.Client{}, map[*client.Client]*mailboxWatch{}, false
+	defer func() {
+		for _, c := range keptConns {
+			c.Logout()
+		}
+		keptConns, connChanges, *writeCacheArg = nil, nil, origWrite
+	}()
+	for {
+		daemonCycle(cfg, filter)
+		if nextCycle(tick, stop) {
+			return
+		}
+	}
+}
+
+// daemonCycle collects and writes stats like a single run but logs failures instead of exiting,
+// so that the next cycle tries again
+func daemonCycle(cfg *config, filter *statsFilter) {
+	report = newRunReport()
+	st, err := fetchPostprocessed(cfg, filter)
+	if saveErr := saveReport(err); saveErr != nil {
+		log.Printf("failed to save report: %s", saveErr)
+	}
+	if err == nil {
+		err = writeOutputs(st)
+	}
+	if err != nil {
+		log.Printf("failed to refresh stats: %s", err)
+	}
+}
+
+// fetchPostprocessed collects stats of -user or all accounts ready to be written
+func fetchPostprocessed(cfg *config, filter *statsFilter) (stats, error) {
+	if allAccounts() {
+		return fetchAccounts(cfg, filter, fetchStats)
+	}
+	st, err := fetchStats(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return postprocessFetched(cfg, st, filter)
+}
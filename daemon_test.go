@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_daemonLoopShouldRefreshCacheOnEveryTick(t *testing.T) {
+	withTempCacheDir(t)
+	resetConnStats(t)
+	defer func(user, maildir, mbox string, quiet bool, concurrency int) {
+		*userArg, *maildirArg, *mboxArg, *quietArg, *concurrencyArg = user, maildir, mbox, quiet, concurrency
+	}(*userArg, *maildirArg, *mboxArg, *quietArg, *concurrencyArg)
+	*userArg, *maildirArg, *mboxArg, *quietArg, *concurrencyArg = "foo@bar.com", "testdata/maildir", "INBOX", true, 1
+
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		*userArg: {"INBOX": {"seen_count": &criteriaCfg{Seen: true}}},
+	}}
+	tick := make(chan time.Time)
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		daemonLoop(cfg, nil, tick, stop)
+		close(done)
+	}()
+	tick <- time.Now()
+	stop <- syscall.SIGTERM
+	<-done
+
+	assert.Equal(t, 2, connCounters(*userArg)["logins"])
+	assert.FileExists(t, cacheFilename())
+	assert.False(t, *writeCacheArg)
+	assert.Nil(t, keptConns)
+}
+
+func Test_connectShouldReuseKeptConnectionsWhileAlive(t *testing.T) {
+	defer func(maildir, mbox string) { *maildirArg, *mboxArg = maildir, mbox }(*maildirArg, *mboxArg)
+	*maildirArg, *mboxArg = "testdata/maildir", "INBOX"
+	defer func() { keptConns = nil }()
+
+	kept, err := dialMaildir(*maildirArg)
+	require.NoError(t, err)
+	defer kept.Logout()
+	keptConns = map[string]*client.Client{*userArg: kept}
+
+	c, err := connect()
+	require.NoError(t, err)
+	assert.Same(t, kept, c)
+	assert.Empty(t, keptConns)
+
+	require.NoError(t, kept.Logout())
+	keptConns[*userArg] = kept
+
+	c, err = connect()
+	require.NoError(t, err)
+	defer c.Logout()
+	assert.NotSame(t, kept, c)
+	assert.Equal(t, imap.ConnState(imap.AuthenticatedState), c.State())
+}
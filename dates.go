@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const groupByWeekday = "weekday"
+
+var (
+	// now is a variable to be replaced in tests
+	now = time.Now
+
+	// location is the timezone days are computed in; set by -tz
+	location = time.Local
+)
+
+func initLocation(name string) error {
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	location = loc
+	return nil
+}
+
+// dayWindow returns the bounds of a day that is daysAgo days before t.
+// Days are computed on the calendar rather than by subtracting 24h
+// so that the window is correct across DST changes.
+func dayWindow(t time.Time, daysAgo int) (since time.Time, before time.Time) {
+	t = t.In(location)
+	y, m, d := t.Date()
+	since = time.Date(y, m, d-daysAgo, 0, 0, 0, 0, location)
+	before = time.Date(y, m, d-daysAgo+1, 0, 0, 0, 0, location)
+	return
+}
+
+// parseDate parses an RFC3339 date or time, or a relative date like 7d or 2w
+// meaning a number of days or weeks before t
+func parseDate(s string, t time.Time) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", s, location); err == nil {
+		return d, nil
+	}
+	if d, err := time.Parse(time.RFC3339, s); err == nil {
+		return d, nil
+	}
+	var days int
+	n, err := strconv.Atoi(s)
+	if len(s) > 1 {
+		days = map[byte]int{'d': 1, 'w': 7}[s[len(s)-1]]
+		n, err = strconv.Atoi(s[:len(s)-1])
+	}
+	if days == 0 || err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("bad date %q: want RFC3339 date or a number of days or weeks ago like 7d", s)
+	}
+	t = t.In(location)
+	return t.AddDate(0, 0, -n*days), nil
+}
+
+// weekdayCounts counts dates by the day of week they fall on in -tz timezone
+type weekdayCounts map[string]int
+
+func newWeekdayCounts() weekdayCounts {
+	res := weekdayCounts{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		res[d.String()] = 0
+	}
+	return res
+}
+
+func (w weekdayCounts) add(t time.Time) {
+	w[t.In(location).Weekday().String()]++
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withLocation(t *testing.T, name string) *time.Location {
+	orig := location
+	require.NoError(t, initLocation(name))
+	t.Cleanup(func() { location = orig })
+	return location
+}
+
+func Test_dayWindow(t *testing.T) {
+	ny := withLocation(t, "America/New_York")
+
+	var tests = []struct {
+		name           string
+		given          time.Time
+		daysAgo        int
+		expectedSince  time.Time
+		expectedBefore time.Time
+	}{
+		{"regular day",
+			time.Date(2021, 6, 10, 15, 0, 0, 0, ny), 0,
+			time.Date(2021, 6, 10, 0, 0, 0, 0, ny), time.Date(2021, 6, 11, 0, 0, 0, 0, ny)},
+		{"yesterday over month boundary",
+			time.Date(2021, 7, 1, 0, 5, 0, 0, ny), 1,
+			time.Date(2021, 6, 30, 0, 0, 0, 0, ny), time.Date(2021, 7, 1, 0, 0, 0, 0, ny)},
+		{"other timezone is converted",
+			time.Date(2021, 6, 10, 2, 0, 0, 0, time.UTC), 0,
+			time.Date(2021, 6, 9, 0, 0, 0, 0, ny), time.Date(2021, 6, 10, 0, 0, 0, 0, ny)},
+		{"today on a 23h DST start day",
+			time.Date(2021, 3, 14, 23, 30, 0, 0, ny), 0,
+			time.Date(2021, 3, 14, 0, 0, 0, 0, ny), time.Date(2021, 3, 15, 0, 0, 0, 0, ny)},
+		{"yesterday right after a DST start day",
+			time.Date(2021, 3, 15, 0, 30, 0, 0, ny), 1,
+			time.Date(2021, 3, 14, 0, 0, 0, 0, ny), time.Date(2021, 3, 15, 0, 0, 0, 0, ny)},
+		{"yesterday right after a 25h DST end day",
+			time.Date(2021, 11, 8, 0, 30, 0, 0, ny), 1,
+			time.Date(2021, 11, 7, 0, 0, 0, 0, ny), time.Date(2021, 11, 8, 0, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			since, before := dayWindow(tt.given, tt.daysAgo)
+			assert.True(t, tt.expectedSince.Equal(since), "since: %s", since)
+			assert.True(t, tt.expectedBefore.Equal(before), "before: %s", before)
+		})
+	}
+}
+
+func Test_criteriaCfgToIMAPShouldExpandDays(t *testing.T) {
+	utc := withLocation(t, "UTC")
+	now = func() time.Time { return time.Date(2021, 6, 10, 15, 0, 0, 0, utc) }
+	defer func() { now = time.Now }()
+
+	expected := imap.NewSearchCriteria()
+	expected.WithoutFlags = []string{imap.SeenFlag}
+	expected.Since = time.Date(2021, 6, 10, 0, 0, 0, 0, utc)
+	expected.Before = time.Date(2021, 6, 11, 0, 0, 0, 0, utc)
+	assert.Equal(t, expected, (&criteriaCfg{Today: true}).toIMAP())
+
+	expected.Since = time.Date(2021, 6, 9, 0, 0, 0, 0, utc)
+	expected.Before = time.Date(2021, 6, 10, 0, 0, 0, 0, utc)
+	assert.Equal(t, expected, (&criteriaCfg{Yesterday: true}).toIMAP())
+}
+
+func Test_parseDate(t *testing.T) {
+	utc := withLocation(t, "UTC")
+	given := time.Date(2021, 6, 10, 15, 0, 0, 0, utc)
+
+	var tests = []struct {
+		name     string
+		given    string
+		expected time.Time
+	}{
+		{"date", "2021-05-01", time.Date(2021, 5, 1, 0, 0, 0, 0, utc)},
+		{"time", "2021-05-01T10:00:00Z", time.Date(2021, 5, 1, 10, 0, 0, 0, utc)},
+		{"days ago", "7d", time.Date(2021, 6, 3, 15, 0, 0, 0, utc)},
+		{"weeks ago", "2w", time.Date(2021, 5, 27, 15, 0, 0, 0, utc)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseDate(tt.given, given)
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(actual), "actual: %s", actual)
+		})
+	}
+	for _, bad := range []string{"", "d", "7", "-1d", "7y", "2021-13-01"} {
+		_, err := parseDate(bad, given)
+		assert.Error(t, err, bad)
+	}
+}
+
+func Test_criteriaCfgToIMAPShouldSetDateRanges(t *testing.T) {
+	utc := withLocation(t, "UTC")
+	now = func() time.Time { return time.Date(2021, 6, 10, 15, 0, 0, 0, utc) }
+	defer func() { now = time.Now }()
+
+	actual := (&criteriaCfg{
+		Since:      "7d",
+		Before:     "2021-06-09",
+		SentSince:  "2021-05-01T00:00:00Z",
+		SentBefore: "1w",
+	}).toIMAP()
+
+	assert.True(t, time.Date(2021, 6, 3, 15, 0, 0, 0, utc).Equal(actual.Since), "since: %s", actual.Since)
+	assert.True(t, time.Date(2021, 6, 9, 0, 0, 0, 0, utc).Equal(actual.Before), "before: %s", actual.Before)
+	assert.True(t, time.Date(2021, 5, 1, 0, 0, 0, 0, utc).Equal(actual.SentSince), "sent since: %s", actual.SentSince)
+	assert.True(t, time.Date(2021, 6, 3, 15, 0, 0, 0, utc).Equal(actual.SentBefore), "sent before: %s", actual.SentBefore)
+}
+
+func Test_configValidateShouldRejectBadDates(t *testing.T) {
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		"foo@bar.com": {"INBOX": {"week_count": &criteriaCfg{SentSince: "last week"}}},
+	}}
+	assert.ErrorIs(t, cfg.validate(), errBadConfig)
+}
+
+func Test_weekdayCountsShouldBucketInConfiguredTimezone(t *testing.T) {
+	withLocation(t, "America/New_York")
+
+	actual := newWeekdayCounts()
+	for _, d := range []time.Time{
+		time.Date(2021, 2, 1, 2, 0, 0, 0, time.UTC),  // Sunday evening in New York
+		time.Date(2021, 2, 1, 15, 0, 0, 0, time.UTC), // Monday
+		time.Date(2021, 2, 8, 15, 0, 0, 0, time.UTC), // Monday
+		time.Date(2021, 2, 6, 12, 0, 0, 0, time.UTC), // Saturday
+	} {
+		actual.add(d)
+	}
+	assert.Equal(t, weekdayCounts{
+		"Sunday":    1,
+		"Monday":    2,
+		"Tuesday":   0,
+		"Wednesday": 0,
+		"Thursday":  0,
+		"Friday":    0,
+		"Saturday":  1,
+	}, actual)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"gopkg.in/yaml.v3"
+)
+
+// describe renders sc as the SEARCH command sent to the server.
+// Extensions like WITHIN depend on server capabilities and are not shown.
+func describe(sc *imap.SearchCriteria) (string, error) {
+	var buf bytes.Buffer
+	cmd := &imap.Command{Name: "SEARCH", Arguments: sc.Format()}
+	if err := cmd.WriteTo(imap.NewWriter(&buf)); err != nil {
+		return "", err
+	}
+	// untagged commands are written with * tag
+	return strings.TrimPrefix(strings.TrimSpace(buf.String()), "* "), nil
+}
+
+// explain writes resolved criteria of stat key of -user and -mailbox and its IMAP search
+func explain(w io.Writer, cfg *config, key string) error {
+	cr, found := cfg.getStatsCfg(*userArg, *mboxArg)[key]
+	if !found {
+		return fmt.Errorf("unknown stat %q in %s of %s", key, *mboxArg, *userArg)
+	}
+	b, err := yaml.Marshal(cr)
+	if err != nil {
+		return err
+	}
+	search, err := describe(cr.toIMAP())
+	if err != nil {
+		return err
+	}
+	mbox := *mboxArg
+	if cr.Mailbox != "" {
+		mbox = cr.Mailbox
+	}
+	fmt.Fprintf(w, "# %s %s %s\n", *userArg, mbox, key)
+	if string(b) != "{}\n" {
+		fmt.Fprint(w, string(b))
+	}
+	fmt.Fprintf(w, "# %s\n", search)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_explainShouldRenderTemplateExpandedCriteria(t *testing.T) {
+	cfg, err := loadConfigFrom(t, templatesConfig)
+	require.NoError(t, err)
+	require.NoError(t, cfg.expandTemplates())
+
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+
+	var buf bytes.Buffer
+	require.NoError(t, explain(&buf, cfg, "from_boss"))
+
+	expected := `# foo@bar.com INBOX from_boss
+body:
+    - urgent
+    - literal
+headers:
+    From: boss@corp.com
+any_seen: true
+# SEARCH FROM "boss@corp.com" BODY "urgent" BODY "literal"
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_explainShouldFailOnUnknownKey(t *testing.T) {
+	cfg, err := fetchConfig(filepath.Join(t.TempDir(), configName))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.Error(t, explain(&buf, cfg, "no_such_stat"))
+}
+
+func Test_describe(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+		given    *criteriaCfg
+	}{
+		{"unseen", "SEARCH UNSEEN", &criteriaCfg{}},
+		{"seen", "SEARCH SEEN", &criteriaCfg{Seen: true}},
+		{"any", "SEARCH ALL", &criteriaCfg{AnySeen: true}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := describe(tt.given.toIMAP())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
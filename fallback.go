@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// fallBackToCache writes cached stats to w if -fallback-cache is set and fetching failed
+// with a network error. It tells whether the cache was written.
+func fallBackToCache(w io.Writer, err error) bool {
+	if !*fallbackCacheArg || !isNetError(err) {
+		return false
+	}
+	warnf("fetching stats failed: %s; falling back to cache %s", err, cacheFilename())
+	if err := copyCache(w, fallbackTTL(), false); err != nil {
+		warnf("can not fall back to cache: %s", err)
+		return false
+	}
+	return true
+}
+
+// fallbackTTL returns the max age of cached stats -fallback-cache outputs
+func fallbackTTL() time.Duration {
+	if *fallbackTTLArg == "" {
+		return ttlInfinite
+	}
+	ttl, err := parseDurationArg(*fallbackTTLArg)
+	if err != nil {
+		return ttlInfinite
+	}
+	return ttl
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fallBackToCacheShouldWriteCacheOnNetworkError(t *testing.T) {
+	withTempCacheDir(t)
+	withReport(t, "")
+	*writeCacheArg, *quietArg = true, true
+	require.NoError(t, writeStats(stats{"unseen_count": 1}))
+	*writeCacheArg, *quietArg = false, false
+	defer func() { *fallbackCacheArg, *fallbackTTLArg = false, "" }()
+
+	var buf bytes.Buffer
+	assert.False(t, fallBackToCache(&buf, io.EOF), "disabled")
+
+	*fallbackCacheArg = true
+	assert.False(t, fallBackToCache(&buf, errors.New("bad config")), "not a network error")
+	assert.Empty(t, buf.String())
+
+	assert.True(t, fallBackToCache(&buf, io.EOF))
+	assert.JSONEq(t, `{"unseen_count": 1}`, buf.String())
+	assert.Len(t, report.Warnings, 1)
+
+	*fallbackTTLArg = "1h"
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(cacheFilename(), old, old))
+	buf.Reset()
+	assert.False(t, fallBackToCache(&buf, io.EOF), "too old")
+	assert.Empty(t, buf.String())
+}
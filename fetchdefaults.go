@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+const fetchDefaultsKey = "fetch_defaults"
+
+// fetchDefaultOptions are criteria options fetch_defaults may set
+var fetchDefaultOptions = map[string]bool{
+	"fetch":       true,
+	"fetch_limit": true,
+	"fields":      true,
+	"preview":     true,
+}
+
+// fetchDefaultConflicts are criteria options that rule out a default option if set to true,
+// as lint reports them combined, e.g. fetch with split_by_seen
+var fetchDefaultConflicts = map[string][]string{
+	"fetch": {"split_by_seen"},
+}
+
+// applyFetchDefaults copies options of the global and mailbox level fetch_defaults into
+// criteria that do not set them: criteria > mailbox defaults > global defaults.
+// It works on the YAML tree so that an explicit false or 0 in criteria is kept.
+// Criteria using templates are left as is and defaults conflicting with criteria are skipped.
+// Logins have to be taken out of accounts before, see takeLogins.
+func applyFetchDefaults(doc *yaml.Node) error {
+	root := doc
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	global, err := takeFetchDefaults(root, fetchDefaultsKey, false)
+	if err != nil {
+		return err
+	}
+	accounts := mappingValue(root, "accounts")
+	if accounts == nil || accounts.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(accounts.Content); i += 2 {
+		mboxes := accounts.Content[i+1]
+		if mboxes.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(mboxes.Content); j += 2 {
+			mbox := mboxes.Content[j+1]
+			if mbox.Kind != yaml.MappingNode {
+				continue
+			}
+			location := fmt.Sprintf("accounts.%s.%s.%s", accounts.Content[i].Value, mboxes.Content[j].Value, fetchDefaultsKey)
+			local, err := takeFetchDefaults(mbox, location, true)
+			if err != nil {
+				return err
+			}
+			for k := 0; k+1 < len(mbox.Content); k += 2 {
+				inheritDefaults(mbox.Content[k+1], local, global)
+			}
+		}
+	}
+	return nil
+}
+
+// takeFetchDefaults returns fetch_defaults of a mapping node, removing it from the node if asked
+func takeFetchDefaults(n *yaml.Node, location string, remove bool) (*yaml.Node, error) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != fetchDefaultsKey {
+			continue
+		}
+		defaults := n.Content[i+1]
+		if remove {
+			n.Content = append(n.Content[:i:i], n.Content[i+2:]...)
+		}
+		if defaults.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%w: %s: must be a mapping", errBadConfig, location)
+		}
+		for j := 0; j < len(defaults.Content); j += 2 {
+			if !fetchDefaultOptions[defaults.Content[j].Value] {
+				return nil, fmt.Errorf("%w: %s: unknown option %s", errBadConfig, location, defaults.Content[j].Value)
+			}
+		}
+		return defaults, nil
+	}
+	return nil, nil
+}
+
+// inheritDefaults adds options of mailbox and global defaults missing in criteria node cr
+func inheritDefaults(cr *yaml.Node, local *yaml.Node, global *yaml.Node) {
+	if local == nil && global == nil {
+		return
+	}
+	if cr.Kind == yaml.ScalarNode && cr.Tag == "!!null" {
+		// a criteria without options
+		cr.Kind, cr.Tag, cr.Value = yaml.MappingNode, "!!map", ""
+	}
+	if cr.Kind != yaml.MappingNode || mappingValue(cr, "use") != nil {
+		return
+	}
+	for _, defaults := range []*yaml.Node{local, global} {
+		if defaults == nil {
+			continue
+		}
+		for i := 0; i+1 < len(defaults.Content); i += 2 {
+			key := defaults.Content[i].Value
+			if mappingValue(cr, key) == nil && !conflictsWithDefault(cr, key) {
+				cr.Content = append(cr.Content, defaults.Content[i], defaults.Content[i+1])
+			}
+		}
+	}
+}
+
+// conflictsWithDefault tells whether criteria node cr sets an option ruling out default key
+func conflictsWithDefault(cr *yaml.Node, key string) bool {
+	for _, option := range fetchDefaultConflicts[key] {
+		var set bool
+		if v := mappingValue(cr, option); v != nil && v.Decode(&set) == nil && set {
+			return true
+		}
+	}
+	return false
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadConfigFrom(t *testing.T, content string) (*config, error) {
+	path := filepath.Join(t.TempDir(), configName)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return loadConfig(path)
+}
+
+func Test_loadConfigShouldInheritFetchDefaults(t *testing.T) {
+	cfg, err := loadConfigFrom(t, `
+fetch_defaults:
+  fetch: true
+  fetch_limit: 5
+  fields: [date, subject]
+accounts:
+  foo@bar.com:
+    INBOX:
+      fetch_defaults:
+        preview: true
+        fetch_limit: 3
+      boss_count:
+        headers:
+          From: boss@bar.com
+      quiet_count:
+        fetch: false
+        preview: false
+      plain_count:
+      templated_count:
+        use: from_sender
+    Work:
+      work_count:
+        fetch_limit: 20
+`)
+	require.NoError(t, err)
+
+	inbox := cfg.Accounts["foo@bar.com"]["INBOX"]
+	assert.NotContains(t, inbox, fetchDefaultsKey)
+	assert.Equal(t, &criteriaCfg{
+		Headers:    map[string]string{"From": "boss@bar.com"},
+		Fetch:      true,
+		FetchLimit: intRef(3),
+		Fields:     []string{"date", "subject"},
+		Preview:    true,
+	}, inbox["boss_count"])
+	assert.Equal(t, &criteriaCfg{
+		FetchLimit: intRef(3),
+		Fields:     []string{"date", "subject"},
+	}, inbox["quiet_count"], "explicit false overrides defaults")
+	assert.Equal(t, &criteriaCfg{
+		Fetch:      true,
+		FetchLimit: intRef(3),
+		Fields:     []string{"date", "subject"},
+		Preview:    true,
+	}, inbox["plain_count"])
+	assert.Equal(t, &criteriaCfg{Use: "from_sender"}, inbox["templated_count"])
+	assert.Equal(t, &criteriaCfg{
+		Fetch:      true,
+		FetchLimit: intRef(20),
+		Fields:     []string{"date", "subject"},
+	}, cfg.Accounts["foo@bar.com"]["Work"]["work_count"])
+}
+
+func Test_loadConfigShouldRejectUnknownFetchDefaults(t *testing.T) {
+	_, err := loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    INBOX:
+      fetch_defaults:
+        seen: true
+`)
+	assert.EqualError(t, err, "bad config: accounts.foo@bar.com.INBOX.fetch_defaults: unknown option seen")
+}
+
+func Test_loadConfigShouldNotApplyFetchDefaultsToLogins(t *testing.T) {
+	cfg, err := loadConfigFrom(t, `
+fetch_defaults:
+  fetch: true
+accounts:
+  foo@bar.com:
+    login:
+      addr: imap.bar.com:993
+      user:
+    INBOX:
+      unseen_count:
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, loginCfg{Addr: "imap.bar.com:993"}, cfg.Logins["foo@bar.com"])
+	assert.Equal(t, map[string]statsConfig{
+		"INBOX": {"unseen_count": &criteriaCfg{Fetch: true}},
+	}, cfg.Accounts["foo@bar.com"])
+}
+
+func Test_loadConfigShouldSkipConflictingFetchDefaults(t *testing.T) {
+	cfg, err := loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    INBOX:
+      fetch_defaults:
+        fetch: true
+        fields: [subject]
+      split_count:
+        split_by_seen: true
+      unsplit_count:
+        split_by_seen: false
+`)
+	require.NoError(t, err)
+
+	inbox := cfg.Accounts["foo@bar.com"]["INBOX"]
+	assert.Equal(t, &criteriaCfg{SplitBySeen: true, Fields: []string{"subject"}}, inbox["split_count"])
+	assert.Equal(t, &criteriaCfg{Fetch: true, Fields: []string{"subject"}}, inbox["unsplit_count"])
+	assert.Empty(t, cfg.lint())
+}
+
+func Test_newLetterShouldPickFields(t *testing.T) {
+	c := newTestClient(t, "foo")
+	cr := &criteriaCfg{Fetch: true, Preview: true, Fields: []string{"subject", "preview"}}
+
+	var actual []*letter
+	err := fetchMails(c, "test", []uint32{2}, false, cr, func(m *imap.Message) error {
+		actual = append(actual, newLetter(m, cr))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*letter{{Subject: "foo", Preview: "hello"}}, actual)
+}
+
+func intRef(n int) *int {
+	return &n
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// filterOps are ordered so that two-char operators are tried before their prefixes
+var filterOps = []string{">=", "<=", "==", ">", "<"}
+
+// statsFilter keeps only stats whose key matches a pattern and whose count
+// satisfies a comparison, e.g. "*_count>5"
+type statsFilter struct {
+	pattern string
+	op      string
+	value   int
+}
+
+func parseFilter(expr string) (*statsFilter, error) {
+	for _, op := range filterOps {
+		i := strings.Index(expr, op)
+		if i < 0 {
+			continue
+		}
+		pattern := strings.TrimSpace(expr[:i])
+		if pattern == "" {
+			return nil, fmt.Errorf("bad filter %q: key is missing", expr)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad filter %q: %w", expr, err)
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(expr[i+len(op):]))
+		if err != nil {
+			return nil, fmt.Errorf("bad filter %q: %w", expr, err)
+		}
+		return &statsFilter{pattern: pattern, op: op, value: value}, nil
+	}
+	return nil, fmt.Errorf("bad filter %q: one of %s expected", expr, strings.Join(filterOps, " "))
+}
+
+func (f *statsFilter) matches(key string, n int) bool {
+	if ok, _ := path.Match(f.pattern, key); !ok {
+		return false
+	}
+	return compare(n, f.op, f.value)
+}
+
+func isFilterOp(op string) bool {
+	for _, it := range filterOps {
+		if op == it {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares n with value by one of filterOps
+func compare(n int, op string, value int) bool {
+	switch op {
+	case ">=":
+		return n >= value
+	case "<=":
+		return n <= value
+	case "==":
+		return n == value
+	case ">":
+		return n > value
+	case "<":
+		return n < value
+	}
+	return false
+}
+
+// apply returns stats with numeric entries that match the filter. Entries
+// derived from a kept stat, e.g. <key>_messages, and _metadata are kept too.
+func (f *statsFilter) apply(st stats) stats {
+	res := stats{}
+	for k, v := range st {
+		if n, ok := count(v); ok && f.matches(k, n) {
+			res[k] = v
+		}
+	}
+	for k, v := range st {
+		if _, ok := count(v); ok {
+			continue
+		}
+		if strings.HasPrefix(k, "_") {
+			res[k] = v
+			continue
+		}
+		for kept := range res {
+			if strings.HasPrefix(k, kept+"_") {
+				res[k] = v
+				break
+			}
+		}
+	}
+	return res
+}
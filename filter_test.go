@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseFilter(t *testing.T) {
+	var tests = []struct {
+		expected *statsFilter
+		given    string
+	}{
+		{&statsFilter{"unseen_count", ">", 5}, "unseen_count>5"},
+		{&statsFilter{"unseen_count", ">=", 5}, "unseen_count >= 5"},
+		{&statsFilter{"*_count", "<=", 0}, "*_count<=0"},
+		{&statsFilter{"foo", "==", -1}, "foo==-1"},
+		{&statsFilter{"foo", "<", 10}, "foo<10"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			actual, err := parseFilter(tt.given)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_parseFilterShouldFailOnBadExpressions(t *testing.T) {
+	for _, given := range []string{"", "foo", "foo=5", ">5", "foo>bar", "[>5"} {
+		_, err := parseFilter(given)
+		assert.Error(t, err, given)
+	}
+}
+
+func Test_statsFilterApply(t *testing.T) {
+	given := stats{
+		"unseen_count":             7,
+		"important_count":          1,
+		"important_count_messages": []*letter{{Subject: "foo"}},
+		"boss_count":               &fetchedStat{Count: 6},
+		"news_count":               10,
+		"news_count_by_weekday":    map[string]int{"Monday": 10},
+		metaKey:                    map[string]interface{}{"uidvalidity": uint32(42)},
+	}
+
+	f, err := parseFilter("*_count>5")
+	require.NoError(t, err)
+
+	assert.Equal(t, stats{
+		"unseen_count":          7,
+		"boss_count":            &fetchedStat{Count: 6},
+		"news_count":            10,
+		"news_count_by_weekday": map[string]int{"Monday": 10},
+		metaKey:                 map[string]interface{}{"uidvalidity": uint32(42)},
+	}, f.apply(given))
+
+	f, err = parseFilter("important_count==1")
+	require.NoError(t, err)
+	assert.Equal(t, stats{
+		"important_count":          1,
+		"important_count_messages": []*letter{{Subject: "foo"}},
+		metaKey:                    map[string]interface{}{"uidvalidity": uint32(42)},
+	}, f.apply(given))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+)
+
+const gmailCap = "X-GM-EXT-1"
+
+// supportsGmail tells whether the server advertises Gmail extensions, see
+// https://developers.google.com/gmail/imap/imap-extensions
+func supportsGmail(c imapClient) (bool, error) {
+	rc, ok := c.(rawCommander)
+	if !ok {
+		return false, nil
+	}
+	return rc.Support(gmailCap)
+}
+
+// gmailRawKeys returns the X-GM-RAW search key of gmail_raw of cr, failing on servers other than Gmail
+func (cr *criteriaCfg) gmailRawKeys(c imapClient) ([]interface{}, error) {
+	gmail, err := supportsGmail(c)
+	if err != nil {
+		return nil, err
+	}
+	if !gmail {
+		return nil, fmt.Errorf("gmail_raw needs Gmail: the server does not support %s", gmailCap)
+	}
+	return []interface{}{imap.RawString("X-GM-RAW"), cr.GmailRaw}, nil
+}
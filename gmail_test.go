@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_collectStatsShouldSearchGmailRaw(t *testing.T) {
+	underTest := &rawFakeClient{caps: map[string]bool{gmailCap: true}}
+
+	st, err := collectStats(underTest, statsConfig{
+		"attachments_count": &criteriaCfg{GmailRaw: "has:attachment older_than:7d"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"attachments_count": 2}, st)
+
+	require.Len(t, underTest.commands, 1)
+	args := underTest.commands[0].Arguments
+	assert.Equal(t, []interface{}{imap.RawString("X-GM-RAW"), "has:attachment older_than:7d"}, args[len(args)-2:])
+}
+
+func Test_collectStatsShouldFailGmailRawOnOtherServers(t *testing.T) {
+	underTest := &rawFakeClient{caps: map[string]bool{}}
+
+	_, err := collectStats(underTest, statsConfig{
+		"attachments_count": &criteriaCfg{GmailRaw: "has:attachment"},
+	}, nil)
+	assert.EqualError(t, err, "gmail_raw needs Gmail: the server does not support X-GM-EXT-1")
+	assert.Empty(t, underTest.commands)
+}
+
+func Test_criteriaCfgLintShouldRejectGmailRawInClauses(t *testing.T) {
+	cr := &criteriaCfg{
+		Or:  []criteriaCfg{{GmailRaw: "has:attachment"}, {Seen: true}},
+		Not: &criteriaCfg{GmailRaw: "in:spam"},
+	}
+	assert.Equal(t, []configProblem{
+		{location: "k.or[0]", msg: "gmail_raw is not supported inside OR or NOT clauses"},
+		{location: "k.not", msg: "gmail_raw is not supported inside OR or NOT clauses"},
+	}, cr.lint("k", true))
+
+	assert.Empty(t, (&criteriaCfg{GmailRaw: "has:attachment"}).lint("k", true))
+}
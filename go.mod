@@ -4,6 +4,9 @@ go 1.15
 
 require (
 	github.com/emersion/go-imap v1.2.0
-	github.com/stretchr/testify v1.8.4 // indirect
+	github.com/emersion/go-message v0.15.0
+	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/stretchr/testify v1.8.4
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+// idleConn fails commands whose connection makes no progress for idle. The deadline is
+// moved on every read or write while a command is in progress, so slow but flowing
+// responses like big SEARCH ones complete while stalled ones are cut. It is not armed
+// between commands as the client keeps reading then and would disconnect on a timeout.
+type idleConn struct {
+	net.Conn
+	idle time.Duration
+
+	mu   sync.Mutex
+	busy int
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.progress()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.progress()
+	}
+	return n, err
+}
+
+// SetDeadline keeps the idle deadline of a command in progress, as the client
+// clears deadlines at the start of every command
+func (c *idleConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.busy > 0 && t.IsZero() {
+		return nil
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *idleConn) progress() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.busy > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.idle))
+	}
+}
+
+// begin arms the deadline for a command
+func (c *idleConn) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.busy++
+	c.Conn.SetDeadline(time.Now().Add(c.idle))
+}
+
+// end disarms the deadline once no command is in progress
+func (c *idleConn) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.busy--
+	if c.busy == 0 {
+		c.Conn.SetDeadline(time.Time{})
+	}
+}
+
+// idleDialer dials connections with the idle deadline. Like net.Dialer
+// with a timeout given to the client, it bounds waiting for the greeting.
+type idleDialer struct {
+	dialer *net.Dialer
+	idle   time.Duration
+	conn   *idleConn
+}
+
+func (d *idleDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.dialer.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(d.dialer.Timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	d.conn = &idleConn{Conn: conn, idle: d.idle}
+	return d.conn, nil
+}
+
+// idleConns are connections of clients dialed with -idle-timeout
+var idleConns sync.Map
+
+// trackIdle remembers conn of c until it logs out
+func trackIdle(c *client.Client, conn *idleConn) {
+	idleConns.Store(c, conn)
+	go func() {
+		<-c.LoggedOut()
+		idleConns.Delete(c)
+	}()
+}
+
+// idleAware arms the idle deadline around commands of c if it was dialed with -idle-timeout
+func idleAware(c *client.Client) imapClient {
+	conn, found := idleConns.Load(c)
+	if !found {
+		return c
+	}
+	return &idleClient{Client: c, conn: conn.(*idleConn)}
+}
+
+type idleClient struct {
+	*client.Client
+	conn *idleConn
+}
+
+func (c *idleClient) Search(criteria *imap.SearchCriteria) ([]uint32, error) {
+	c.conn.begin()
+	defer c.conn.end()
+	return c.Client.Search(criteria)
+}
+
+func (c *idleClient) Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
+	c.conn.begin()
+	defer c.conn.end()
+	return c.Client.Fetch(seqset, items, ch)
+}
+
+func (c *idleClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
+	c.conn.begin()
+	defer c.conn.end()
+	return c.Client.Select(name, readOnly)
+}
+
+func (c *idleClient) Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	c.conn.begin()
+	defer c.conn.end()
+	return c.Client.Status(name, items)
+}
+
+func (c *idleClient) Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error) {
+	c.conn.begin()
+	defer c.conn.end()
+	return c.Client.Execute(cmdr, h)
+}
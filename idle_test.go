@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestIdleConn(t *testing.T, idle time.Duration) (*idleConn, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	return &idleConn{Conn: local, idle: idle}, remote
+}
+
+func Test_idleConnShouldFailStalledCommand(t *testing.T) {
+	conn, _ := newTestIdleConn(t, 50*time.Millisecond)
+
+	conn.begin()
+	// the client clears deadlines at the start of every command
+	require.NoError(t, conn.SetDeadline(time.Time{}))
+	_, err := conn.Read(make([]byte, 1))
+
+	require.Error(t, err)
+	assert.True(t, err.(net.Error).Timeout())
+}
+
+func Test_idleConnShouldNotFailSlowButProgressingCommand(t *testing.T) {
+	const idle = 100 * time.Millisecond
+	conn, remote := newTestIdleConn(t, idle)
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(idle / 5)
+			remote.Write([]byte{'x'})
+		}
+	}()
+
+	started := time.Now()
+	conn.begin()
+	defer conn.end()
+	buf := make([]byte, 1)
+	for i := 0; i < 10; i++ {
+		_, err := conn.Read(buf)
+		require.NoError(t, err)
+	}
+
+	assert.True(t, time.Since(started) > idle)
+}
+
+func Test_idleConnShouldNotFailBetweenCommands(t *testing.T) {
+	const idle = 50 * time.Millisecond
+	conn, remote := newTestIdleConn(t, idle)
+	go func() {
+		time.Sleep(2 * idle)
+		remote.Write([]byte{'x'})
+	}()
+
+	conn.begin()
+	conn.end()
+	_, err := conn.Read(make([]byte, 1))
+
+	assert.NoError(t, err)
+}
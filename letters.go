@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+const (
+	// previewLength is the default number of characters of message text put into a preview
+	previewLength = 100
+	// previewFetchBytes is how much of message text is fetched to make a preview
+	previewFetchBytes = 1024
+)
+
+// letter fields selectable with fields option
+var letterFields = map[string]func(l *letter){
+	"date":    func(l *letter) { l.Date, l.time = "", time.Time{} },
+	"from":    func(l *letter) { l.From = "" },
+	"subject": func(l *letter) { l.Subject = "" },
+	"preview": func(l *letter) { l.Preview = "" },
+}
+
+// previewSection is the beginning of message text; peeking keeps messages unseen
+var previewSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{Specifier: imap.TextSpecifier},
+	Peek:         true,
+	Partial:      []int{0, previewFetchBytes},
+}
+
+// preview returns the beginning of message text with whitespace collapsed
+func preview(m *imap.Message) string {
+	r := m.GetBody(previewSection)
+	if r == nil {
+		return ""
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return ""
+	}
+	text := []rune(strings.Join(strings.Fields(string(b)), " "))
+	if len(text) > *snippetMaxArg {
+		text = text[:*snippetMaxArg]
+	}
+	return string(text)
+}
+
+// limitSnippets drops previews of fetched messages if stats with them
+// take more than -snippet-budget bytes
+func limitSnippets(st stats) error {
+	if *snippetBudgetArg <= 0 {
+		return nil
+	}
+	size, err := encodedSize(st)
+	if err != nil || size <= *snippetBudgetArg {
+		return err
+	}
+	warnf("stats take %d bytes which exceeds -snippet-budget %d: dropping previews", size, *snippetBudgetArg)
+	for _, v := range st {
+		var letters []*letter
+		switch v := v.(type) {
+		case []*letter:
+			letters = v
+		case *fetchedStat:
+			letters = v.Messages
+		}
+		for _, l := range letters {
+			l.Preview = ""
+		}
+	}
+	return nil
+}
+
+// pickFields clears fields of l not listed in fields option of cr
+func (cr *criteriaCfg) pickFields(l *letter) {
+	if len(cr.Fields) == 0 {
+		return
+	}
+	picked := map[string]bool{}
+	for _, f := range cr.Fields {
+		picked[f] = true
+	}
+	for name, clear := range letterFields {
+		if !picked[name] {
+			clear(l)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_previewShouldBeTruncatedToSnippetMax(t *testing.T) {
+	defer func(orig int) { *snippetMaxArg = orig }(*snippetMaxArg)
+	*snippetMaxArg = 3
+
+	c := newTestClient(t, "foo")
+	cr := &criteriaCfg{Fetch: true, Preview: true, Fields: []string{"preview"}}
+
+	var actual []*letter
+	err := fetchMails(c, "test", []uint32{2}, false, cr, func(m *imap.Message) error {
+		actual = append(actual, newLetter(m, cr))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*letter{{Preview: "hel"}}, actual)
+}
+
+func Test_limitSnippetsShouldDropPreviewsOverBudget(t *testing.T) {
+	defer func(orig int) { *snippetBudgetArg = orig }(*snippetBudgetArg)
+
+	newStats := func() stats {
+		return stats{
+			"foo_count":          1,
+			"foo_count_messages": []*letter{{Subject: "foo", Preview: "hello world"}},
+			"bar_count":          &fetchedStat{Count: 1, Messages: []*letter{{Subject: "bar", Preview: "hi"}}},
+		}
+	}
+
+	*snippetBudgetArg = 0
+	st := newStats()
+	require.NoError(t, limitSnippets(st))
+	assert.Equal(t, newStats(), st)
+
+	*snippetBudgetArg = 1000
+	st = newStats()
+	require.NoError(t, limitSnippets(st))
+	assert.Equal(t, newStats(), st)
+
+	*snippetBudgetArg = 10
+	st = newStats()
+	require.NoError(t, limitSnippets(st))
+	assert.Equal(t, stats{
+		"foo_count":          1,
+		"foo_count_messages": []*letter{{Subject: "foo"}},
+		"bar_count":          &fetchedStat{Count: 1, Messages: []*letter{{Subject: "bar"}}},
+	}, st)
+}
+
+func Test_newLetterShouldFormatDateWithDateFormat(t *testing.T) {
+	defer func(orig string) { *dateFormatArg = orig }(*dateFormatArg)
+	*dateFormatArg = "Jan 2 15:04"
+
+	date := time.Date(2021, 6, 10, 15, 4, 0, 0, time.UTC)
+	given := &imap.Message{Envelope: &imap.Envelope{Date: date, Subject: "foo"}}
+	assert.Equal(t, &letter{Date: "Jun 10 15:04", Subject: "foo", time: date}, newLetter(given, &criteriaCfg{}))
+
+	assert.Equal(t, &letter{Subject: "foo"}, newLetter(&imap.Message{Envelope: &imap.Envelope{Subject: "foo"}}, &criteriaCfg{}))
+	assert.Equal(t, &letter{}, newLetter(&imap.Message{}, &criteriaCfg{}))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// letterStream writes fetched messages as JSON lines as soon as they arrive,
+// so that they are not kept in memory for the output
+type letterStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// streamedLetter is a fetched message along with where it was found
+type streamedLetter struct {
+	Account string `json:"account,omitempty"`
+	Mailbox string `json:"mailbox"`
+	Key     string `json:"key"`
+	*letter
+}
+
+// letterOut is the stream of fetched messages set by -letters-to
+var letterOut *letterStream
+
+func newLetterStream(w io.Writer) *letterStream {
+	return &letterStream{enc: json.NewEncoder(w)}
+}
+
+// openLetterStream appends fetched messages to a file
+func openLetterStream(filename string) (*letterStream, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, cachePerms)
+	if err != nil {
+		return nil, err
+	}
+	s := newLetterStream(f)
+	s.c = f
+	return s, nil
+}
+
+// write writes a message found by criteria k of a mailbox; criteria of all
+// accounts and mailboxes may write concurrently
+func (s *letterStream) write(account string, mailbox string, k string, l *letter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(&streamedLetter{Account: account, Mailbox: mailbox, Key: k, letter: l})
+}
+
+func (s *letterStream) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writesRecorder keeps every write on its own
+type writesRecorder struct {
+	writes []string
+}
+
+func (w *writesRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func Test_collectStatsShouldStreamLettersAsTheyArrive(t *testing.T) {
+	defer func(user, mbox string) { *userArg, *mboxArg, letterOut = user, mbox, nil }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+	w := &writesRecorder{}
+	letterOut = newLetterStream(w)
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{
+		"all_count": &criteriaCfg{AnySeen: true, Fetch: true, Fields: []string{"subject"}, FetchLimit: intRef(0)},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, st["all_count"])
+	assert.Empty(t, st["all_count_messages"], "streamed messages are not kept")
+	require.Len(t, w.writes, 3, "a write per message")
+	var subjects []string
+	for _, line := range w.writes {
+		var l map[string]string
+		require.NoError(t, json.Unmarshal([]byte(line), &l))
+		assert.Equal(t, "foo@bar.com", l["account"])
+		assert.Equal(t, "INBOX", l["mailbox"])
+		assert.Equal(t, "all_count", l["key"])
+		subjects = append(subjects, l["subject"])
+	}
+	assert.ElementsMatch(t, []string{"A little message, just for you", "foo", "bar"}, subjects)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connLimiter bounds the number of simultaneously open IMAP connections
+// no matter which part of the program opens them
+type connLimiter chan struct{}
+
+// connLimit is the global limiter set by -max-connections; nil means no limit
+var connLimit connLimiter
+
+func newConnLimiter(max int) connLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return make(connLimiter, max)
+}
+
+// acquire blocks until a connection can be opened
+func (l connLimiter) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l connLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// releaseOnLogout frees the slot of a connection once it is closed for any reason
+func (l connLimiter) releaseOnLogout(loggedOut <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	go func() {
+		<-loggedOut
+		l.release()
+	}()
+}
+
+// rate is a number of commands allowed per period; zero means no limit
+type rate struct {
+	n      int
+	period time.Duration
+}
+
+// commandRate is the per-account command rate set by -rate
+var commandRate rate
+
+// parseRate parses rates like 30/m; s, m and h periods are supported
+func parseRate(s string) (rate, error) {
+	if s == "" {
+		return rate{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return rate{}, fmt.Errorf("bad rate: %q: want N/s, N/m or N/h", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n <= 0 {
+		return rate{}, fmt.Errorf("bad rate: %q: count must be a positive number", s)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, found := periods[parts[1]]
+	if !found {
+		return rate{}, fmt.Errorf("bad rate: %q: want N/s, N/m or N/h", s)
+	}
+	return rate{n: n, period: period}, nil
+}
+
+// tokenBucket paces commands: up to n of them go at once,
+// then one more each period/n
+type tokenBucket struct {
+	mu sync.Mutex
+
+	capacity float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(r rate) *tokenBucket {
+	if r.n <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity: float64(r.n),
+		interval: r.period / time.Duration(r.n),
+		tokens:   float64(r.n),
+		last:     now(),
+	}
+}
+
+// take blocks until a command can be issued
+func (b *tokenBucket) take() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := now()
+	b.tokens = math.Min(b.capacity, b.tokens+float64(t.Sub(b.last))/float64(b.interval))
+	b.last = t
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) * float64(b.interval))
+		sleep(wait)
+		b.tokens = 1
+		b.last = t.Add(wait)
+	}
+	b.tokens--
+}
+
+var rateLimits = struct {
+	sync.Mutex
+	byAccount map[string]*tokenBucket
+}{byAccount: map[string]*tokenBucket{}}
+
+// accountRateLimit returns the command limiter shared by all connections of an account
+func accountRateLimit(account string) *tokenBucket {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	b, found := rateLimits.byAccount[account]
+	if !found {
+		b = newTokenBucket(commandRate)
+		rateLimits.byAccount[account] = b
+	}
+	return b
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_connLimiterShouldNeverExceedLimit(t *testing.T) {
+	underTest := newConnLimiter(3)
+
+	var open, maxOpen int32
+	var wg sync.WaitGroup
+	// emulate accounts fetched in parallel each running criteria in parallel
+	for acc := 0; acc < 4; acc++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var inner sync.WaitGroup
+			for cr := 0; cr < 5; cr++ {
+				inner.Add(1)
+				go func() {
+					defer inner.Done()
+					underTest.acquire()
+					defer underTest.release()
+
+					n := atomic.AddInt32(&open, 1)
+					for {
+						max := atomic.LoadInt32(&maxOpen)
+						if n <= max || atomic.CompareAndSwapInt32(&maxOpen, max, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddInt32(&open, -1)
+				}()
+			}
+			inner.Wait()
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, int(maxOpen), 3)
+	assert.Greater(t, int(maxOpen), 0)
+}
+
+func Test_connLimiterShouldReleaseOnLogout(t *testing.T) {
+	underTest := newConnLimiter(1)
+	c := newTestClient(t)
+
+	underTest.acquire()
+	underTest.releaseOnLogout(c.LoggedOut())
+	c.Logout()
+
+	acquired := make(chan struct{})
+	go func() {
+		underTest.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("slot was not released on logout")
+	}
+}
+
+func Test_connLimiterShouldNotLimitIfUnset(t *testing.T) {
+	underTest := newConnLimiter(0)
+	assert.Nil(t, underTest)
+	for i := 0; i < 100; i++ {
+		underTest.acquire()
+	}
+}
+
+// fakeClock makes now and sleep follow a clock that advances only by sleeping
+func fakeClock(t *testing.T) *time.Time {
+	clock := time.Date(2021, 6, 10, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	t.Cleanup(func() {
+		now = time.Now
+		sleep = time.Sleep
+	})
+	return &clock
+}
+
+func Test_parseRate(t *testing.T) {
+	var tests = []struct {
+		given    string
+		expected rate
+		err      string
+	}{
+		{"", rate{}, ""},
+		{"30/m", rate{30, time.Minute}, ""},
+		{"2/s", rate{2, time.Second}, ""},
+		{"1000/h", rate{1000, time.Hour}, ""},
+		{"30", rate{}, `bad rate: "30": want N/s, N/m or N/h`},
+		{"0/m", rate{}, `bad rate: "0/m": count must be a positive number`},
+		{"30/d", rate{}, `bad rate: "30/d": want N/s, N/m or N/h`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			actual, err := parseRate(tt.given)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_tokenBucketShouldPaceBurst(t *testing.T) {
+	clock := fakeClock(t)
+	started := *clock
+	underTest := newTokenBucket(rate{n: 6, period: time.Minute})
+
+	var issued []time.Duration
+	for i := 0; i < 10; i++ {
+		underTest.take()
+		issued = append(issued, clock.Sub(started))
+	}
+
+	// first 6 go at once, the rest one per 10s
+	assert.Equal(t, []time.Duration{
+		0, 0, 0, 0, 0, 0,
+		10 * time.Second, 20 * time.Second, 30 * time.Second, 40 * time.Second,
+	}, issued)
+
+	*clock = clock.Add(time.Hour)
+	underTest.take()
+	assert.Equal(t, 40*time.Second+time.Hour, clock.Sub(started), "idle time refills the bucket")
+}
+
+func Test_collectStatsShouldPaceSearches(t *testing.T) {
+	clock := fakeClock(t)
+	started := *clock
+	commandRate = rate{n: 2, period: time.Second}
+	delete(rateLimits.byAccount, *userArg)
+	defer func() {
+		commandRate = rate{}
+		delete(rateLimits.byAccount, *userArg)
+	}()
+
+	underTest := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		return []uint32{1}, nil
+	}}
+	cfg := statsConfig{}
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		cfg[k] = &criteriaCfg{Seen: true}
+	}
+	_, err := collectStats(underTest, cfg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, clock.Sub(started))
+}
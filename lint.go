@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+var errBadConfig = errors.New("bad config")
+
+// configProblem is a single mistake found in the config along with its location
+type configProblem struct {
+	location string
+	msg      string
+	// advisory problems, like settings without effect, do not prevent runs
+	advisory bool
+}
+
+func (p configProblem) String() string {
+	return p.location + ": " + p.msg
+}
+
+// systemFlags are flags defined by IMAP that criteria can search for
+var systemFlags = []string{
+	imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag, imap.RecentFlag,
+}
+
+// flagSpecials are characters keywords can not have, see atom-specials of RFC 3501
+const flagSpecials = "(){ %*\"\\]"
+
+func isSystemFlag(f string) bool {
+	for _, sys := range systemFlags {
+		if strings.EqualFold(f, sys) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHeaderName tells whether name consists of printable characters other than colon, see RFC 5322
+func isHeaderName(name string) bool {
+	for _, r := range name {
+		if r <= ' ' || r > '~' || r == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]*criteriaCfg) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lint checks the whole config and reports all found problems in a stable order
+func (c *config) lint() []configProblem {
+	problems := []configProblem{}
+
+	accounts := make([]string, 0, len(c.Accounts))
+	for acc := range c.Accounts {
+		accounts = append(accounts, acc)
+	}
+	sort.Strings(accounts)
+	for _, acc := range accounts {
+		mboxes := make([]string, 0, len(c.Accounts[acc]))
+		for mbox := range c.Accounts[acc] {
+			mboxes = append(mboxes, mbox)
+		}
+		sort.Strings(mboxes)
+		for _, mbox := range mboxes {
+			cfg := c.Accounts[acc][mbox]
+			for _, key := range sortedKeys(cfg) {
+				location := fmt.Sprintf("accounts.%s.%s.%s", acc, mbox, key)
+				if cfg[key] == nil {
+					continue
+				}
+				problems = append(problems, cfg[key].lint(location, true)...)
+			}
+		}
+	}
+	names := make([]string, 0, len(c.Summaries))
+	for name := range c.Summaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for i, sel := range c.Summaries[name] {
+			if sel.Key == "" {
+				problems = append(problems, configProblem{
+					location: fmt.Sprintf("summaries.%s[%d]", name, i), msg: "key must not be empty"})
+			}
+		}
+	}
+	return append(problems, c.lintComputed()...)
+}
+
+func (cr *criteriaCfg) lint(location string, topLevel bool) []configProblem {
+	problems := []configProblem{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, configProblem{location: location, msg: fmt.Sprintf(format, args...)})
+	}
+	advise := func(format string, args ...interface{}) {
+		problems = append(problems, configProblem{location: location, msg: fmt.Sprintf(format, args...), advisory: true})
+	}
+
+	headers := make([]string, 0, len(cr.Headers))
+	for k := range cr.Headers {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	for _, k := range headers {
+		if k == "" {
+			add("header name must not be empty")
+		} else if !isHeaderName(k) {
+			add("bad header name %q", k)
+		}
+	}
+	for _, b := range cr.Body {
+		if b == "" {
+			add("body search string must not be empty")
+		}
+	}
+	if cr.Seen && cr.AnySeen {
+		add("seen and any_seen are mutually exclusive")
+	}
+	required := cr.WithFlags
+	if cr.Seen {
+		required = append([]string{imap.SeenFlag}, required...)
+	}
+	for _, f := range append(cr.WithFlags, cr.WithoutFlags...) {
+		switch {
+		case f == "":
+			add("flag must not be empty")
+		case strings.HasPrefix(f, `\`):
+			if !isSystemFlag(f) {
+				add("unknown system flag %s", f)
+			}
+		case strings.ContainsAny(f, flagSpecials):
+			add("bad flag keyword %q", f)
+		}
+	}
+	for _, excluded := range cr.WithoutFlags {
+		for _, f := range required {
+			if excluded != "" && strings.EqualFold(f, excluded) {
+				add("flag %s is both required and excluded", excluded)
+			}
+		}
+	}
+	switch cr.RecentMode {
+	case "", recentStatus, recentSearch, recentIgnore:
+	default:
+		add("unknown recent_mode: %s", cr.RecentMode)
+	}
+	if cr.RecentMode != "" && !cr.Recent {
+		advise("recent_mode has no effect without recent")
+	}
+	if cr.Recent && cr.recentMode() == recentStatus && !(topLevel && cr.recentOnly()) {
+		add("recent counts from status can not be combined with other criteria; set recent_mode: search")
+	}
+	if cr.SplitBySeen && (cr.Seen || cr.AnySeen) {
+		add("split_by_seen can not be combined with seen or any_seen")
+	}
+	if cr.SplitBySeen && cr.needsFetch() {
+		add("split_by_seen can not be combined with fetching messages")
+	}
+	if cr.UIDRange != "" {
+		if _, err := imap.ParseSeqSet(cr.UIDRange); err != nil {
+			add("bad uid_range %q: %s", cr.UIDRange, err)
+		}
+	}
+	if cr.UIDRange != "" && cr.UIDsFile != "" {
+		add("uid_range and uids_file are mutually exclusive")
+	}
+	if cr.Today && cr.Yesterday {
+		add("today and yesterday are mutually exclusive")
+	}
+	if cr.hasWithin() && (cr.Today || cr.Yesterday) {
+		add("younger and older can not be combined with today or yesterday")
+	}
+	dates := map[string]string{
+		"since": cr.Since, "before": cr.Before, "sent_since": cr.SentSince, "sent_before": cr.SentBefore}
+	for _, name := range []string{"since", "before", "sent_since", "sent_before"} {
+		if dates[name] == "" {
+			continue
+		}
+		if _, err := parseDate(dates[name], now()); err != nil {
+			add("%s: %s", name, err)
+		}
+	}
+	if (cr.Since != "" || cr.Before != "") && (cr.Today || cr.Yesterday || cr.hasWithin()) {
+		add("since and before can not be combined with today, yesterday, younger or older")
+	}
+	if cr.LargerThan != "" {
+		if _, err := parseSize(cr.LargerThan); err != nil {
+			add("larger_than: %s", err)
+		}
+	}
+	if cr.SmallerThan != "" {
+		if _, err := parseSize(cr.SmallerThan); err != nil {
+			add("smaller_than: %s", err)
+		}
+	}
+	if cr.Younger < 0 || cr.Older < 0 {
+		add("younger and older must not be negative")
+	}
+	if cr.Timeout < 0 {
+		add("timeout must not be negative")
+	}
+	if cr.TTL < 0 {
+		add("ttl must not be negative")
+	}
+	if cr.ThresholdOp != "" {
+		if cr.Threshold == nil && cr.Warning == nil && cr.Critical == nil {
+			advise("threshold_op has no effect without threshold, warning or critical")
+		}
+		if !isFilterOp(cr.ThresholdOp) {
+			add("unknown threshold_op: %s", cr.ThresholdOp)
+		}
+	}
+	if cr.Cap < 0 {
+		add("cap must not be negative")
+	}
+	if cr.FetchLimit != nil && *cr.FetchLimit < 0 {
+		add("fetch_limit must not be negative")
+	}
+	for _, f := range cr.Fields {
+		if letterFields[f] == nil {
+			add("unknown field: %s", f)
+		}
+	}
+	if cr.GroupBy != "" && cr.GroupBy != groupByWeekday && cr.GroupBy != groupByFrom {
+		add("unknown group_by: %s", cr.GroupBy)
+	}
+	switch cr.FromAddress {
+	case "", fromFirst, fromLast, fromAll:
+	default:
+		add("unknown from_address: %s", cr.FromAddress)
+	}
+	if cr.NewOnly && !cr.Fetch {
+		advise("new_only has no effect without fetch")
+	}
+	if !topLevel && cr.Fetch {
+		advise("fetch has no effect inside OR or NOT clauses")
+	}
+	if !topLevel && cr.hasWithin() {
+		// YOUNGER and OLDER are added to the top-level search only
+		advise("younger and older are rounded to whole days inside OR or NOT clauses")
+	}
+	if !topLevel && cr.GmailRaw != "" {
+		// X-GM-RAW is added to the top-level search only
+		add("gmail_raw is not supported inside OR or NOT clauses")
+	}
+	if len(cr.Or) == 1 {
+		add("OR criteria must have 2 clauses")
+	}
+	for i := range cr.Or {
+		problems = append(problems, cr.Or[i].lint(fmt.Sprintf("%s.or[%d]", location, i), false)...)
+	}
+	if cr.Not != nil {
+		if reflect.DeepEqual(cr.Not, &criteriaCfg{}) {
+			add("NOT criteria must not be empty")
+		}
+		problems = append(problems, cr.Not.lint(location+".not", false)...)
+	}
+	return problems
+}
+
+// lintConfig prints all problems of the config at a given path.
+// It fails if any problem other than advisory ones is found.
+func lintConfig(path string) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := cfg.expandTemplates(); err != nil {
+		return err
+	}
+	failed := 0
+	for _, p := range cfg.lint() {
+		if p.advisory {
+			fmt.Println("warning:", p)
+			continue
+		}
+		fmt.Println(p)
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d problem(s) found", errBadConfig, failed)
+	}
+	return nil
+}
+
+// validateConfig loads the config like a run does and prints accounts, mailboxes and criteria
+// found in it without connecting to servers
+func validateConfig(path string, w io.Writer) error {
+	cfg, err := fetchConfig(path)
+	if err != nil {
+		return err
+	}
+	mailboxes, criteria := 0, 0
+	for _, acc := range cfg.accountNames() {
+		fmt.Fprintln(w, acc)
+		mboxes := make([]string, 0, len(cfg.Accounts[acc]))
+		for mbox := range cfg.Accounts[acc] {
+			mboxes = append(mboxes, mbox)
+		}
+		sort.Strings(mboxes)
+		for _, mbox := range mboxes {
+			keys := sortedKeys(cfg.Accounts[acc][mbox])
+			fmt.Fprintf(w, "  %s: %s\n", mbox, strings.Join(keys, ", "))
+			mailboxes++
+			criteria += len(keys)
+		}
+	}
+	fmt.Fprintf(w, "config is valid: %d account(s), %d mailbox(es), %d criteria\n",
+		len(cfg.Accounts), mailboxes, criteria)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configLintShouldReportAllProblems(t *testing.T) {
+	cfg, err := loadConfig("testdata/config.lint.yaml")
+	require.NoError(t, err)
+
+	var actual []string
+	for _, p := range cfg.lint() {
+		actual = append(actual, p.String())
+	}
+	assert.Equal(t, []string{
+		"accounts.baz@bar.com.Sent.single_or_count: seen and any_seen are mutually exclusive",
+		"accounts.baz@bar.com.Sent.single_or_count: OR criteria must have 2 clauses",
+		"accounts.foo@bar.com.INBOX.bad_count: header name must not be empty",
+		`accounts.foo@bar.com.INBOX.bad_count: bad header name "X Bad"`,
+		"accounts.foo@bar.com.INBOX.bad_count: body search string must not be empty",
+		"accounts.foo@bar.com.INBOX.bad_count: flag must not be empty",
+		`accounts.foo@bar.com.INBOX.bad_count: unknown system flag \Important`,
+		`accounts.foo@bar.com.INBOX.bad_count: bad flag keyword "my keyword"`,
+		`accounts.foo@bar.com.INBOX.bad_count: flag \flagged is both required and excluded`,
+		"accounts.foo@bar.com.INBOX.bad_count: unknown recent_mode: sometimes",
+		"accounts.foo@bar.com.INBOX.bad_count: recent_mode has no effect without recent",
+		`accounts.foo@bar.com.INBOX.bad_count: bad uid_range "10:foo": imap: bad sequence set value "10:foo"`,
+		"accounts.foo@bar.com.INBOX.bad_count: uid_range and uids_file are mutually exclusive",
+		"accounts.foo@bar.com.INBOX.bad_count: today and yesterday are mutually exclusive",
+		"accounts.foo@bar.com.INBOX.bad_count: younger and older can not be combined with today or yesterday",
+		`accounts.foo@bar.com.INBOX.bad_count: since: bad date "7x": want RFC3339 date or a number of days or weeks ago like 7d`,
+		"accounts.foo@bar.com.INBOX.bad_count: since and before can not be combined with today, yesterday, younger or older",
+		`accounts.foo@bar.com.INBOX.bad_count: larger_than: bad size "5X": want a number of bytes with optional k, M or G suffix`,
+		"accounts.foo@bar.com.INBOX.bad_count: younger and older must not be negative",
+		"accounts.foo@bar.com.INBOX.bad_count: timeout must not be negative",
+		"accounts.foo@bar.com.INBOX.bad_count: cap must not be negative",
+		"accounts.foo@bar.com.INBOX.bad_count: fetch_limit must not be negative",
+		"accounts.foo@bar.com.INBOX.bad_count: unknown field: body",
+		"accounts.foo@bar.com.INBOX.bad_count: unknown group_by: month",
+		"accounts.foo@bar.com.INBOX.bad_count: unknown from_address: middle",
+		"accounts.foo@bar.com.INBOX.bad_count: new_only has no effect without fetch",
+		"accounts.foo@bar.com.INBOX.bad_count.or[0]: fetch has no effect inside OR or NOT clauses",
+		"accounts.foo@bar.com.INBOX.bad_count.or[0]: OR criteria must have 2 clauses",
+		"accounts.foo@bar.com.INBOX.bad_count: NOT criteria must not be empty",
+		"summaries.total[1]: key must not be empty",
+		"computed.ratio: missing ) at 27",
+	}, actual)
+}
+
+func Test_configLintShouldPassValidConfigs(t *testing.T) {
+	for _, given := range []string{"testdata/config.yaml", "testdata/config.with-or.yaml"} {
+		cfg, err := loadConfig(given)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.lint(), given)
+	}
+}
+
+func Test_lintConfigShouldFailOnProblems(t *testing.T) {
+	err := lintConfig("testdata/config.lint.yaml")
+	assert.EqualError(t, err, "bad config: 28 problem(s) found")
+	assert.NoError(t, lintConfig("testdata/config.yaml"))
+}
+
+func Test_validateConfigShouldPrintFoundCriteria(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, validateConfig("testdata/config.yaml", &buf))
+	assert.Equal(t, `foo@bar.com
+  INBOX: important_count, notification_count, seen_count
+config is valid: 1 account(s), 1 mailbox(es), 3 criteria
+`, buf.String())
+
+	buf.Reset()
+	err := validateConfig("testdata/config.lint.yaml", &buf)
+	assert.EqualError(t, err, "bad config: accounts.baz@bar.com.Sent.single_or_count: seen and any_seen are mutually exclusive")
+	assert.Empty(t, buf.String())
+}
+
+func Test_configValidateShouldOnlyWarnAboutAdvisoryProblems(t *testing.T) {
+	withReport(t, "")
+	cfg, err := loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    INBOX:
+      any_count:
+        or:
+          - fetch: true
+          - seen: true
+        new_only: true
+`)
+	require.NoError(t, err)
+
+	require.NoError(t, cfg.validate())
+	assert.Equal(t, []string{
+		"config: accounts.foo@bar.com.INBOX.any_count: new_only has no effect without fetch",
+		"config: accounts.foo@bar.com.INBOX.any_count.or[0]: fetch has no effect inside OR or NOT clauses",
+	}, report.Warnings)
+}
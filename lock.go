@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	lockSuffix = ".lock"
+
+	// lockWait is how long a run waits for another one to finish writing the cache
+	lockWait  = 5 * time.Second
+	lockRetry = 100 * time.Millisecond
+)
+
+var errCacheLocked = errors.New("cache is locked by another run")
+
+// lockCache takes an advisory lock on a file next to the cache so that concurrent runs
+// do not write it at once. It waits up to lockWait for the lock held by another run.
+// The returned func releases the lock.
+func lockCache(cache string) (func(), error) {
+	f, err := os.OpenFile(cache+lockSuffix, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := now().Add(lockWait)
+	for {
+		err = tryLockFile(f)
+		if err != errCacheLocked || !now().Before(deadline) {
+			break
+		}
+		time.Sleep(lockRetry)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
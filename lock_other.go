@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "os"
+
+// tryLockFile does not lock where flock is missing: concurrent runs may then both
+// write the cache, which is still replaced atomically, so readers see either one
+func tryLockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) {}
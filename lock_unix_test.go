@@ -0,0 +1,74 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// advancingNow makes now jump by step on every call so that lock waits run out at once
+func advancingNow(step time.Duration) func() time.Time {
+	t := time.Now()
+	return func() time.Time {
+		t = t.Add(step)
+		return t
+	}
+}
+
+func Test_lockCacheShouldFailWhileLockedByAnotherRun(t *testing.T) {
+	defer func() { now = time.Now }()
+	cache := filepath.Join(t.TempDir(), "foo@bar.com.INBOX")
+
+	unlock, err := lockCache(cache)
+	require.NoError(t, err)
+
+	now = advancingNow(lockWait)
+	_, err = lockCache(cache)
+	assert.Equal(t, errCacheLocked, err)
+
+	unlock()
+	unlockAgain, err := lockCache(cache)
+	require.NoError(t, err)
+	unlockAgain()
+}
+
+func Test_writeStatsShouldNotWriteCacheLockedByAnotherRun(t *testing.T) {
+	withTempCacheDir(t)
+	defer func() { now = time.Now }()
+	*writeCacheArg, *quietArg = true, true
+	defer func() { *writeCacheArg, *quietArg = false, false }()
+	require.NoError(t, os.MkdirAll(profileCacheDir(), defaultDirPerms))
+
+	unlock, err := lockCache(cacheFilename())
+	require.NoError(t, err)
+	defer unlock()
+
+	now = advancingNow(lockWait)
+	require.NoError(t, writeStats(stats{"unseen_count": 1}))
+
+	_, err = os.Stat(cacheFilename())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_lockRefreshShouldFailWhileAnotherRefreshRuns(t *testing.T) {
+	withTempCacheDir(t)
+	require.NoError(t, os.MkdirAll(profileCacheDir(), defaultDirPerms))
+
+	lock, err := lockRefresh()
+	require.NoError(t, err)
+
+	_, err = lockRefresh()
+	assert.Equal(t, errRefreshing, err)
+
+	require.NoError(t, lock.Close())
+	lock, err = lockRefresh()
+	require.NoError(t, err)
+	lock.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+)
+
+const (
+	// exitTempFail and exitNoPerm follow sysexits.h like exitUnavailable
+	exitTempFail = 75
+	exitNoPerm   = 77
+	// exitTokenExpired tells that the OAuth token expired and has to be refreshed by the caller.
+	// It is EX_CONFIG as the configured token is what has to change.
+	exitTokenExpired = 78
+)
+
+// Like rate limiting, the kind of a login failure can only be told by the text
+// of the server response: go-imap drops codes like [AUTHENTICATIONFAILED].
+// Whole phrases are matched, so that e.g. a wrong password response asking
+// to try again or naming a contact address is still permanent:
+//   - [UNAVAILABLE] Temporary authentication failure, temporarily locked, try again later
+//   - gmail [WEBALERT] Web login required and [ALERT] Please log in via your web browser
+//   - [CONTACTADMIN] Please contact your administrator
+var transientLoginPattern = regexp.MustCompile(`(?i)\b(temporar(y|ily)|try again later|web login required|log ?in via your web browser|contact (your|the) (system )?administrator)\b`)
+
+// loginError is a rejected LOGIN. Transient ones, e.g. a temporarily
+// locked account, may succeed later; permanent ones, e.g. a wrong password, will not.
+type loginError struct {
+	err       error
+	transient bool
+	// expired is set if an OAuth token was rejected as expired
+	expired bool
+}
+
+func (e *loginError) Error() string {
+	if e.expired {
+		return "login failed: oauth token expired: " + e.err.Error()
+	}
+	return "login failed: " + e.err.Error()
+}
+
+func (e *loginError) Unwrap() error {
+	return e.err
+}
+
+// classifyLoginError wraps an error of LOGIN command into loginError.
+// Failures not recognised as transient are considered permanent.
+// Network failures are not login failures and are returned as is.
+func classifyLoginError(err error) error {
+	if err == nil || isNetError(err) {
+		return err
+	}
+	transient := isRateLimited(err) || transientLoginPattern.MatchString(err.Error())
+	return &loginError{err: err, transient: transient}
+}
+
+// isTransientLoginError tells whether err is a login failure worth retrying
+func isTransientLoginError(err error) bool {
+	var lErr *loginError
+	return errors.As(err, &lErr) && lErr.transient
+}
+
+// loginExitCode returns an exit code of a login failure and false for other errors
+func loginExitCode(err error) (int, bool) {
+	var lErr *loginError
+	if !errors.As(err, &lErr) {
+		return 0, false
+	}
+	if lErr.expired {
+		return exitTokenExpired, true
+	}
+	if lErr.transient {
+		return exitTempFail, true
+	}
+	return exitNoPerm, true
+}
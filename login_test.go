@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_classifyLoginError(t *testing.T) {
+	var tests = []struct {
+		given     string
+		transient bool
+	}{
+		{"Invalid credentials (Failure)", false},
+		{"Authentication failed.", false},
+		{"LOGIN failed.", false},
+		{"Temporary authentication failure", true},
+		{"Account is temporarily locked, try again later", true},
+		{"Web login required: https://support.google.com/mail/answer/78754", true},
+		{"Please log in via your web browser: https://support.google.com/mail/accounts/answer/78754 (Failure)", true},
+		{"Please contact your administrator", true},
+		{"Too many simultaneous connections. (Failure)", true},
+		{"Invalid password, try again", false},
+		{"Authentication failed, contact support@bar.com", false},
+		{"Unknown user: contemporary@bar.com", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			err := classifyLoginError(errors.New(tt.given))
+			assert.EqualError(t, err, "login failed: "+tt.given)
+			assert.Equal(t, tt.transient, isTransientLoginError(err))
+
+			expectedCode := exitNoPerm
+			if tt.transient {
+				expectedCode = exitTempFail
+			}
+			assert.Equal(t, expectedCode, errorToExitCode(err))
+		})
+	}
+	assert.NoError(t, classifyLoginError(nil))
+	assert.Equal(t, 1, errorToExitCode(errors.New("Invalid credentials (Failure)")))
+}
+
+func Test_withRateLimitRetryShouldRetryTransientLoginErrorsOnly(t *testing.T) {
+	slept := stubSleep(t)
+	*rateLimitRetriesArg = 2
+	defer func() { *rateLimitRetriesArg = 0 }()
+	withReport(t, "")
+
+	calls := 0
+	err := withRateLimitRetry(func() error {
+		calls++
+		return classifyLoginError(errors.New("Temporary authentication failure"))
+	})
+	assert.EqualError(t, err, "login is still failing after 2 retries: login failed: Temporary authentication failure")
+	assert.Equal(t, 3, calls)
+	assert.Len(t, *slept, 2)
+
+	calls = 0
+	err = withRateLimitRetry(func() error {
+		calls++
+		return classifyLoginError(errors.New("Invalid credentials (Failure)"))
+	})
+	assert.EqualError(t, err, "login failed: Invalid credentials (Failure)")
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+const mailboxIndexPrefix = "#"
+
+func mailboxesCacheFilename() string {
+	return filepath.Join(profileCacheDir(), *userArg+".mailboxes")
+}
+
+// listMailboxes prints numbered mailboxes of the server and remembers them
+// so that later runs can refer to a mailbox as #N
+func listMailboxes() error {
+	c, err := login()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	ch := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", ch)
+	}()
+	names := []string{}
+	for mbox := range ch {
+		names = append(names, mbox.Name)
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+
+	if err := writeMailboxes(mailboxesCacheFilename(), names); err != nil {
+		return err
+	}
+	for i, name := range names {
+		fmt.Printf("%d\t%s\n", i+1, name)
+	}
+	return nil
+}
+
+func writeMailboxes(filename string, names []string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), defaultDirPerms); err != nil {
+		return err
+	}
+	content := strings.Join(names, "\n") + "\n"
+	return ioutil.WriteFile(filename, []byte(content), 0600)
+}
+
+func readMailboxes(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		names = append(names, scanner.Text())
+	}
+	return names, scanner.Err()
+}
+
+// resolveMailbox turns #N into the name of the Nth mailbox from the last
+// -list-mailboxes output. Other names are returned as is.
+func resolveMailbox(name string) (string, error) {
+	if !strings.HasPrefix(name, mailboxIndexPrefix) {
+		return name, nil
+	}
+	idx, err := strconv.Atoi(strings.TrimPrefix(name, mailboxIndexPrefix))
+	if err != nil {
+		return "", fmt.Errorf("bad mailbox index %s: %w", name, err)
+	}
+	names, err := readMailboxes(mailboxesCacheFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no mailbox list found: run with -list-mailboxes first")
+		}
+		return "", err
+	}
+	if idx < 1 || idx > len(names) {
+		return "", fmt.Errorf("mailbox index %s out of range: %d mailboxes listed", name, len(names))
+	}
+	return names[idx-1], nil
+}
+
+// mailboxNames returns mailboxes given with -mailbox as a comma separated list
+func mailboxNames() []string {
+	var names []string
+	for _, name := range strings.Split(*mboxArg, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{*mboxArg}
+	}
+	return names
+}
+
+// multiMailbox tells whether stats of several mailboxes are nested under their names
+func multiMailbox() bool {
+	return len(mailboxNames()) > 1
+}
+
+// resolveMailboxes resolves each of comma separated mailboxes with resolveMailbox
+func resolveMailboxes(list string) (string, error) {
+	names := strings.Split(list, ",")
+	for i, name := range names {
+		resolved, err := resolveMailbox(strings.TrimSpace(name))
+		if err != nil {
+			return "", err
+		}
+		names[i] = resolved
+	}
+	return strings.Join(names, ","), nil
+}
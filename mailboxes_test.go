@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withTempCacheDir(t *testing.T) {
+	orig := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir = orig })
+}
+
+func Test_resolveMailbox(t *testing.T) {
+	withTempCacheDir(t)
+	require.NoError(t, writeMailboxes(mailboxesCacheFilename(),
+		[]string{"INBOX", "Sent", "[Gmail]/All Mail"}))
+
+	var tests = []struct {
+		expected string
+		given    string
+	}{
+		{"INBOX", "INBOX"},
+		{"INBOX", "#1"},
+		{"[Gmail]/All Mail", "#3"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			actual, err := resolveMailbox(tt.given)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_resolveMailboxShouldFailOnBadIndex(t *testing.T) {
+	withTempCacheDir(t)
+
+	_, err := resolveMailbox("#1")
+	assert.EqualError(t, err, "no mailbox list found: run with -list-mailboxes first")
+
+	require.NoError(t, writeMailboxes(mailboxesCacheFilename(), []string{"INBOX"}))
+
+	_, err = resolveMailbox("#2")
+	assert.EqualError(t, err, "mailbox index #2 out of range: 1 mailboxes listed")
+	_, err = resolveMailbox("#0")
+	assert.EqualError(t, err, "mailbox index #0 out of range: 1 mailboxes listed")
+	_, err = resolveMailbox("#foo")
+	assert.Error(t, err)
+}
+
+func Test_mailboxNames(t *testing.T) {
+	defer func(mbox string) { *mboxArg = mbox }(*mboxArg)
+
+	var tests = []struct {
+		expected []string
+		given    string
+	}{
+		{[]string{"INBOX"}, "INBOX"},
+		{[]string{"INBOX", "Sent"}, "INBOX,Sent"},
+		{[]string{"INBOX", "Sent"}, "INBOX, Sent,"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			*mboxArg = tt.given
+			assert.Equal(t, tt.expected, mailboxNames())
+		})
+	}
+}
+
+func Test_cacheFilenameShouldNotDependOnOrderOfMailboxes(t *testing.T) {
+	defer func(mbox string) { *mboxArg = mbox }(*mboxArg)
+
+	*mboxArg = "INBOX"
+	single := cacheFilename()
+	*mboxArg = "Sent,INBOX"
+	multi := cacheFilename()
+	*mboxArg = "INBOX,Sent"
+
+	assert.Equal(t, multi, cacheFilename())
+	assert.NotEqual(t, single, multi)
+}
+
+func Test_fetchStatsShouldNestStatsOfSeveralMailboxes(t *testing.T) {
+	withTempCacheDir(t)
+	defer func(maildir, mbox string) { *maildirArg, *mboxArg = maildir, mbox }(*maildirArg, *mboxArg)
+	// the maildir is served as INBOX and its .Archive folder as Archive
+	*maildirArg, *mboxArg = "testdata/maildir", "INBOX,Archive"
+
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		*userArg: {
+			"INBOX": {
+				"flagged_count": &criteriaCfg{WithFlags: []string{imap.FlaggedFlag}},
+			},
+			"Archive": {
+				"boss_count": &criteriaCfg{AnySeen: true, Headers: map[string]string{"From": "boss@corp.com"}},
+			},
+		},
+	}}
+	st, err := fetchStats(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "INBOX,Archive", *mboxArg)
+
+	assert.Equal(t, stats{
+		"INBOX":   stats{"unseen_count": 2, "flagged_count": 1, "uid_validity": 1},
+		"Archive": stats{"unseen_count": 0, "boss_count": 1, "uid_validity": 1},
+	}, withoutCacheOnly(st))
+
+	st, err = postprocessStats(cfg, st, &statsFilter{pattern: "*_count", op: ">", value: 0})
+	require.NoError(t, err)
+	assert.Equal(t, stats{
+		"INBOX":   stats{"unseen_count": 2, "flagged_count": 1},
+		"Archive": stats{"boss_count": 1},
+	}, withoutCacheOnly(st))
+}
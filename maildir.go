@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+// credentials of the only user of the memory backend
+const (
+	memoryUser     = "username"
+	memoryPassword = "password"
+)
+
+// maildirFlags maps flags of the Maildir info suffix to IMAP flags.
+// Lowercase keyword letters are local to a Maildir and are ignored.
+var maildirFlags = map[rune]string{
+	'D': imap.DraftFlag,
+	'F': imap.FlaggedFlag,
+	'R': imap.AnsweredFlag,
+	'S': imap.SeenFlag,
+	'T': imap.DeletedFlag,
+}
+
+// parseMaildirFlags returns IMAP flags encoded in a Maildir file name like 1234.host:2,FS
+func parseMaildirFlags(name string) []string {
+	i := strings.LastIndex(name, ":2,")
+	if i < 0 {
+		return nil
+	}
+	var flags []string
+	for _, r := range name[i+3:] {
+		if f, found := maildirFlags[r]; found {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// readMaildir reads messages of a Maildir. Messages in new are recent and unseen,
+// the modification time of a file is its received date.
+func readMaildir(dir string) ([]*memory.Message, error) {
+	var msgs []*memory.Message
+	for _, sub := range []string{"cur", "new"} {
+		files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, sub, fi.Name()))
+			if err != nil {
+				return nil, err
+			}
+			flags := parseMaildirFlags(fi.Name())
+			if sub == "new" {
+				flags = append(flags, imap.RecentFlag)
+			}
+			msgs = append(msgs, &memory.Message{
+				Date:  fi.ModTime(),
+				Size:  uint32(len(b)),
+				Flags: flags,
+				Body:  b,
+			})
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Date.Before(msgs[j].Date) })
+	for i, m := range msgs {
+		m.Uid = uint32(i + 1)
+	}
+	return msgs, nil
+}
+
+// maildirFolders returns Maildir++ folders of a Maildir by mailbox name: the Maildir itself
+// is INBOX, its subdirectories like .Archive.2021 are Archive/2021.
+func maildirFolders(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]string{"INBOX": dir}
+	for _, fi := range files {
+		name := fi.Name()
+		if !fi.IsDir() || !strings.HasPrefix(name, ".") || name == "." || name == ".." {
+			continue
+		}
+		res[strings.ReplaceAll(name[1:], ".", memory.Delimiter)] = filepath.Join(dir, name)
+	}
+	return res, nil
+}
+
+// dialMaildir serves messages of a Maildir and its folders as mailboxes of an in-process
+// IMAP server and returns a client logged in to it, so that criteria are evaluated as with
+// a real server. The server is closed once the client logs out.
+func dialMaildir(dir string) (*client.Client, error) {
+	folders, err := maildirFolders(dir)
+	if err != nil {
+		return nil, err
+	}
+	be := memory.New()
+	user, err := be.Login(nil, memoryUser, memoryPassword)
+	if err != nil {
+		return nil, err
+	}
+	for name, folder := range folders {
+		msgs, err := readMaildir(folder)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := user.GetMailbox(name); err != nil {
+			if err := user.CreateMailbox(name); err != nil {
+				return nil, err
+			}
+		}
+		mbox, err := user.GetMailbox(name)
+		if err != nil {
+			return nil, err
+		}
+		mbox.(*memory.Mailbox).Messages = msgs
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := server.New(anyUserBackend{be})
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	go func() {
+		<-c.LoggedOut()
+		s.Close()
+	}()
+	countConn(*userArg, connOpened)
+	if err := c.Login(*userArg, ""); err != nil {
+		c.Logout()
+		return nil, err
+	}
+	countConn(*userArg, connLogins)
+	return c, nil
+}
+
+// anyUserBackend is the memory backend that logs in any user as its only one
+type anyUserBackend struct {
+	*memory.Backend
+}
+
+func (b anyUserBackend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(conn, memoryUser, memoryPassword)
+	if err != nil {
+		return nil, err
+	}
+	return maildirUser{user}, nil
+}
+
+// maildirUser is a backend user with mailboxes reporting UNSEEN in STATUS responses;
+// the memory backend always reports 0
+type maildirUser struct {
+	backend.User
+}
+
+func (u maildirUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return maildirMailbox{mbox}, nil
+}
+
+type maildirMailbox struct {
+	backend.Mailbox
+}
+
+func (m maildirMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	status, err := m.Mailbox.Status(items)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := status.Items[imap.StatusUnseen]; ok {
+		unseen := imap.NewSearchCriteria()
+		unseen.WithoutFlags = []string{imap.SeenFlag}
+		ids, err := m.Mailbox.SearchMessages(false, unseen)
+		if err != nil {
+			return nil, err
+		}
+		status.Unseen = uint32(len(ids))
+	}
+	return status, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseMaildirFlags(t *testing.T) {
+	var tests = []struct {
+		given    string
+		expected []string
+	}{
+		{"1234.host", nil},
+		{"1234.host:2,", nil},
+		{"1234.host:2,S", []string{imap.SeenFlag}},
+		{"1234.host:2,FRSa", []string{imap.FlaggedFlag, imap.AnsweredFlag, imap.SeenFlag}},
+		{"1234.host:2,DT", []string{imap.DraftFlag, imap.DeletedFlag}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseMaildirFlags(tt.given))
+		})
+	}
+}
+
+func Test_fetchStatsShouldEvaluateCriteriaAgainstMaildir(t *testing.T) {
+	defer func(maildir, mbox string) { *maildirArg, *mboxArg = maildir, mbox }(*maildirArg, *mboxArg)
+	*maildirArg, *mboxArg = "testdata/maildir", "INBOX"
+
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		*userArg: {"INBOX": {
+			"seen_count":    &criteriaCfg{Seen: true},
+			"flagged_count": &criteriaCfg{WithFlags: []string{imap.FlaggedFlag}},
+			"boss_count":    &criteriaCfg{AnySeen: true, Headers: map[string]string{"From": "boss@corp.com"}},
+			"late_count":    &criteriaCfg{AnySeen: true, SentSince: "2021-02-03"},
+		}},
+	}}
+	st, err := fetchStats(cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, stats{
+		"unseen_count":  2,
+		"seen_count":    2,
+		"flagged_count": 1,
+		"boss_count":    2,
+		"late_count":    2,
+		"uid_validity":  1,
+	}, withoutCacheOnly(st))
+}
+
+func Test_maildirFolders(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{".Archive", ".Archive.2021", "cur", "new"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0700))
+	}
+
+	actual, err := maildirFolders(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"INBOX":        dir,
+		"Archive":      filepath.Join(dir, ".Archive"),
+		"Archive/2021": filepath.Join(dir, ".Archive.2021"),
+	}, actual)
+}
+
+func Test_dialMaildirShouldFailOnMissingMaildir(t *testing.T) {
+	_, err := dialMaildir(t.TempDir())
+	assert.Error(t, err)
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,13 +14,18 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message/charset"
 	"gopkg.in/yaml.v3"
 )
 
@@ -25,64 +35,342 @@ const (
 	configName = "config.yaml"
 
 	defaultDirPerms = 0700
+	cachePerms      = 0600
 
 	ttlInfinite time.Duration = -1
 
-	imapTimeout = 20 * time.Second
+	defaultIMAPTimeout = 20 * time.Second
 
-	maxMailFetchCount = 10
+	defaultFetchLimit = 10
+
+	defaultMaxLetters = 1000
+
+	defaultConcurrency = 4
+
+	defaultJunkCap = 99
 
 	// /usr/include/sysexits.h:101: EX_UNAVAILABLE - service unavailable
 	exitUnavailable = 69
+
+	metaKey = "_meta"
 )
 
+var errSearchTimeout = errors.New("timeout")
+
+// imapClient is the subset of *client.Client used to collect stats
+type imapClient interface {
+	Search(criteria *imap.SearchCriteria) ([]uint32, error)
+	Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
+	Select(name string, readOnly bool) (*imap.MailboxStatus, error)
+	Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error)
+}
+
 var (
 	appHomeDir string
 	cacheDir   string
 
 	// CLI args
 	addrArg       = flag.String("addr", "imap.gmail.com:993", "IMAP user")
-	userArg       = flag.String("user", "", "IMAP user")
+	userArg       = flag.String("user", "", "IMAP user. If empty, stats of all configured accounts are keyed by account")
 	passwordArg   = flag.String("pass", "", "IMAP password")
-	mboxArg       = flag.String("mailbox", "INBOX", "mailbox on the server")
+	mboxArg       = flag.String("mailbox", "INBOX", "mailbox on the server, several comma separated ones nest stats under their names. #N refers to the Nth mailbox of the last -list-mailboxes")
 	quietArg      = flag.Bool("q", false, "If set, does not output stats on stdin. Can be used in background jobs to update cache")
 	writeCacheArg = flag.Bool("write-cache", false, "if true writes to cache")
 	readCacheArg  = flag.Bool("read-cache", false, "if true reads from cache")
 	ttlArg        = flag.String("ttl", "",
 		"sets cache ttl. By default no ttl is set. Default unit is seconds, hours and minues are also supported e.g. 2h; 35m")
+	formatArg         = flag.String("format", formatJSON, "output format: json, flat, text, prometheus, nagios or waybar. flat outputs numeric stats under account.mailbox.key keys, text as sorted key value lines, prometheus as gauges in text exposition format, nagios as a check status line with perfdata exiting with its state, waybar as JSON of a status bar module")
+	profileArg        = flag.String("profile", "", "if set, keeps cache files in a separate directory named after the profile")
+	tzArg             = flag.String("tz", "", "timezone for day based criteria, e.g. Europe/Berlin. Local timezone by default")
+	lintArg           = flag.Bool("lint", false, "if true, checks the config for problems without connecting to the server")
+	listMailboxesArg  = flag.Bool("list-mailboxes", false, "if true, lists mailboxes on the server and exits")
+	maxConnectionsArg = flag.Int("max-connections", 0, "if set, limits the number of simultaneously open IMAP connections")
+	verboseArg        = flag.Bool("v", false, "if true, logs debug messages")
+	execArg           = flag.String("exec", "", "if set, pipes stats to stdin of a given shell command, e.g. a custom notifier")
+	filterArg         = flag.String("filter", "", "if set, outputs only stats matching an expression like KEY>5. KEY can be a glob; >, <, >=, <=, == are supported")
+	sqliteArg         = flag.String("sqlite", "", "if set, also stores numeric stats into a given SQLite database")
+	hashArg           = flag.Bool("hash", false, "if true, adds a hash of numeric stats under _hash key to cheaply detect changes")
+	metaArg           = flag.Bool("meta", false, "if true, adds mailbox metadata such as UIDVALIDITY under _meta key")
+	nestedOutputArg   = flag.Bool("nested-output", false,
+		"if true, fetched messages are nested with their count under a single key instead of a separate <key>_messages")
+	bestEffortArg = flag.Bool("best-effort", false,
+		"if true, criteria that time out are reported as <key>_error instead of failing the whole run")
+	reportArg           = flag.String("report", "", "if set, writes a JSON report of the run with timings, warnings and errors to a given file")
+	rateLimitRetriesArg = flag.Int("rate-limit-retries", 0, "how many times to retry connecting if the server reports rate limiting")
+	rateLimitDelayArg   = flag.Duration("rate-limit-delay", 30*time.Second, "initial delay between rate limit retries; doubles on each retry")
+	foldCaseArg         = flag.Bool("fold-case", false,
+		"recheck body matches of the server case-insensitively against fetched messages; counts over the fetch limit are taken from the server")
+	noNewlineArg       = flag.Bool("no-newline", false, "do not terminate written stats with a newline")
+	crlfArg            = flag.Bool("crlf", false, "terminate written stats with CRLF instead of LF")
+	rateArg            = flag.String("rate", "", "if set, limits IMAP commands issued per account, e.g. 30/m; s, m and h periods are supported")
+	warmArg            = flag.Bool("warm", false, "if true, refreshes caches of all configured mailboxes of all configured accounts, or -mailbox of -user without them, and exits")
+	defaultStatKeyArg  = flag.String("default-stat-key", "unseen_count", "key of the default stat counting unseen messages")
+	secretsArg         = flag.String("secrets", "", "YAML or JSON file mapping accounts to passwords; takes precedence over -pass")
+	maxOutputBytesArg  = flag.Int("max-output-bytes", 0, "if set, limits the size of written stats; see -max-output-policy")
+	maxOutputPolicyArg = flag.String("max-output-policy", outputTruncate,
+		"what to write if stats exceed -max-output-bytes: truncate drops fetched messages, refuse writes an error marker only")
+	graphiteArg           = flag.String("graphite", "", "if set, sends numeric stats to Graphite plaintext protocol listener at a given host:port")
+	explainArg            = flag.String("explain", "", "if set, prints resolved criteria of a given stat key and its IMAP search, then exits without connecting")
+	snippetMaxArg         = flag.Int("snippet-max", previewLength, "max length of message previews in characters")
+	snippetBudgetArg      = flag.Int("snippet-budget", 0, "if set, drops message previews when stats with them take more bytes than a given number")
+	inArg                 = flag.String("in", "", "if set to -, reads cached stats from stdin and writes them in -format without connecting")
+	maildirArg            = flag.String("maildir", "", "if set, evaluates criteria against messages of a local Maildir instead of connecting to the server; the Maildir is INBOX and its Maildir++ folders like .Archive are other mailboxes")
+	strictArg             = flag.Bool("strict", false, "if true, exits with an error after writing stats if any warning was reported, e.g. for CI")
+	concurrencyArg        = flag.Int("concurrency", defaultConcurrency, "how many connections to collect stats of a mailbox with at once; bounded by -max-connections. Each connection above 1 is another login per run, reused across mailboxes; -daemon and -serve use 1")
+	rawCommandArg         = flag.String("raw-command", "", "if set, sends a given IMAP command after examining -mailbox, prints responses and exits; for diagnostics")
+	unsafeRawCommandArg   = flag.Bool("i-know-what-im-doing", false, "allows -raw-command to send commands that are not known to be read-only")
+	passEnvArg            = flag.String("pass-env", "", "if set, reads the IMAP password from a given environment variable; it takes precedence over -secrets and -pass")
+	passCommandArg        = flag.String("pass-command", "", "if set, reads the IMAP password from stdout of a given shell command like pass show imap; it takes precedence over -pass-env, -secrets and -pass")
+	oauthTokenArg         = flag.String("oauth-token", "", "if set, authenticates with XOAUTH2 using an OAuth2 access token read from a given file, or from stdout of a command after !, instead of the password")
+	starttlsArg           = flag.Bool("starttls", false, "if true, connects in plain text, e.g. to port 143, and upgrades the connection with STARTTLS instead of implicit TLS")
+	caCertArg             = flag.String("ca-cert", "", "if set, trusts CA certificates of a given PEM file in addition to system ones, e.g. of a private CA")
+	insecureSkipVerifyArg = flag.Bool("insecure-skip-verify", false, "if true, does not verify the certificate of the server; for testing only")
+	idleTimeoutArg        = flag.Duration("idle-timeout", 0, "if set, fails a command once its connection makes no progress for a given time, e.g. 1m; slow but flowing responses are not cut")
+	retriesArg            = flag.Int("retries", 0, "how many times to retry connecting and logging in on network failures like timeouts")
+	retryDelayArg         = flag.Duration("retry-delay", time.Second, "initial delay between -retries; doubles on each retry")
+	timeoutArg            = flag.String("timeout", "20s", "timeout of connecting to servers and of each command unless -idle-timeout is set, like 10s, 2m or 1h; a number without unit is seconds")
+	fallbackCacheArg      = flag.Bool("fallback-cache", false, "if true, outputs cached stats of the last -write-cache run when fetching fails with a network error, e.g. offline; -ttl is ignored")
+	fallbackTTLArg        = flag.String("fallback-ttl", "", "if set, limits the age of cached stats -fallback-cache outputs like 12h; any age by default")
+	templateArg           = flag.String("template", "", "if set, renders stats with a Go text/template file instead of -format; count, sum and date functions are available besides builtin ones")
+	refreshArg            = flag.Bool("refresh", false, "if true, -read-cache also starts refreshing the cache in background for the next run unless it is within -ttl or already refreshed; its log is kept next to the cache")
+	dateFormatArg         = flag.String("date-format", time.RFC3339, "Go layout of dates of fetched letters, e.g. \"Jan 2 15:04\"")
+	fetchLimitArg         = flag.Int("fetch-limit", defaultFetchLimit, "max number of messages fetched per criteria unless it sets fetch_limit; 0 means all of them")
+	maxLettersArg         = flag.Int("max-letters", defaultMaxLetters, "max number of fetched messages kept in memory for output per criteria, also if fetch_limit is 0; others are still counted. 0 means no limit")
+	lettersToArg          = flag.String("letters-to", "", "if set, appends each fetched message to this file as a JSON line as soon as it arrives instead of keeping it for output; counts are output as usual")
+	junkCapArg            = flag.Int("junk-cap", defaultJunkCap, "cap of counts of criteria without cap of their own in the mailbox with SPECIAL-USE \\Junk attribute, e.g. reported as 99+. 0 disables it")
+	includeTotalsArg      = flag.Bool("include-totals", false, "if true, adds total_count, recent_count and uid_next of the mailbox as reported by SELECT unless configured stats use these keys")
+	validateArg           = flag.Bool("validate", false, "if true, loads the config like a run does, prints its accounts, mailboxes and criteria and exits without connecting; -lint lists all problems")
+	daemonArg             = flag.Bool("daemon", false, "if true, stays resident and refreshes the cache every -interval like -write-cache, keeping connections logged in between cycles; SIGINT or SIGTERM logs out and exits")
+	intervalArg           = flag.String("interval", "60s", "how often -daemon refreshes stats: number of seconds, minutes or hours like 30s, 5m or 1h")
+	idleArg               = flag.Bool("idle", false, "if true, -daemon also refreshes stats once the server reports new mail, expunges or flag changes of the mailbox with IDLE; servers without IDLE are polled every -interval")
+	serveArg              = flag.String("serve", "", "if set, serves stats at a given address like :8080 over HTTP: /stats as JSON, /metrics in Prometheus text format and /healthz telling whether the last fetch succeeded. Stats are fetched once cached ones are older than -ttl")
 )
 
 type letter struct {
-	Date    string `json:"date"`
-	Subject string `json:"subject"`
+	Date    string `json:"date,omitempty"`
+	From    string `json:"from,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Preview string `json:"preview,omitempty"`
+
+	// time is the date Date is formatted from for display, kept for templates
+	time time.Time
+}
+
+// fetchedStat combines a count with the fetched messages under a single key
+type fetchedStat struct {
+	Count    int       `json:"count"`
+	Messages []*letter `json:"messages"`
 }
 
 type stats map[string]interface{}
 
 type criteriaCfg struct {
-	Seen    bool              `yaml:"seen"`
-	Body    []string          `yaml:"body"`
-	Headers map[string]string `yaml:"headers"`
+	// Seen matches seen messages only; unseen ones are matched by default
+	Seen    bool              `yaml:"seen,omitempty"`
+	Body    []string          `yaml:"body,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// AnySeen matches both seen and unseen messages. Every OR clause filters
+	// by seen flag on its own, so set it on clauses too to match any message.
+	AnySeen bool `yaml:"any_seen,omitempty"`
+
+	Or []criteriaCfg `yaml:"or,omitempty"`
+
+	// Not excludes messages matching given criteria. Unlike OR clauses, it does not
+	// filter by seen flag on its own unless seen is set in it.
+	Not *criteriaCfg `yaml:"not,omitempty"`
+
+	// WithFlags and WithoutFlags match messages by flags like \Flagged or custom keywords.
+	// If either is set, unseen messages are no longer matched by default;
+	// seen adds \Seen to WithFlags and any_seen has no effect.
+	WithFlags    []string `yaml:"with_flags,omitempty"`
+	WithoutFlags []string `yaml:"without_flags,omitempty"`
+
+	// Recent matches messages with \Recent flag. Servers reset the flag once any session
+	// sees a message, so recent counts are unreliable across runs. RecentMode tells where
+	// the count comes from: status (default) takes RECENT of the mailbox STATUS and can not
+	// be combined with other criteria, search searches for the flag and ignore omits the stat.
+	Recent     bool   `yaml:"recent,omitempty"`
+	RecentMode string `yaml:"recent_mode,omitempty"`
+
+	Fetch bool `yaml:"fetch,omitempty"`
+	// FetchLimit bounds the number of fetched messages; 0 means all of them.
+	// -fetch-limit applies if it is not set.
+	FetchLimit *int `yaml:"fetch_limit,omitempty"`
+	// Fields are letter fields to output: date, from, subject and preview; all by default
+	Fields []string `yaml:"fields,omitempty"`
+	// Preview adds the beginning of message text to fetched letters
+	Preview bool `yaml:"preview,omitempty"`
+	// UseInternalDate makes fetched messages report the date the server
+	// received them instead of the Date header set by the sender
+	UseInternalDate bool `yaml:"use_internaldate,omitempty"`
+	// GroupBy additionally reports counts of fetched messages grouped by a given field
+	GroupBy string `yaml:"group_by,omitempty"`
+	// FromAddress picks addresses of messages with several From ones: first, last or all
+	FromAddress string `yaml:"from_address,omitempty"`
+	// NewOnly lists only messages newer than the newest one fetched by the previous run
+	// according to -write-cache; the count is not affected
+	NewOnly bool `yaml:"new_only,omitempty"`
+	// Duplicates additionally reports how many fetched messages share Message-ID with another one
+	Duplicates bool `yaml:"duplicates,omitempty"`
 
-	Or []criteriaCfg `yaml:"or"`
+	// UIDRange restricts the search to messages with given UIDs, e.g. 1000:2000 or 1,5:*
+	UIDRange string `yaml:"uid_range,omitempty"`
+	// UIDsFile restricts the search to messages with UIDs listed in a given file
+	UIDsFile string `yaml:"uids_file,omitempty"`
+	uids     *imap.SeqSet
 
-	Fetch bool `yaml:"fetch"`
+	// Today and Yesterday restrict the search to the given day in -tz timezone
+	Today     bool `yaml:"today,omitempty"`
+	Yesterday bool `yaml:"yesterday,omitempty"`
+
+	// Younger and Older restrict the search to messages received within a given time.
+	// Servers without WITHIN extension, and OR or NOT clauses, are asked for whole days instead.
+	Younger time.Duration `yaml:"younger,omitempty"`
+	Older   time.Duration `yaml:"older,omitempty"`
+
+	// Since and Before restrict the search by the date the server received messages,
+	// SentSince and SentBefore by the Date header. Each is either an RFC3339 date
+	// or a number of days or weeks ago, e.g. 7d or 2w.
+	Since      string `yaml:"since,omitempty"`
+	Before     string `yaml:"before,omitempty"`
+	SentSince  string `yaml:"sent_since,omitempty"`
+	SentBefore string `yaml:"sent_before,omitempty"`
+
+	// LargerThan and SmallerThan restrict the search by message size like 5M or 200k; 0 means no limit
+	LargerThan  string `yaml:"larger_than,omitempty"`
+	SmallerThan string `yaml:"smaller_than,omitempty"`
+
+	// SplitBySeen additionally reports <key>_seen and <key>_unseen counts
+	SplitBySeen bool `yaml:"split_by_seen,omitempty"`
+
+	// GmailRaw additionally matches messages by a Gmail search like has:attachment older_than:7d
+	// with X-GM-RAW; servers other than Gmail fail such criteria
+	GmailRaw string `yaml:"gmail_raw,omitempty"`
+
+	// Mailbox, if set, makes this criteria search in a given mailbox instead of -mailbox
+	Mailbox string `yaml:"mailbox,omitempty"`
+
+	// Timeout bounds the search of this criteria; 0 means no timeout
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// TTL lets -write-cache reuse stats of this criteria collected by a previous run until
+	// they get older than TTL, e.g. for heavy stats; 0 means collecting them on every run
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	// Priority orders stats by importance in _order list, higher first
+	Priority int `yaml:"priority,omitempty"`
+
+	// Threshold makes a run exit with code 10 once stats are written if the count
+	// compared with it by ThresholdOp holds; one of > (default), >=, <, <= and ==
+	Threshold   *int   `yaml:"threshold,omitempty"`
+	ThresholdOp string `yaml:"threshold_op,omitempty"`
+	// Warning and Critical are levels of -format nagios compared like Threshold,
+	// which is the critical level unless set
+	Warning  *int `yaml:"warning,omitempty"`
+	Critical *int `yaml:"critical,omitempty"`
+
+	// Cap reports counts above it as e.g. 99+, useful for noisy folders like Junk.
+	// The exact count is reported as <key>_raw. The \Junk mailbox gets -junk-cap by default.
+	Cap int `yaml:"cap,omitempty"`
+
+	// Use makes this criteria an instance of a given template with ${name} parameters set from Args
+	Use  string            `yaml:"use,omitempty"`
+	Args map[string]string `yaml:"args,omitempty"`
 }
 
 func (cr *criteriaCfg) toIMAP() *imap.SearchCriteria {
 	res := imap.NewSearchCriteria()
-	if !cr.Seen {
+	if cr.Seen {
+		res.WithFlags = []string{imap.SeenFlag}
+	} else if !cr.AnySeen && !cr.hasFlags() {
 		res.WithoutFlags = []string{imap.SeenFlag}
 	}
+	res.WithFlags = append(res.WithFlags, cr.WithFlags...)
+	if cr.Recent {
+		res.WithFlags = append(res.WithFlags, imap.RecentFlag)
+	}
+	res.WithoutFlags = append(res.WithoutFlags, cr.WithoutFlags...)
 	res.Body = cr.Body
 	for k, v := range cr.Headers {
 		res.Header.Add(k, v)
 	}
+	if cr.UIDRange != "" {
+		// validated with the config
+		res.Uid, _ = imap.ParseSeqSet(cr.UIDRange)
+	}
+	if cr.uids != nil {
+		res.Uid = cr.uids
+	}
+	if cr.Today {
+		res.Since, res.Before = dayWindow(now(), 0)
+	}
+	if cr.Yesterday {
+		res.Since, res.Before = dayWindow(now(), 1)
+	}
+	if cr.Younger > 0 {
+		res.Since = now().Add(-cr.Younger)
+	}
+	if cr.Older > 0 {
+		res.Before = now().Add(-cr.Older)
+	}
+	// dates are validated with the config
+	if cr.Since != "" {
+		res.Since, _ = parseDate(cr.Since, now())
+	}
+	if cr.Before != "" {
+		res.Before, _ = parseDate(cr.Before, now())
+	}
+	if cr.SentSince != "" {
+		res.SentSince, _ = parseDate(cr.SentSince, now())
+	}
+	if cr.SentBefore != "" {
+		res.SentBefore, _ = parseDate(cr.SentBefore, now())
+	}
+	// sizes are validated with the config
+	if cr.LargerThan != "" {
+		res.Larger, _ = parseSize(cr.LargerThan)
+	}
+	if cr.SmallerThan != "" {
+		res.Smaller, _ = parseSize(cr.SmallerThan)
+	}
 	mkORclause(res, cr.Or)
+	if cr.Not != nil {
+		not := *cr.Not
+		not.AnySeen = !not.Seen
+		res.Not = append(res.Not, not.toIMAP())
+	}
+
+	return res
+}
+
+// hasFlags tells whether cr matches messages by explicitly listed flags
+func (cr *criteriaCfg) hasFlags() bool {
+	return len(cr.WithFlags) > 0 || len(cr.WithoutFlags) > 0 || cr.Recent
+}
 
+func removeFlag(flags []string, flag string) []string {
+	res := []string{}
+	for _, f := range flags {
+		if f != flag {
+			res = append(res, f)
+		}
+	}
 	return res
 }
 
+// splitBySeen returns search criteria for seen and unseen messages matched by cr
+func (cr *criteriaCfg) splitBySeen() (seen *imap.SearchCriteria, unseen *imap.SearchCriteria) {
+	seen, unseen = cr.toIMAP(), cr.toIMAP()
+	seen.WithoutFlags = removeFlag(seen.WithoutFlags, imap.SeenFlag)
+	seen.WithFlags = append(removeFlag(seen.WithFlags, imap.SeenFlag), imap.SeenFlag)
+	unseen.WithFlags = removeFlag(unseen.WithFlags, imap.SeenFlag)
+	unseen.WithoutFlags = append(removeFlag(unseen.WithoutFlags, imap.SeenFlag), imap.SeenFlag)
+	return
+}
+
+// mkORclause adds a balanced tree of OR keys matching any of given criteria,
+// so that the nesting grows logarithmically with the number of clauses
 func mkORclause(sc *imap.SearchCriteria, or []criteriaCfg) {
 	if len(or) == 0 {
 		return
@@ -90,41 +378,52 @@ func mkORclause(sc *imap.SearchCriteria, or []criteriaCfg) {
 	if len(or) == 1 {
 		panic("OR criteria can't have 1 criterion")
 	}
-	if len(or) == 2 {
-		sc.Or = append(sc.Or, [2]*imap.SearchCriteria{})
-		sc.Or[0][0] = or[0].toIMAP()
-		sc.Or[0][1] = or[1].toIMAP()
-		return
-	}
-	sc.Or = append(sc.Or, [2]*imap.SearchCriteria{})
-	sc.Or[0][0] = or[0].toIMAP()
-	sc.Or[0][1] = imap.NewSearchCriteria()
+	half := len(or) / 2
+	sc.Or = append(sc.Or, [2]*imap.SearchCriteria{orBranch(or[:half]), orBranch(or[half:])})
+}
 
-	mkORclause(sc.Or[0][1], or[1:])
+// orBranch returns a single clause as is and several ones as a nested OR
+func orBranch(or []criteriaCfg) *imap.SearchCriteria {
+	if len(or) == 1 {
+		return or[0].toIMAP()
+	}
+	sc := imap.NewSearchCriteria()
+	mkORclause(sc, or)
+	return sc
 }
 
 type statsConfig map[string]*criteriaCfg
 
 type config struct {
 	Accounts map[string]map[string]statsConfig `yaml:"accounts"`
+
+	// Logins are server addresses and credentials given next to mailboxes of accounts
+	Logins map[string]loginCfg `yaml:"-"`
+
+	// Summaries are named sums of stats across accounts and mailboxes
+	Summaries map[string][]summarySelector `yaml:"summaries"`
+
+	// Templates are parameterized criteria referenced from stats with use
+	Templates map[string]yaml.Node `yaml:"templates"`
+
+	// Computed are stats evaluated from arithmetic expressions over other stats
+	Computed map[string]string `yaml:"computed"`
 }
 
+// validate fails on the first problem of the config; advisory ones are warned about only
 func (c *config) validate() error {
-	for _, acc := range c.Accounts {
-		for _, cfg := range acc {
-			for _, cr := range cfg {
-				if len(cr.Or) == 1 {
-					return fmt.Errorf("bad config: OR criteria must have 2 clauses")
-				}
-			}
+	for _, p := range c.lint() {
+		if !p.advisory {
+			return fmt.Errorf("%w: %s", errBadConfig, p)
 		}
+		warnf("config: %s", p)
 	}
 	return nil
 }
 
 func (c *config) getStatsCfg(user string, mailBox string) statsConfig {
 	// unseen count added by default
-	defaultCfg := statsConfig{"unseen_count": &criteriaCfg{}}
+	defaultCfg := statsConfig{*defaultStatKeyArg: &criteriaCfg{}}
 
 	mboxes := c.Accounts[user]
 	if mboxes == nil {
@@ -134,19 +433,24 @@ func (c *config) getStatsCfg(user string, mailBox string) statsConfig {
 	if cfg == nil {
 		return defaultCfg
 	}
-	if cfg["unseen_count"] == nil {
-		cfg["unseen_count"] = &criteriaCfg{}
+	if cfg[*defaultStatKeyArg] == nil {
+		cfg[*defaultStatKeyArg] = &criteriaCfg{}
 	}
 	return cfg
 }
 
 func init() {
 	log.SetFlags(0)
+	// go-imap decodes encoded-words of envelopes itself but only knows UTF-8 and ISO-8859-1
+	imap.CharsetReader = charset.Reader
 
 	must(initPaths())
 }
 
 func errorToExitCode(err error) int {
+	if code, ok := loginExitCode(err); ok {
+		return code
+	}
 	if os.IsTimeout(err) {
 		return exitUnavailable
 	}
@@ -162,12 +466,21 @@ func dieOnNetError(v ...interface{}) {
 	for _, it := range v {
 		switch err := it.(type) {
 		case error:
+			dieUnknown(err)
 			log.Printf("fatal: dieOnNetError: %T %s", err, err)
 			os.Exit(errorToExitCode(err))
 		}
 	}
 }
 
+func dieOnLoginError(err error) {
+	if code, ok := loginExitCode(err); ok {
+		dieUnknown(err)
+		log.Printf("fatal: %s", err)
+		os.Exit(code)
+	}
+}
+
 type nwTimeoutFatalLogger struct{}
 
 func (l *nwTimeoutFatalLogger) Printf(format string, v ...interface{}) {
@@ -196,197 +509,1437 @@ func initPaths() error {
 	return nil
 }
 
-func dialAndLogin(passwd string) (*client.Client, error) {
-	dialer := &net.Dialer{Timeout: imapTimeout}
-	c, err := client.DialWithDialerTLS(dialer, *addrArg, nil)
+// dialAndLogin connects to the server of an account and logs in with a given password,
+// or authenticates with XOAUTH2 if the account uses an OAuth token and secret is one
+func dialAndLogin(account string, l loginCfg, secret string) (*client.Client, error) {
+	var dialer client.Dialer = &net.Dialer{Timeout: imapTimeout()}
+	var idle *idleDialer
+	if *idleTimeoutArg > 0 {
+		idle = &idleDialer{dialer: &net.Dialer{Timeout: imapTimeout()}, idle: *idleTimeoutArg}
+		dialer = idle
+	}
+	tlsCfg, err := tlsConfig(l.Addr)
+	if err != nil {
+		return nil, err
+	}
+	connLimit.acquire()
+	var c *client.Client
+	if l.StartTLS {
+		c, err = dialStartTLS(dialer, l.Addr, tlsCfg)
+	} else {
+		c, err = client.DialWithDialerTLS(dialer, l.Addr, tlsCfg)
+	}
+	if err != nil {
+		connLimit.release()
+		return nil, err
+	}
+	watchChanges(c)
+	if idle != nil {
+		trackIdle(c, idle.conn)
+	} else {
+		// the dialer bounds connecting only; -idle-timeout bounds commands on its own
+		c.Timeout = imapTimeout()
+	}
+	connLimit.releaseOnLogout(c.LoggedOut())
+	countConn(account, connOpened)
+
+	if l.OAuthToken != "" {
+		err = authenticateXOAuth2(c, l.User, secret)
+	} else {
+		err = classifyLoginError(c.Login(l.User, secret))
+	}
 	if err != nil {
+		c.Logout()
 		return nil, err
 	}
+	countConn(account, connLogins)
 
 	// HACK: go-imap tries to be smart and handle timeouts itself.
 	// Wich does not work well for cli usecase.
 	// However it reports such erros to custom logger. This logger simply
-	// aborts on network timeouts for now.
+	// aborts on network timeouts for now. It is set only once logged in,
+	// so that failures of dialing and logging in are returned and can be retried.
 	c.ErrorLog = &nwTimeoutFatalLogger{}
+	return c, nil
+}
 
-	if err := c.Login(*userArg, passwd); err != nil {
-		return nil, err
+// login reads the password and connects to the server retrying on rate limits
+func login() (*client.Client, error) {
+	if *maildirArg != "" {
+		return dialMaildir(*maildirArg)
 	}
-	if _, err = c.Select(*mboxArg, false); err != nil {
+	l := accountLogin(*userArg)
+	var secret string
+	var err error
+	if l.OAuthToken != "" {
+		secret, err = readOAuthToken(*userArg, l.OAuthToken)
+	} else {
+		secret, err = readPassword(*userArg)
+	}
+	if err != nil {
 		return nil, err
 	}
-	return c, nil
+	var c *client.Client
+	err = withRateLimitRetry(func() error {
+		return withNetRetry(func() (err error) {
+			c, err = dialAndLogin(*userArg, l, secret)
+			return
+		})
+	})
+	if code, _ := loginExitCode(err); code == exitTokenExpired {
+		forgetOAuthToken(*userArg, l.OAuthToken)
+	}
+	return c, err
 }
 
-func fetchMails(c *client.Client, name string, ids []uint32) ([]*imap.Message, error) {
+// fetchMails fetches fetchItems of cr of the given messages and hands each one to fn
+// as soon as it arrives, so that callers do not have to buffer them all.
+// Over the fetch limit of cr, the newest messages are fetched; sorted tells that ids are newest first already.
+func fetchMails(c imapClient, name string, ids []uint32, sorted bool, cr *criteriaCfg, fn func(*imap.Message) error) error {
 	if len(ids) < 1 {
-		return nil, nil
+		return nil
 	}
-	if len(ids) > maxMailFetchCount {
-		log.Printf("WARN %s: found %d mails; will fetch %d ",
-			name, len(ids), maxMailFetchCount)
-		ids = ids[0:maxMailFetchCount]
+	limit, items := cr.fetchLimit(), cr.fetchItems()
+	if limit > 0 && len(ids) > limit {
+		warnf("%s: found %d mails; will fetch %d",
+			name, len(ids), limit)
+		if sorted {
+			ids = ids[:limit]
+		} else {
+			newest, err := newestIDs(c, ids, limit, cr)
+			if err != nil {
+				return err
+			}
+			ids = newest
+		}
 	}
 	set := &imap.SeqSet{}
 	set.AddNum(ids...)
 	done := make(chan error, 1)
 	msgChan := make(chan *imap.Message, 2)
-	messages := make([]*imap.Message, 0, len(ids))
 	go func() {
-		done <- c.Fetch(set, []imap.FetchItem{imap.FetchEnvelope}, msgChan)
+		done <- c.Fetch(set, items, msgChan)
 	}()
 
+	var fnErr error
 	for msg := range msgChan {
-		messages = append(messages, msg)
+		if fnErr != nil {
+			// keep draining: Fetch blocks until the channel is consumed
+			continue
+		}
+		fnErr = fn(msg)
 	}
 	// TODO: add timeout channel here. Otherwise there is a risk of infinite blocking
 	if err := <-done; err != nil {
+		return fmt.Errorf("%w %T", err, err)
+	}
+	return fnErr
+}
+
+// newestIDs returns limit ids of messages with the newest dates as told by messageDate of cr.
+// Search results come in mailbox order which does not have to follow dates, so dates of all
+// messages are fetched to pick the newest ones: INTERNALDATE with use_internaldate,
+// envelopes otherwise. Messages without a date go last.
+func newestIDs(c imapClient, ids []uint32, limit int, cr *criteriaCfg) ([]uint32, error) {
+	item := imap.FetchEnvelope
+	if cr.UseInternalDate {
+		item = imap.FetchInternalDate
+	}
+	set := &imap.SeqSet{}
+	set.AddNum(ids...)
+	msgChan := make(chan *imap.Message, len(ids))
+	if err := c.Fetch(set, []imap.FetchItem{item}, msgChan); err != nil {
 		return nil, fmt.Errorf("%w %T", err, err)
 	}
-	return messages, nil
+	var msgs []*imap.Message
+	for m := range msgChan {
+		msgs = append(msgs, m)
+	}
+	date := cr.messageDate
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if di, dj := date(msgs[i]), date(msgs[j]); !di.Equal(dj) {
+			return di.After(dj)
+		}
+		return msgs[i].SeqNum > msgs[j].SeqNum
+	})
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	res := make([]uint32, 0, len(msgs))
+	for _, m := range msgs {
+		res = append(res, m.SeqNum)
+	}
+	return res, nil
+}
+
+func (cr *criteriaCfg) fetchItems() []imap.FetchItem {
+	items := []imap.FetchItem{imap.FetchEnvelope}
+	if cr.UseInternalDate {
+		items = append(items, imap.FetchInternalDate)
+	}
+	if cr.foldsCase() {
+		items = append(items, textSection.FetchItem())
+	}
+	if cr.Preview {
+		items = append(items, previewSection.FetchItem())
+	}
+	return items
+}
+
+// fetchLimit returns the max number of messages to fetch; 0 means no limit
+func (cr *criteriaCfg) fetchLimit() int {
+	if cr.FetchLimit != nil {
+		return *cr.FetchLimit
+	}
+	return *fetchLimitArg
+}
+
+// textSection is the message text fetched for client side body checks; peeking keeps messages unseen
+var textSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{Specifier: imap.TextSpecifier},
+	Peek:         true,
+}
+
+// foldsCase tells whether messages the server matched by body are rechecked on the client ignoring case
+func (cr *criteriaCfg) foldsCase() bool {
+	return *foldCaseArg && cr.Fetch && len(cr.Body) > 0
 }
 
-func fetchStats(cfg *config) (stats, error) {
-	passwd, err := readPassword()
+// bodyMatches tells whether the text of m contains all body strings of cr ignoring case
+func (cr *criteriaCfg) bodyMatches(m *imap.Message) (bool, error) {
+	r := m.GetBody(textSection)
+	if r == nil {
+		return false, nil
+	}
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	return containsAllFold(string(b), cr.Body), nil
+}
+
+func containsAllFold(text string, substrs []string) bool {
+	text = strings.ToLower(text)
+	for _, s := range substrs {
+		if !strings.Contains(text, strings.ToLower(s)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *criteriaCfg) messageDate(m *imap.Message) time.Time {
+	if cr.UseInternalDate {
+		return m.InternalDate
+	}
+	if m.Envelope == nil {
+		return time.Time{}
+	}
+	return m.Envelope.Date
+}
+
+// isDefault tells whether cr is the default criteria that counts all unseen messages
+func (cr *criteriaCfg) isDefault() bool {
+	return reflect.DeepEqual(cr, &criteriaCfg{})
+}
+
+// needsFetch tells whether matched messages have to be fetched for this criteria
+func (cr *criteriaCfg) needsFetch() bool {
+	return cr.Fetch || cr.GroupBy != "" || cr.Duplicates
+}
+
+// duplicateCounter counts messages beyond the first one for each Message-ID
+type duplicateCounter struct {
+	seen       map[string]bool
+	duplicates int
+}
+
+func (d *duplicateCounter) add(msgID string) {
+	if msgID == "" {
+		// no way to tell
+		return
+	}
+	if d.seen == nil {
+		d.seen = map[string]bool{}
+	}
+	if d.seen[msgID] {
+		d.duplicates++
+		return
+	}
+	d.seen[msgID] = true
+}
+
+func newLetter(m *imap.Message, cr *criteriaCfg) *letter {
+	date := cr.messageDate(m)
+	l := &letter{
+		Date: formatLetterDate(date),
+		From: strings.Join(cr.fromSenders(m), ", "),
+		time: date,
+	}
+	if m.Envelope != nil {
+		l.Subject = m.Envelope.Subject
+	}
+	if cr.Preview {
+		l.Preview = preview(m)
+	}
+	cr.pickFields(l)
+	return l
+}
+
+// formatLetterDate formats a date of a letter with -date-format; a missing date is left empty
+func formatLetterDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(*dateFormatArg)
+}
+
+// fetchStats collects stats of -mailbox. Stats of several comma separated mailboxes
+// are nested under mailbox names. The connection and those of -concurrency workers
+// are logged in once and select each mailbox in turn.
+func fetchStats(cfg *config) (_ stats, err error) {
+	names := mailboxNames()
+	cache := cacheFilename()
+	origMbox := *mboxArg
+	defer func() { *mboxArg = origMbox }()
+	for _, name := range names {
+		report.touch(*userArg, name)
 	}
-	c, err := dialAndLogin(passwd)
+	*mboxArg = names[0]
+	c, err := connect()
 	if err != nil {
 		return nil, err
 	}
-	defer c.Logout()
-	st := stats{}
+	defer func() { release(c, err) }()
+	var mbox *imap.MailboxStatus
+	redial := func() (imapClient, error) {
+		c.Logout()
+		newC, err := login()
+		if err != nil {
+			return nil, err
+		}
+		c = newC
+		mbox, err = selectMailbox(idleAware(c), *mboxArg)
+		return idleAware(c), err
+	}
+	// connections of other workers are dialed once and select each mailbox in turn;
+	// they are closed once all stats are collected
+	var (
+		mu    sync.Mutex
+		extra []*client.Client
+		idle  []*client.Client
+	)
+	defer func() {
+		for _, c := range extra {
+			c.Logout()
+		}
+	}()
+	dial := func() (imapClient, error) {
+		mu.Lock()
+		var c *client.Client
+		if n := len(idle); n > 0 {
+			c, idle = idle[n-1], idle[:n-1]
+		}
+		mu.Unlock()
+		if c != nil {
+			if _, err := selectMailbox(idleAware(c), *mboxArg); err == nil {
+				return idleAware(c), nil
+			}
+			c.Logout()
+		}
+		c, err := login()
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		extra = append(extra, c)
+		mu.Unlock()
+		if _, err := selectMailbox(idleAware(c), *mboxArg); err != nil {
+			return nil, err
+		}
+		return idleAware(c), nil
+	}
 
-	// TODO: explore a possibility to run in parallel - will be useful if many stats to be collected
-	for k, cr := range cfg.getStatsCfg(*userArg, *mboxArg) {
-		ids, err := c.Search(cr.toIMAP())
+	res := stats{}
+	for _, name := range names {
+		*mboxArg = name
+		if mbox, err = selectMailbox(idleAware(c), name); err != nil {
+			return nil, err
+		}
+		// stats of the previous run are nested in the cache like they are output
+		var nested []string
+		if fetchingAccounts {
+			nested = append(nested, *userArg)
+		}
+		if len(names) > 1 {
+			nested = append(nested, name)
+		}
+		prev, err := readCachedMailbox(cache, nested...)
 		if err != nil {
 			return nil, err
 		}
-		st[k] = len(ids)
-		if cr.Fetch {
-			messages, err := fetchMails(c, k, ids)
-			if err != nil {
+		prev = prev.checkUIDValidity(name, mbox.UidValidity)
+		knowUIDValidity(*userArg, name, mbox.UidValidity)
+		prevNewest = prev.newest()
+		statsCfg, reused := junkCapped(c, name, cfg.getStatsCfg(*userArg, name)), stats{}
+		if *writeCacheArg {
+			reused, statsCfg = prev.reuse(statsCfg)
+		}
+		st, err := collectStatsConcurrently(idleAware(c), statsCfg, redial, dial, workers())
+		if err != nil {
+			return nil, err
+		}
+		// workers are done, so the next mailbox can reuse their connections
+		idle = append(idle[:0], extra...)
+		mergeStats(st, reused)
+		addUIDValidity(st, mbox, cfg.getStatsCfg(*userArg, name))
+		if err := limitSnippets(st); err != nil {
+			return nil, err
+		}
+		if *includeTotalsArg {
+			addTotals(st, mbox, cfg.getStatsCfg(*userArg, name))
+		}
+		if *metaArg {
+			if err := addMeta(st, mbox, cfg); err != nil {
 				return nil, err
 			}
-			letters := []*letter{}
-			for _, m := range messages {
-				letters = append(letters,
-					&letter{
-						Date:    m.Envelope.Date.Format(time.RFC3339),
-						Subject: m.Envelope.Subject,
-					})
-			}
-			st[k+"_messages"] = letters
 		}
+		if len(names) == 1 {
+			return st, nil
+		}
+		res[name] = st
 	}
-	return st, nil
+	return res, nil
 }
 
-func fetchConfig(path string) (*config, error) {
-	var cfg config
-	b, err := ioutil.ReadFile(path)
+// addMeta adds mailbox metadata that is not a stat by itself under _meta key
+func addMeta(st stats, mbox *imap.MailboxStatus, cfg *config) error {
+	hash, err := cfg.hash()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &cfg, nil
-		}
-		return nil, err
-	}
-	if err := yaml.Unmarshal(b, &cfg); err != nil {
-		return nil, err
+		return err
 	}
-	if err := cfg.validate(); err != nil {
-		return nil, err
+	st[metaKey] = map[string]interface{}{
+		"uidvalidity": mbox.UidValidity,
+		"config_hash": hash,
+		"connections": connCounters(*userArg),
 	}
-	return &cfg, nil
+	return nil
 }
 
-func main() {
-	flag.Parse()
-	if *readCacheArg {
-		must(readFromCache())
-		return
+// hash returns sha256 of the canonical JSON of the loaded config with templates expanded.
+// encoding/json sorts map keys, so the order of keys in the file does not matter.
+func (c *config) hash() (string, error) {
+	canonical := struct {
+		Accounts  map[string]map[string]statsConfig
+		Summaries map[string][]summarySelector
+		Computed  map[string]string
+	}{c.Accounts, c.Summaries, c.Computed}
+	b, err := json.Marshal(canonical)
+	if err != nil {
+		return "", fmt.Errorf("can not hash config: %w", err)
 	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
 
-	cfg, err := fetchConfig(filepath.Join(appHomeDir, configName))
-	dieIf(err)
-	st, err := fetchStats(cfg)
-	dieOnNetError(err)
-	dieIf(err)
+// searcher runs searches bounded by timeouts
+type searcher struct {
+	c imapClient
+	// limit paces commands of the account; nil means no limit
+	limit *tokenBucket
 
-	must(writeStats(st))
+	// pending is closed once an abandoned search completes
+	pending chan struct{}
+	// redial replaces the connection once a timed out search is dropped; without it
+	// the search is abandoned and later commands wait for it
+	redial func() (imapClient, error)
+	// dropped tells that the connection was closed with a timed out search in flight
+	dropped bool
+	// used tells whether the connection already served a stat
+	used bool
 }
 
-func readPassword() (string, error) {
-	b, err := ioutil.ReadFile(*passwordArg)
+// search runs a search bounded by a given timeout; 0 means no timeout
+func (s *searcher) search(sc *imap.SearchCriteria, keys []interface{}, timeout time.Duration) ([]uint32, error) {
+	var ids []uint32
+	err := s.run(timeout, func() (err error) {
+		ids, err = searchIDs(s.c, sc, keys)
+		return err
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	res := strings.TrimSpace(string(b))
-	return res, nil
+	return ids, nil
 }
 
-func readFromCache() error {
-	filename := cacheFilename()
-	info, err := os.Stat(filename)
-	if err != nil {
+// searchNewest searches newest messages first where the server can sort them,
+// bounded by a given timeout; 0 means no timeout
+func (s *searcher) searchNewest(sc *imap.SearchCriteria, keys []interface{}, arrival bool, timeout time.Duration) ([]uint32, bool, error) {
+	var (
+		ids    []uint32
+		sorted bool
+	)
+	err := s.run(timeout, func() (err error) {
+		ids, sorted, err = searchNewest(s.c, sc, keys, arrival)
 		return err
+	})
+	if err != nil {
+		return nil, false, err
 	}
-	age := time.Now().Sub(info.ModTime())
-	if cacheTTL() != ttlInfinite && age > cacheTTL() {
-		// TODO: the error message can be confusing
-		return fmt.Errorf("%w: too old: %s", os.ErrNotExist, filename)
-	}
+	return ids, sorted, nil
+}
 
-	f, err := os.Open(filename)
-	if err != nil {
+// count counts matching messages bounded by a given timeout; 0 means no timeout
+func (s *searcher) count(sc *imap.SearchCriteria, keys []interface{}, timeout time.Duration) (int, error) {
+	var n int
+	err := s.run(timeout, func() (err error) {
+		n, err = countMatches(s.c, sc, keys)
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
-	defer f.Close()
-
-	_, err = io.Copy(os.Stdout, f)
-	return err
+	return n, nil
 }
 
-func writeStats(st stats) error {
-	var w io.Writer = os.Stdout
-	if *writeCacheArg {
-		f, err := os.Create(cacheFilename())
+// extensionKeys returns search keys of server extensions cr needs, adjusting sc accordingly
+func (s *searcher) extensionKeys(cr *criteriaCfg, sc *imap.SearchCriteria) ([]interface{}, error) {
+	var keys []interface{}
+	if cr.hasWithin() {
+		s.wait()
+		within, err := supportsWithin(s.c)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer f.Close()
-		if *quietArg {
-			w = f
-		} else {
-			w = io.MultiWriter(w, f)
+		if within {
+			keys = append(keys, cr.withinKeys(sc)...)
+		}
+	}
+	if cr.GmailRaw != "" {
+		s.wait()
+		gmailKeys, err := cr.gmailRawKeys(s.c)
+		if err != nil {
+			return nil, err
 		}
+		keys = append(keys, gmailKeys...)
 	}
-	return json.NewEncoder(w).Encode(st)
+	return keys, nil
 }
 
-func cacheFilename() string {
-	return filepath.Join(cacheDir, *userArg+"."+*mboxArg)
-}
+// run runs a search command bounded by a given timeout; 0 means no timeout.
+// IMAP has no way to cancel a command, and go-imap can not tell replies of concurrent
+// commands apart, so a timed out command is dropped with its connection if it can be
+// redialed. Otherwise it is abandoned and the next command waits for it.
+func (s *searcher) run(timeout time.Duration, cmd func() error) error {
+	s.wait()
+	s.limit.take()
+	countConn(*userArg, connSearches)
+	if timeout <= 0 {
+		return cmd()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-func dieIf(err error) {
-	if err != nil {
-		log.Fatalf("fatal: %T %s", err, err)
+	res := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res <- cmd()
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		s.pending = done
+		s.drop()
+		return errSearchTimeout
 	}
 }
 
-func must(err error) { dieIf(err) }
+// drop closes the connection of a timed out search if it can be redialed
+func (s *searcher) drop() {
+	t, ok := s.c.(interface{ Terminate() error })
+	if !ok || s.redial == nil {
+		return
+	}
+	t.Terminate()
+	s.dropped = true
+}
 
-func cacheTTL() time.Duration {
-	units := map[string]time.Duration{
-		"s": time.Second,
-		"m": time.Minute,
-		"h": time.Hour,
+// use counts the reuse of the connection if it already served a stat
+func (s *searcher) use() {
+	if s.used {
+		countConn(*userArg, connReused)
 	}
-	val := *ttlArg
-	if val == "" {
-		return ttlInfinite
+	s.used = true
+}
+
+// wait blocks until an abandoned search, if any, completes
+func (s *searcher) wait() {
+	if s.pending != nil {
+		<-s.pending
+		s.pending = nil
+	}
+}
+
+func (s *searcher) selectMailbox(name string) error {
+	if s.dropped {
+		// the redialed connection selects -mailbox
+		return nil
+	}
+	s.wait()
+	s.limit.take()
+	_, err := selectMailbox(s.c, name)
+	return err
+}
+
+// isConnClosed tells whether err is caused by the server closing the connection,
+// e.g. by an untagged BYE on idle timeout or maintenance
+func isConnClosed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, client.ErrNoMailboxSelected) || errors.Is(err, client.ErrNotLoggedIn) {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "imap: connection closed")
+}
+
+// collectStats runs the configured searches against the currently selected mailbox.
+// If the server closes the connection, redial is used once to reconnect and
+// finish remaining criteria. Nil redial disables reconnecting.
+func collectStats(c imapClient, cfg statsConfig, redial func() (imapClient, error)) (stats, error) {
+	st := stats{}
+	if err := collectKeys(context.Background(), c, cfg, queueKeys(cfg), redial, st, &sync.Mutex{}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// queueKeys returns a closed channel with all keys of cfg
+func queueKeys(cfg statsConfig) chan string {
+	keys := make(chan string, len(cfg))
+	for k := range cfg {
+		keys <- k
+	}
+	close(keys)
+	return keys
+}
+
+// collectKeys collects stats of criteria taken from keys over one connection
+// until keys run out or ctx is done. Collected stats are merged into st under mu.
+func collectKeys(ctx context.Context, c imapClient, cfg statsConfig, keys <-chan string,
+	redial func() (imapClient, error), st stats, mu *sync.Mutex) error {
+	reconnect := redial
+	s := &searcher{c: c, limit: accountRateLimit(*userArg), redial: reconnect}
+	defer func() { s.wait() }()
+
+	for k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cr := cfg[k]
+		started := now()
+		collected := stats{}
+		s.use()
+		err := collectStat(s, collected, k, cr)
+		if isConnClosed(err) && redial != nil {
+			warnf("connection closed by server: %s; reconnecting", err)
+			c, err = redial()
+			if err != nil {
+				return err
+			}
+			redial = nil
+			s = &searcher{c: c, limit: accountRateLimit(*userArg), redial: reconnect}
+			s.use()
+			collected = stats{}
+			err = collectStat(s, collected, k, cr)
+		}
+		mailbox := *mboxArg
+		if cr.Mailbox != "" {
+			mailbox = cr.Mailbox
+		}
+		report.timing(*userArg, mailbox, k, now().Sub(started), err)
+		if s.dropped {
+			s.wait()
+			if c, err = reconnect(); err != nil {
+				return err
+			}
+			s = &searcher{c: c, limit: accountRateLimit(*userArg), redial: reconnect}
+		}
+		if err != nil {
+			return err
+		}
+		applyCap(collected, k, cr)
+		recordCriterion(collected, k, cr)
+		mu.Lock()
+		mergeStats(st, collected)
+		mu.Unlock()
+	}
+	return nil
+}
+
+// mergeStats copies stats collected for a criteria into st
+func mergeStats(st stats, collected stats) {
+	for k, v := range collected {
+		switch k {
+		case newestKey:
+			mergeNewest(st, v.(map[string]time.Time))
+		case cacheMetaKey:
+			mergeCacheMeta(st, v.(*cacheMeta))
+		default:
+			st[k] = v
+		}
+	}
+}
+
+// statusUnseen gets unseen count of a mailbox with STATUS which is much cheaper
+// than SEARCH on large mailboxes. ok is false if the server omitted UNSEEN.
+func statusUnseen(c imapClient, mailbox string) (n int, ok bool, err error) {
+	var mbox *imap.MailboxStatus
+	err = withMailboxRetry(mailbox, func() (err error) {
+		mbox, err = c.Status(mailbox, []imap.StatusItem{imap.StatusUnseen})
+		return
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if _, ok := mbox.Items[imap.StatusUnseen]; !ok {
+		return 0, false, nil
+	}
+	return int(mbox.Unseen), true, nil
+}
+
+// handleTimeout reports a timed out search in best-effort mode.
+// It returns true if the error is handled.
+func handleTimeout(st stats, k string, cr *criteriaCfg, err error) bool {
+	if err != errSearchTimeout || !*bestEffortArg {
+		return false
+	}
+	warnf("%s: search timed out after %s", k, cr.Timeout)
+	st[k+"_error"] = err.Error()
+	return true
+}
+
+func collectSplitBySeen(s *searcher, st stats, k string, cr *criteriaCfg) error {
+	seen, unseen := cr.splitBySeen()
+	seenKeys, err := s.extensionKeys(cr, seen)
+	if err != nil {
+		return err
+	}
+	unseenKeys, err := s.extensionKeys(cr, unseen)
+	if err != nil {
+		return err
+	}
+	seenCount, err := s.count(seen, seenKeys, cr.Timeout)
+	if handleTimeout(st, k, cr, err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	unseenCount, err := s.count(unseen, unseenKeys, cr.Timeout)
+	if handleTimeout(st, k, cr, err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st[k] = seenCount + unseenCount
+	st[k+"_seen"] = seenCount
+	st[k+"_unseen"] = unseenCount
+	return nil
+}
+
+func collectStat(s *searcher, st stats, k string, cr *criteriaCfg) (err error) {
+	if cr.Mailbox != "" && cr.Mailbox != *mboxArg {
+		report.touch(*userArg, cr.Mailbox)
+		if err := s.selectMailbox(cr.Mailbox); err != nil {
+			return err
+		}
+		defer func() {
+			if selErr := s.selectMailbox(*mboxArg); err == nil {
+				err = selErr
+			}
+		}()
+	}
+	if cr.Recent {
+		if done, err := collectRecent(s, st, k, cr); done || err != nil {
+			return err
+		}
+	}
+	if cr.SplitBySeen {
+		return collectSplitBySeen(s, st, k, cr)
+	}
+	if cr.isDefault() {
+		s.wait()
+		s.limit.take()
+		n, ok, err := statusUnseen(s.c, *mboxArg)
+		switch {
+		case err != nil:
+			warnf("%s: STATUS failed: %s; falling back to search", *mboxArg, err)
+		case ok:
+			st[k] = n
+			return nil
+		default:
+			debugf("%s: server did not report UNSEEN in STATUS; falling back to search", *mboxArg)
+		}
+	}
+	sc := cr.toIMAP()
+	keys, err := s.extensionKeys(cr, sc)
+	if err != nil {
+		return err
+	}
+	if !cr.needsFetch() {
+		n, err := s.count(sc, keys, cr.Timeout)
+		if handleTimeout(st, k, cr, err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		st[k] = n
+		return nil
+	}
+	ids, sorted, err := s.searchNewest(sc, keys, cr.UseInternalDate, cr.Timeout)
+	if handleTimeout(st, k, cr, err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	letters := []*letter{}
+	var byWeekday weekdayCounts
+	if cr.GroupBy == groupByWeekday {
+		byWeekday = newWeekdayCounts()
+	}
+	var byFrom map[string]int
+	if cr.GroupBy == groupByFrom {
+		byFrom = map[string]int{}
+	}
+	dups := &duplicateCounter{}
+	var newest time.Time
+	// fetched letters over -max-letters are counted but not kept
+	dropped := 0
+	mailbox := *mboxArg
+	if cr.Mailbox != "" {
+		mailbox = cr.Mailbox
+	}
+	count := len(ids)
+	// the recheck can only count all candidates if none is left out by the fetch limit
+	recount := cr.foldsCase()
+	if limit := cr.fetchLimit(); recount && limit > 0 && len(ids) > limit {
+		warnf("%s: %d candidates exceed fetch limit %d; counting server matches without case folding", k, len(ids), limit)
+		recount = false
+	}
+	if recount {
+		count = 0
+	}
+	s.limit.take()
+	err = fetchMails(s.c, k, ids, sorted, cr, func(m *imap.Message) error {
+		if cr.foldsCase() {
+			ok, err := cr.bodyMatches(m)
+			if err != nil || !ok {
+				return err
+			}
+			if recount {
+				count++
+			}
+		}
+		if cr.Fetch && cr.isNew(k, m) {
+			switch {
+			case letterOut != nil:
+				if err := letterOut.write(*userArg, mailbox, k, newLetter(m, cr)); err != nil {
+					return err
+				}
+			case *maxLettersArg <= 0 || len(letters) < *maxLettersArg:
+				letters = append(letters, newLetter(m, cr))
+			default:
+				dropped++
+			}
+		}
+		if d := cr.messageDate(m); d.After(newest) {
+			newest = d
+		}
+		if byWeekday != nil {
+			byWeekday.add(cr.messageDate(m))
+		}
+		if byFrom != nil {
+			for _, addr := range cr.fromAddresses(m) {
+				byFrom[addr]++
+			}
+		}
+		dups.add(m.Envelope.MessageId)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		warnf("%s: keeping %d of %d fetched mails as -max-letters is %d", k, len(letters), len(letters)+dropped, *maxLettersArg)
+	}
+	if byWeekday != nil {
+		st[k+"_by_weekday"] = map[string]int(byWeekday)
+	}
+	if byFrom != nil {
+		st[k+"_by_from"] = byFrom
+	}
+	if cr.Duplicates {
+		st[k+"_duplicates"] = dups.duplicates
+	}
+	if cr.NewOnly {
+		recordNewest(st, k, newest)
+	}
+	if !cr.Fetch {
+		st[k] = len(ids)
+		return nil
+	}
+	if *nestedOutputArg {
+		st[k] = &fetchedStat{Count: count, Messages: letters}
+		return nil
+	}
+	st[k] = count
+	st[k+messagesSuffix] = letters
+	return nil
+}
+
+func loadConfig(path string) (*config, error) {
+	var cfg config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if err := expandEnv(&doc); err != nil {
+		return nil, err
+	}
+	logins, err := takeLogins(&doc)
+	if err != nil {
+		return nil, err
+	}
+	// logins are taken out first, so that their options are not taken for criteria
+	if err := applyFetchDefaults(&doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		// empty file
+		return &cfg, nil
+	}
+	if err := doc.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	cfg.Logins = logins
+	return &cfg, nil
+}
+
+func fetchConfig(path string) (*config, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.expandTemplates(); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadUIDFiles(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func main() {
+	flag.Parse()
+	connLimit = newConnLimiter(*maxConnectionsArg)
+	dieIf(initLocation(*tzArg))
+	dieIf(validateProfile(*profileArg))
+	dieIf(validateFormat(*formatArg))
+	dieIf(validateTerminator())
+	dieIf(validateOutputPolicy(*maxOutputPolicyArg))
+	if *templateArg != "" {
+		tmpl, err := loadOutputTemplate(*templateArg)
+		dieIf(err)
+		outputTemplate = tmpl
+	}
+	if *readCacheArg {
+		err := readFromCache()
+		if *refreshArg && needsRefresh(err) {
+			// a missing or outdated cache gets refreshed too
+			if err := startRefresh(); err != nil {
+				warnf("can not refresh cache: %s", err)
+			}
+		}
+		must(err)
+		return
+	}
+	if *snippetMaxArg < 0 {
+		dieIf(errors.New("-snippet-max must not be negative"))
+	}
+	if *concurrencyArg < 1 {
+		dieIf(errors.New("-concurrency must be at least 1"))
+	}
+	if timeout, err := parseDurationArg(*timeoutArg); err != nil || timeout <= 0 {
+		dieIf(fmt.Errorf("bad -timeout %q: want a positive number of seconds, minutes or hours like 30s, 5m or 1h", *timeoutArg))
+	}
+	if *retriesArg < 0 {
+		dieIf(errors.New("-retries must not be negative"))
+	}
+	if *fallbackTTLArg != "" {
+		if ttl, err := parseDurationArg(*fallbackTTLArg); err != nil || ttl <= 0 {
+			dieIf(fmt.Errorf("bad -fallback-ttl %q: want a positive number of seconds, minutes or hours like 30s, 5m or 1h", *fallbackTTLArg))
+		}
+	}
+	dieIf(validateServe())
+	if *idleArg && !*daemonArg {
+		dieIf(errors.New("-idle needs -daemon"))
+	}
+	if *daemonArg {
+		if interval, err := parseDurationArg(*intervalArg); err != nil || interval <= 0 {
+			dieIf(fmt.Errorf("bad -interval %q: want a positive number of seconds, minutes or hours like 30s, 5m or 1h", *intervalArg))
+		}
+	}
+	if *idleTimeoutArg < 0 {
+		dieIf(errors.New("-idle-timeout must not be negative"))
+	}
+	if *insecureSkipVerifyArg {
+		warnf("-insecure-skip-verify is set: the certificate of the server is not verified")
+	}
+	if *inArg != "" {
+		if *inArg != "-" {
+			dieIf(errors.New("-in only supports - for stdin"))
+		}
+		must(renderCached(os.Stdin, os.Stdout))
+		return
+	}
+	if *defaultStatKeyArg == "" {
+		dieIf(errors.New("-default-stat-key must not be empty"))
+	}
+	r, err := parseRate(*rateArg)
+	dieIf(err)
+	commandRate = r
+	if *lintArg {
+		must(lintConfig(filepath.Join(appHomeDir, configName)))
+		return
+	}
+	if *validateArg {
+		must(validateConfig(filepath.Join(appHomeDir, configName), os.Stdout))
+		return
+	}
+	if *listMailboxesArg {
+		must(listMailboxes())
+		return
+	}
+	if *rawCommandArg != "" {
+		must(sendRawCommand(os.Stdout))
+		return
+	}
+	mbox, err := resolveMailboxes(*mboxArg)
+	dieIf(err)
+	*mboxArg = mbox
+
+	var filter *statsFilter
+	if *filterArg != "" {
+		filter, err = parseFilter(*filterArg)
+		dieIf(err)
+	}
+
+	cfg, err := fetchConfig(filepath.Join(appHomeDir, configName))
+	dieIf(err)
+	logins = cfg.Logins
+	if *lettersToArg != "" {
+		letterOut, err = openLetterStream(*lettersToArg)
+		dieIf(err)
+		defer letterOut.Close()
+	}
+	checkConfig = cfg
+	if *explainArg != "" {
+		must(explain(os.Stdout, cfg, *explainArg))
+		return
+	}
+	if *warmArg {
+		err := warmCaches(cfg, filter, fetchStats)
+		must(saveReport(err))
+		must(err)
+		must(report.strictErr())
+		return
+	}
+	if *daemonArg {
+		must(runDaemon(cfg, filter))
+		return
+	}
+	if *serveArg != "" {
+		must(runServer(cfg, filter, *serveArg))
+		return
+	}
+	if allAccounts() {
+		st, err := fetchAccounts(cfg, filter, fetchStats)
+		must(saveReport(err))
+		if fallBackToCache(os.Stdout, err) {
+			return
+		}
+		dieOnLoginError(err)
+		dieOnNetError(err)
+		dieIf(err)
+		must(writeOutputs(st))
+		exitOnBreaches(cfg, st)
+		return
+	}
+	st, err := fetchStats(cfg)
+	must(saveReport(err))
+	if fallBackToCache(os.Stdout, err) {
+		return
+	}
+	dieOnLoginError(err)
+	dieOnNetError(err)
+	dieIf(err)
+	st, err = postprocessFetched(cfg, st, filter)
+	must(err)
+	must(writeOutputs(st))
+	exitOnBreaches(cfg, st)
+}
+
+// writeOutputs writes stats to stdout and the cache and passes them to -exec and -graphite
+func writeOutputs(st stats) error {
+	if err := writeStats(st); err != nil {
+		return err
+	}
+	if *execArg != "" {
+		if err := execStats(*execArg, withoutCacheOnly(st)); err != nil {
+			return err
+		}
+	}
+	if *graphiteArg != "" {
+		if err := sendGraphite(*graphiteArg, withoutCacheOnly(st)); err != nil {
+			return err
+		}
+	}
+	return report.strictErr()
+}
+
+// postprocessStats adds derived stats, records history and filters stats before they are written
+func postprocessStats(cfg *config, st stats, filter *statsFilter) (stats, error) {
+	if multiMailbox() {
+		return postprocessMailboxes(cfg, st, filter)
+	}
+	cfg.compute(st)
+	if *sqliteArg != "" {
+		if err := writeStatsDB(*sqliteArg, st); err != nil {
+			return nil, err
+		}
+	}
+	if filter != nil {
+		st = filter.apply(st)
+	}
+	addOrder(st, cfg.getStatsCfg(*userArg, *mboxArg))
+	if *hashArg {
+		st[hashKey] = statsHash(st)
+	}
+	return st, nil
+}
+
+// postprocessFetched postprocesses stats fetchStats collected for -user
+// and sums them up across mailboxes into summaries
+func postprocessFetched(cfg *config, st stats, filter *statsFilter) (stats, error) {
+	collected := results{}
+	collected.add(*userArg, st)
+	st, err := postprocessStats(cfg, st, filter)
+	if err != nil {
+		return nil, err
+	}
+	cfg.addSummaries(st, collected)
+	return st, nil
+}
+
+// postprocessMailboxes postprocesses stats of each mailbox nested in st
+func postprocessMailboxes(cfg *config, st stats, filter *statsFilter) (stats, error) {
+	origMbox := *mboxArg
+	defer func() { *mboxArg = origMbox }()
+	for _, name := range mailboxNames() {
+		nested, ok := st[name].(stats)
+		if !ok {
+			continue
+		}
+		*mboxArg = name
+		nested, err := postprocessStats(cfg, nested, filter)
+		if err != nil {
+			return nil, err
+		}
+		st[name] = nested
+	}
+	return st, nil
+}
+
+// readPassword returns the password of an account. The first given source is used:
+// pass_command, pass_env and pass of the account, -pass-command, -pass-env, -secrets and -pass file.
+// Password commands run once per account.
+func readPassword(account string) (string, error) {
+	l := accountLogin(account)
+	if l.PassCommand != "" {
+		return readAccountCommand(account, "pass", l.PassCommand)
+	}
+	if l.PassEnv != "" {
+		return readPasswordEnv(l.PassEnv)
+	}
+	if l.Pass != "" {
+		return readPasswordFile(l.Pass)
+	}
+	if *passCommandArg != "" {
+		return readAccountCommand(account, "pass", *passCommandArg)
+	}
+	if *passEnvArg != "" {
+		return readPasswordEnv(*passEnvArg)
+	}
+	if *secretsArg != "" {
+		secrets, err := readSecrets(*secretsArg)
+		if err != nil {
+			return "", err
+		}
+		passwd, found := secrets[account]
+		if !found {
+			return "", fmt.Errorf("no password for %s in %s", account, *secretsArg)
+		}
+		return passwd, nil
+	}
+	return readPasswordFile(*passwordArg)
+}
+
+// commandSecrets memoizes secrets printed by commands per account and command.
+// Workers, redials and daemon cycles log in again; without it each login would
+// run a password manager again, possibly prompting the user.
+var commandSecrets = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// readAccountCommand is readSecretCommand run once per account for the process lifetime.
+// Failures are not memoized.
+func readAccountCommand(account string, what string, command string) (string, error) {
+	commandSecrets.Lock()
+	defer commandSecrets.Unlock()
+	// the lock is held while the command runs, so that concurrent logins do not run it twice
+	key := account + "\x00" + command
+	if secret, found := commandSecrets.m[key]; found {
+		return secret, nil
+	}
+	secret, err := readSecretCommand(what, command)
+	if err != nil {
+		return "", err
+	}
+	commandSecrets.m[key] = secret
+	return secret, nil
+}
+
+// forgetAccountCommand drops a memoized secret, e.g. an expired token
+func forgetAccountCommand(account string, command string) {
+	commandSecrets.Lock()
+	defer commandSecrets.Unlock()
+	delete(commandSecrets.m, account+"\x00"+command)
+}
+
+// readSecretCommand runs a shell command and returns its trimmed output.
+// what names the secret in errors.
+func readSecretCommand(what string, command string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = &out
+	// stdout is reserved for stats
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("%s command %q: exit code %d", what, command, exitErr.ExitCode())
+	}
+	if err != nil {
+		return "", err
+	}
+	res := strings.TrimSpace(out.String())
+	if res == "" {
+		return "", fmt.Errorf("%s command %q: empty output", what, command)
+	}
+	return res, nil
+}
+
+func readPasswordEnv(name string) (string, error) {
+	res := strings.TrimSpace(os.Getenv(name))
+	if res == "" {
+		return "", fmt.Errorf("no password in environment variable %s", name)
+	}
+	return res, nil
+}
+
+func readPasswordFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	res := strings.TrimSpace(string(b))
+	return res, nil
+}
+
+// readFromCache writes the cache to stdout in -format
+func readFromCache() error {
+	if *formatArg == formatJSON && *templateArg == "" && lineTerminator() == "\n" {
+		// cached stats are JSON already
+		return copyCache(os.Stdout, cacheTTL(), true)
+	}
+	var cached bytes.Buffer
+	if err := copyCache(&cached, cacheTTL(), true); err != nil {
+		return err
+	}
+	return renderCached(&cached, os.Stdout)
+}
+
+// copyCache writes cached stats to w as JSON unless the cache is older than ttl or
+// UIDVALIDITY of a cached mailbox differs from a live one fetched by this process.
+// checkCriteria makes criteria with their own ttl checked too.
+func copyCache(w io.Writer, ttl time.Duration, checkCriteria bool) error {
+	filename := cacheFilename()
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	age := time.Now().Sub(info.ModTime())
+	if ttl != ttlInfinite && age > ttl {
+		// TODO: the error message can be confusing
+		return fmt.Errorf("%w: too old: %s", os.ErrNotExist, filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var st stats
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("bad cached stats: %w", err)
+	}
+	if changed := changedUIDValidity(st); len(changed) > 0 {
+		return fmt.Errorf("%w: UIDVALIDITY changed in %s: %s", os.ErrNotExist, filename, strings.Join(changed, ", "))
+	}
+	if checkCriteria {
+		stale, err := staleCriteria(st)
+		if err != nil {
+			return fmt.Errorf("bad cached stats: %w", err)
+		}
+		if len(stale) > 0 {
+			return fmt.Errorf("%w: stale criteria in %s: %s", os.ErrNotExist, filename, strings.Join(stale, ", "))
+		}
+	}
+	return json.NewEncoder(w).Encode(withoutCacheOnly(st))
+}
+
+func writeStats(st stats) error {
+	st, err := limitOutput(st)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	// keys the next run needs are kept in the cache only
+	out := withoutCacheOnly(st)
+	if *writeCacheArg {
+		if err := os.MkdirAll(profileCacheDir(), defaultDirPerms); err != nil {
+			return err
+		}
+		unlock, err := lockCache(cacheFilename())
+		if errors.Is(err, errCacheLocked) {
+			// the other run writes fresh stats anyway
+			warnf("not writing cache %s: %s", cacheFilename(), err)
+			if *quietArg {
+				return nil
+			}
+			return encodeStatsTerminated(w, out)
+		}
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		// the cache is always JSON, so that -read-cache, -in - and -serve can read it back;
+		// -format and -template apply to stdout only. It is replaced once stats are
+		// written there, so that readers never see it half-written.
+		var cached bytes.Buffer
+		if err := json.NewEncoder(&cached).Encode(st); err != nil {
+			return err
+		}
+		if !*quietArg {
+			if err := encodeStatsTerminated(w, out); err != nil {
+				return err
+			}
+		}
+		return writeFileAtomic(cacheFilename(), cached.Bytes(), cachePerms)
+	}
+	return encodeStatsTerminated(w, out)
+}
+
+// profileCacheDir returns the cache directory of the current -profile
+func profileCacheDir() string {
+	if *profileArg == "" {
+		return cacheDir
+	}
+	return filepath.Join(cacheDir, *profileArg)
+}
+
+func validateProfile(name string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("bad profile name: %q", name)
+	}
+	return nil
+}
+
+// cacheFilename returns the cache file of -user and -mailbox. Runs over the same
+// mailboxes share a cache whatever order they are listed in.
+func cacheFilename() string {
+	account := *userArg
+	if allAccounts() {
+		account = allAccountsName
+	}
+	names := mailboxNames()
+	sort.Strings(names)
+	return filepath.Join(profileCacheDir(), account+"."+strings.Join(names, ","))
+}
+
+func debugf(format string, v ...interface{}) {
+	if *verboseArg {
+		log.Printf("DEBUG "+format, v...)
+	}
+}
+
+func dieIf(err error) {
+	if err != nil {
+		dieUnknown(err)
+		log.Fatalf("fatal: %T %s", err, err)
+	}
+}
+
+func must(err error) { dieIf(err) }
+
+func cacheTTL() time.Duration {
+	if *ttlArg == "" {
+		return ttlInfinite
+	}
+	ttl, err := parseDurationArg(*ttlArg)
+	if err != nil {
+		return ttlInfinite
+	}
+	return ttl
+}
+
+// parseDurationArg parses durations of flags like 30s, 5m or 1h; a number without unit is seconds
+func parseDurationArg(val string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
 	}
 	unit := time.Second
 	for k, v := range units {
@@ -396,9 +1949,18 @@ func cacheTTL() time.Duration {
 			break
 		}
 	}
-	ttl, err := strconv.ParseInt(val, 10, 64)
+	n, err := strconv.ParseInt(val, 10, 64)
 	if err != nil {
-		return ttlInfinite
+		return 0, err
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// imapTimeout returns -timeout bounding connecting to servers and commands
+func imapTimeout() time.Duration {
+	timeout, err := parseDurationArg(*timeoutArg)
+	if err != nil || timeout <= 0 {
+		return defaultIMAPTimeout
 	}
-	return time.Duration(ttl) * unit
+	return timeout
 }
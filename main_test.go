@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,9 +30,22 @@ func Test_configDefaultBehaviours(t *testing.T) {
 	assert.Equal(t, statsConfig{"unseen_count": &criteriaCfg{}}, statCfg)
 }
 
+func Test_getStatsCfgShouldInjectDefaultUnderCustomKey(t *testing.T) {
+	*defaultStatKeyArg = "unread"
+	defer func() { *defaultStatKeyArg = "unseen_count" }()
+
+	cfg, err := fetchConfig("testdata/config.yaml")
+	require.NoError(t, err)
+
+	assert.Equal(t, statsConfig{"unread": &criteriaCfg{}}, cfg.getStatsCfg("foo", "bar"))
+	statCfg := cfg.getStatsCfg("foo@bar.com", "INBOX")
+	assert.Equal(t, &criteriaCfg{}, statCfg["unread"])
+	assert.NotContains(t, statCfg, "unseen_count")
+}
+
 func Test_fetchConfigShouldFailOnInvalidOrClause(t *testing.T) {
 	cfg, err := fetchConfig("testdata/config.invalid-or.yaml")
-	require.EqualError(t, err, "bad config: OR criteria must have 2 clauses")
+	require.EqualError(t, err, "bad config: accounts.foo@bar.com.INBOX.important_count: OR criteria must have 2 clauses")
 	assert.Nil(t, cfg)
 }
 
@@ -104,6 +129,102 @@ func Test_criteriaCfgToIMAP(t *testing.T) {
 	expected = imap.NewSearchCriteria()
 	expected.WithoutFlags = []string{imap.SeenFlag}
 	assert.Equal(t, expected, actual.toIMAP())
+
+	// test seen
+	actual = &criteriaCfg{Seen: true}
+	expected = imap.NewSearchCriteria()
+	expected.WithFlags = []string{imap.SeenFlag}
+	assert.Equal(t, expected, actual.toIMAP())
+	assert.NotEmpty(t, actual.toIMAP().WithFlags)
+	assert.Empty(t, actual.toIMAP().WithoutFlags)
+}
+
+func Test_criteriaCfgToIMAPShouldPassFlagsThrough(t *testing.T) {
+	var tests = []struct {
+		name                 string
+		given                *criteriaCfg
+		expectedWithFlags    []string
+		expectedWithoutFlags []string
+	}{
+		{"with flags",
+			&criteriaCfg{WithFlags: []string{imap.FlaggedFlag}},
+			[]string{imap.FlaggedFlag}, nil},
+		{"without flags",
+			&criteriaCfg{WithoutFlags: []string{imap.AnsweredFlag, "$Junk"}},
+			nil, []string{imap.AnsweredFlag, "$Junk"}},
+		{"seen adds to with flags",
+			&criteriaCfg{Seen: true, WithFlags: []string{imap.FlaggedFlag}, WithoutFlags: []string{imap.DraftFlag}},
+			[]string{imap.SeenFlag, imap.FlaggedFlag}, []string{imap.DraftFlag}},
+		{"default is unseen",
+			&criteriaCfg{},
+			nil, []string{imap.SeenFlag}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			actual := tt.given.toIMAP()
+			assert.Equal(t, tt.expectedWithFlags, actual.WithFlags)
+			assert.Equal(t, tt.expectedWithoutFlags, actual.WithoutFlags)
+		})
+	}
+}
+
+func Test_criteriaCfgToIMAPShouldOmitSeenFilterForAnySeen(t *testing.T) {
+	given := &criteriaCfg{
+		AnySeen: true,
+		Or: []criteriaCfg{
+			{AnySeen: true, Headers: map[string]string{"Subject": "foo"}},
+			{Headers: map[string]string{"Subject": "bar"}},
+		},
+	}
+
+	first := imap.NewSearchCriteria()
+	first.Header.Add("Subject", "foo")
+
+	second := imap.NewSearchCriteria()
+	second.Header.Add("Subject", "bar")
+	second.WithoutFlags = []string{imap.SeenFlag}
+
+	expected := imap.NewSearchCriteria()
+	expected.Or = [][2]*imap.SearchCriteria{
+		{first, second},
+	}
+	actual := given.toIMAP()
+	assert.Equal(t, expected, actual)
+	assert.Empty(t, actual.WithoutFlags)
+	assert.Empty(t, actual.WithFlags)
+}
+
+func Test_criteriaCfgToIMAPShouldConstrainUIDs(t *testing.T) {
+	given := &criteriaCfg{UIDRange: "1000:2000,3000"}
+
+	expected := imap.NewSearchCriteria()
+	expected.WithoutFlags = []string{imap.SeenFlag}
+	expected.Uid = &imap.SeqSet{}
+	expected.Uid.AddRange(1000, 2000)
+	expected.Uid.AddNum(3000)
+
+	actual := given.toIMAP()
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "1000:2000,3000", actual.Uid.String())
+}
+
+func Test_criteriaCfgToIMAPShouldNegateNotClause(t *testing.T) {
+	given := &criteriaCfg{
+		Not: &criteriaCfg{Headers: map[string]string{"From": "noreply@corp.com"}},
+	}
+
+	not := imap.NewSearchCriteria()
+	not.Header.Add("From", "noreply@corp.com")
+
+	expected := imap.NewSearchCriteria()
+	expected.WithoutFlags = []string{imap.SeenFlag}
+	expected.Not = []*imap.SearchCriteria{not}
+	assert.Equal(t, expected, given.toIMAP())
+
+	given.Not.Seen = true
+	not.WithFlags = []string{imap.SeenFlag}
+	assert.Equal(t, expected, given.toIMAP())
 }
 
 func Test_criteriaCfgToIMAPShouldPanicOnASingleCriterion(t *testing.T) {
@@ -173,6 +294,37 @@ func Test_criteriaCfgToIMAPShouldHanldleORClauseWithMoreThanTwoCriteria(t *testi
 	assert.Equal(t, expected, given.toIMAP())
 }
 
+func Test_criteriaCfgToIMAPShouldBalanceORClauseWithFourCriteria(t *testing.T) {
+	subjects := []string{"foo", "bar", "fuzz", "buzz"}
+	given := &criteriaCfg{}
+	leaves := []*imap.SearchCriteria{}
+	for _, subj := range subjects {
+		given.Or = append(given.Or, criteriaCfg{Headers: map[string]string{"Subject": subj}})
+
+		leaf := imap.NewSearchCriteria()
+		leaf.Header.Add("Subject", subj)
+		leaf.WithoutFlags = []string{imap.SeenFlag}
+		leaves = append(leaves, leaf)
+	}
+
+	first := imap.NewSearchCriteria()
+	first.Or = [][2]*imap.SearchCriteria{
+		{leaves[0], leaves[1]},
+	}
+
+	second := imap.NewSearchCriteria()
+	second.Or = [][2]*imap.SearchCriteria{
+		{leaves[2], leaves[3]},
+	}
+
+	expected := imap.NewSearchCriteria()
+	expected.WithoutFlags = []string{imap.SeenFlag}
+	expected.Or = [][2]*imap.SearchCriteria{
+		{first, second},
+	}
+	assert.Equal(t, expected, given.toIMAP())
+}
+
 func Test_cacheTTL(t *testing.T) {
 	assert.Equal(t, ttlInfinite, cacheTTL())
 
@@ -193,3 +345,805 @@ func Test_cacheTTL(t *testing.T) {
 		})
 	}
 }
+
+func Test_imapTimeout(t *testing.T) {
+	defer func(timeout string) { *timeoutArg = timeout }(*timeoutArg)
+	assert.Equal(t, defaultIMAPTimeout, imapTimeout())
+
+	var tests = []struct {
+		expected time.Duration
+		given    string
+	}{
+		{5 * time.Second, "5"},
+		{3 * time.Second, "3s"},
+		{2 * time.Minute, "2m"},
+		{defaultIMAPTimeout, "0"},
+		{defaultIMAPTimeout, "soon"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			*timeoutArg = tt.given
+			assert.Equal(t, tt.expected, imapTimeout())
+		})
+	}
+}
+
+// testBackend is the memory backend that reports UNSEEN in STATUS responses
+type testBackend struct {
+	*memory.Backend
+}
+
+func (b testBackend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(conn, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return testUser{user}, nil
+}
+
+type testUser struct {
+	backend.User
+}
+
+func (u testUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return testMailbox{mbox}, nil
+}
+
+type testMailbox struct {
+	backend.Mailbox
+}
+
+func (m testMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	status, err := m.Mailbox.Status(items)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := status.Items[imap.StatusUnseen]; ok {
+		unseen := imap.NewSearchCriteria()
+		unseen.WithoutFlags = []string{imap.SeenFlag}
+		ids, err := m.Mailbox.SearchMessages(false, unseen)
+		if err != nil {
+			return nil, err
+		}
+		status.Unseen = uint32(len(ids))
+	}
+	return status, nil
+}
+
+// newTestClient starts an in-memory IMAP server and returns a client logged in
+// with INBOX selected. Given subjects are appended as extra unseen messages
+// sent on 1st, 2nd... of February 2021 and received a month later.
+func newTestClient(t *testing.T, subjects ...string) *client.Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := server.New(testBackend{memory.New()})
+	s.AllowInsecureAuth = true
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := client.Dial(l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Logout() })
+	require.NoError(t, c.Login("username", "password"))
+
+	for i, subj := range subjects {
+		msg := fmt.Sprintf("From: foo@bar.com\r\nSubject: %s\r\n"+
+			"Date: Mon, 0%d Feb 2021 10:00:00 +0000\r\n\r\nhello", subj, i+1)
+		received := time.Date(2021, 3, i+1, 10, 0, 0, 0, time.UTC)
+		require.NoError(t, c.Append("INBOX", nil, received, bytes.NewBufferString(msg)))
+	}
+	_, err = c.Select("INBOX", false)
+	require.NoError(t, err)
+	return c
+}
+
+func Test_fetchMailsShouldEmitMessagesIncrementally(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+
+	var actual []string
+	err := fetchMails(c, "test", []uint32{1, 2, 3}, false, &criteriaCfg{}, func(m *imap.Message) error {
+		actual = append(actual, m.Envelope.Subject)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A little message, just for you", "foo", "bar"}, actual)
+}
+
+func Test_fetchMailsShouldFetchNewestMessagesOverLimit(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+	msg := "From: foo@bar.com\r\nSubject: old\r\nDate: Mon, 01 Feb 2019 10:00:00 +0000\r\n\r\nhello"
+	require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)))
+	_, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+
+	var actual []string
+	err = fetchMails(c, "test", []uint32{1, 2, 3, 4}, false, &criteriaCfg{FetchLimit: intRef(2)}, func(m *imap.Message) error {
+		actual = append(actual, m.Envelope.Subject)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, actual)
+}
+
+func Test_fetchMailsShouldFetchNewestReceivedMessagesOverLimitWithInternalDate(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+	msg := "From: foo@bar.com\r\nSubject: old\r\nDate: Mon, 01 Feb 2019 10:00:00 +0000\r\n\r\nhello"
+	require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)))
+	_, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+
+	var actual []string
+	cr := &criteriaCfg{FetchLimit: intRef(1), UseInternalDate: true}
+	err = fetchMails(c, "test", []uint32{1, 2, 3, 4}, false, cr, func(m *imap.Message) error {
+		actual = append(actual, m.Envelope.Subject)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old"}, actual)
+}
+
+func Test_fetchMailsShouldStopEmittingOnCallbackError(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+
+	calls := 0
+	err := fetchMails(c, "test", []uint32{1, 2, 3}, false, &criteriaCfg{}, func(m *imap.Message) error {
+		calls++
+		return fmt.Errorf("boom")
+	})
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 1, calls)
+}
+
+func Test_collectStatsShouldLimitFetchedMessages(t *testing.T) {
+	defer func(orig int) { *fetchLimitArg = orig }(*fetchLimitArg)
+	*fetchLimitArg = 1
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{
+		"flag_count": &criteriaCfg{AnySeen: true, Fetch: true, Fields: []string{"subject"}},
+		"own_count":  &criteriaCfg{AnySeen: true, Fetch: true, Fields: []string{"subject"}, FetchLimit: intRef(2)},
+		"all_count":  &criteriaCfg{AnySeen: true, Fetch: true, Fields: []string{"subject"}, FetchLimit: intRef(0)},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, st["flag_count"])
+	assert.Len(t, st["flag_count_messages"], 1)
+	assert.Len(t, st["own_count_messages"], 2)
+	assert.Len(t, st["all_count_messages"], 3)
+}
+
+func Test_collectStatsShouldKeepAtMostMaxLetters(t *testing.T) {
+	defer func(orig int) { *maxLettersArg = orig }(*maxLettersArg)
+	*maxLettersArg = 2
+	withReport(t, "")
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{
+		"all_count": &criteriaCfg{AnySeen: true, Fetch: true, FetchLimit: intRef(0)},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, st["all_count"])
+	assert.Len(t, st["all_count_messages"], 2)
+	assert.Len(t, report.Warnings, 1)
+}
+
+func Test_collectStatsShouldNestFetchedMessages(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+	*nestedOutputArg = true
+	defer func() { *nestedOutputArg = false }()
+
+	st, err := collectStats(c, statsConfig{
+		"unseen_count": &criteriaCfg{},
+		"foo_count": &criteriaCfg{
+			Headers: map[string]string{"Subject": "foo"},
+			Fetch:   true,
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	actual, err := json.Marshal(st)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"unseen_count": 2,
+		"foo_count": {
+			"count": 1,
+			"messages": [{"date": "2021-02-01T10:00:00Z", "from": "foo@bar.com", "subject": "foo"}]
+		}
+	}`, string(actual))
+}
+
+func Test_collectStatsShouldKeepFlatOutputByDefault(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{
+		"foo_count": &criteriaCfg{
+			Headers: map[string]string{"Subject": "foo"},
+			Fetch:   true,
+		},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, st["foo_count"])
+	letters := st["foo_count_messages"].([]*letter)
+	require.Len(t, letters, 1)
+	assert.True(t, letters[0].time.Equal(time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC)))
+	letters[0].time = time.Time{}
+	assert.Equal(t, []*letter{{Date: "2021-02-01T10:00:00Z", From: "foo@bar.com", Subject: "foo"}}, letters)
+}
+
+type fakeClient struct {
+	search func(*imap.SearchCriteria) ([]uint32, error)
+	status func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error)
+
+	selected []string
+	// selectErrs are returned by subsequent selects before they start to succeed
+	selectErrs []error
+}
+
+func (c *fakeClient) Search(criteria *imap.SearchCriteria) ([]uint32, error) {
+	return c.search(criteria)
+}
+
+func (c *fakeClient) Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
+	close(ch)
+	return nil
+}
+
+func (c *fakeClient) Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	if c.status == nil {
+		// behave like a server that omits UNSEEN
+		return imap.NewMailboxStatus(name, nil), nil
+	}
+	return c.status(name, items)
+}
+
+func (c *fakeClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
+	c.selected = append(c.selected, name)
+	if len(c.selectErrs) > 0 {
+		err := c.selectErrs[0]
+		c.selectErrs = c.selectErrs[1:]
+		return nil, err
+	}
+	return imap.NewMailboxStatus(name, nil), nil
+}
+
+func slowOnSubject(subject string) *fakeClient {
+	var running int32
+	return &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			panic("concurrent searches on one connection")
+		}
+		defer atomic.AddInt32(&running, -1)
+		if sc.Header.Get("Subject") == subject {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return []uint32{1, 2}, nil
+	}}
+}
+
+func Test_collectStatsShouldReportTimedOutCriteriaInBestEffortMode(t *testing.T) {
+	*bestEffortArg = true
+	defer func() { *bestEffortArg = false }()
+
+	st, err := collectStats(slowOnSubject("slow"), statsConfig{
+		"fast_count":  &criteriaCfg{Timeout: time.Second},
+		"other_count": &criteriaCfg{Seen: true},
+		"slow_count": &criteriaCfg{
+			Headers: map[string]string{"Subject": "slow"},
+			Timeout: 10 * time.Millisecond,
+		},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"fast_count": 2, "other_count": 2, "slow_count_error": "timeout"}, st)
+}
+
+func Test_collectStatsShouldFailOnTimeout(t *testing.T) {
+	st, err := collectStats(slowOnSubject("slow"), statsConfig{
+		"slow_count": &criteriaCfg{
+			Headers: map[string]string{"Subject": "slow"},
+			Timeout: 10 * time.Millisecond,
+		},
+	}, nil)
+	assert.Equal(t, errSearchTimeout, err)
+	assert.Nil(t, st)
+}
+
+// terminatingClient is a fakeClient whose connection can be closed
+type terminatingClient struct {
+	*fakeClient
+	terminated bool
+}
+
+func (c *terminatingClient) Terminate() error {
+	c.terminated = true
+	return nil
+}
+
+func Test_collectStatsShouldRedialAfterTimedOutSearch(t *testing.T) {
+	*bestEffortArg = true
+	defer func() { *bestEffortArg = false }()
+
+	first := &terminatingClient{fakeClient: slowOnSubject("slow")}
+	var redialed []*terminatingClient
+	redial := func() (imapClient, error) {
+		c := &terminatingClient{fakeClient: slowOnSubject("slow")}
+		redialed = append(redialed, c)
+		return c, nil
+	}
+	st, err := collectStats(first, statsConfig{
+		"a_slow_count": &criteriaCfg{
+			Headers: map[string]string{"Subject": "slow"},
+			Timeout: 10 * time.Millisecond,
+		},
+		"b_count": &criteriaCfg{Seen: true},
+	}, redial)
+	require.NoError(t, err)
+
+	assert.Equal(t, stats{"a_slow_count_error": "timeout", "b_count": 2}, st)
+	assert.True(t, first.terminated)
+	require.Len(t, redialed, 1)
+	assert.False(t, redialed[0].terminated)
+}
+
+func Test_addMetaShouldOutputUidValidity(t *testing.T) {
+	resetConnStats(t)
+	c := newTestClient(t)
+	mbox, err := c.Select("INBOX", true)
+	require.NoError(t, err)
+	require.NotZero(t, mbox.UidValidity)
+
+	st := stats{"unseen_count": 0}
+	cfg := &config{}
+	require.NoError(t, addMeta(st, mbox, cfg))
+	hash, err := cfg.hash()
+	require.NoError(t, err)
+
+	actual, err := json.Marshal(st)
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		fmt.Sprintf(`{"unseen_count": 0, "_meta": {"uidvalidity": %d, "config_hash": %q,
+			"connections": {"opened": 0, "reused": 0, "logins": 0, "searches": 0}}}`, mbox.UidValidity, hash),
+		string(actual))
+}
+
+func Test_configHashShouldChangeOnConfigEdits(t *testing.T) {
+	load := func(content string) *config {
+		path := filepath.Join(t.TempDir(), configName)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		cfg, err := fetchConfig(path)
+		require.NoError(t, err)
+		return cfg
+	}
+	original := load(`
+accounts:
+  foo@bar.com:
+    INBOX:
+      boss_count:
+        headers:
+          From: boss@bar.com
+        seen: true
+`)
+	reordered := load(`
+accounts:
+  foo@bar.com:
+    INBOX:
+      boss_count:
+        seen: true
+        headers:
+          From: boss@bar.com
+`)
+	edited := load(`
+accounts:
+  foo@bar.com:
+    INBOX:
+      boss_count:
+        headers:
+          From: boss@corp.com
+        seen: true
+`)
+
+	hash := func(cfg *config) string {
+		res, err := cfg.hash()
+		require.NoError(t, err)
+		return res
+	}
+	assert.Len(t, hash(original), 64)
+	assert.Equal(t, hash(original), hash(reordered))
+	assert.NotEqual(t, hash(original), hash(edited))
+}
+
+func Test_writeStatsShouldSeparateProfiles(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func() {
+		*writeCacheArg, *quietArg, *profileArg = false, false, ""
+	}()
+
+	for _, profile := range []string{"work", "personal"} {
+		*profileArg = profile
+		require.NoError(t, writeStats(stats{"profile": profile}))
+	}
+
+	for _, profile := range []string{"work", "personal"} {
+		*profileArg = profile
+		actual, err := ioutil.ReadFile(cacheFilename())
+		require.NoError(t, err)
+		assert.JSONEq(t, fmt.Sprintf(`{"profile": %q}`, profile), string(actual))
+		assert.Equal(t, filepath.Join(cacheDir, profile), filepath.Dir(cacheFilename()))
+	}
+}
+
+func Test_writeStatsShouldCacheJSONWhateverOutputFormat(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func(format, user, mbox string) {
+		*writeCacheArg, *quietArg, *formatArg, *templateArg, outputTemplate = false, false, format, "", nil
+		*userArg, *mboxArg = user, mbox
+	}(*formatArg, *userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+
+	var tests = []struct {
+		name     string
+		format   string
+		template string
+		// expected is the output rendered from the cache
+		expected string
+	}{
+		{"template", formatJSON, `{{.unseen_count}} unseen`, "3 unseen\n"},
+		{"prometheus", formatPrometheus, "", "# HELP imapstats_unseen_count imapstats stat of a mailbox\n" +
+			"# TYPE imapstats_unseen_count gauge\n" +
+			`imapstats_unseen_count{account="foo@bar.com",mailbox="INBOX"} 3` + "\n"},
+		{"text", formatText, "", "unseen_count 3\n"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			*formatArg, *templateArg, outputTemplate = tt.format, "", nil
+			if tt.template != "" {
+				require.NoError(t, loadOutputTemplateFrom(t, tt.template))
+				*templateArg = "stats.tmpl"
+			}
+			require.NoError(t, writeStats(stats{"unseen_count": 3}))
+
+			actual, err := ioutil.ReadFile(cacheFilename())
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"unseen_count": 3}`, string(actual))
+
+			var buf bytes.Buffer
+			require.NoError(t, renderCached(bytes.NewReader(actual), &buf))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func Test_validateProfile(t *testing.T) {
+	assert.NoError(t, validateProfile(""))
+	assert.NoError(t, validateProfile("work"))
+	assert.Error(t, validateProfile(".."))
+	assert.Error(t, validateProfile("foo/bar"))
+}
+
+func Test_collectStatsShouldUseInternalDateIfSet(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{
+		"foo_count": &criteriaCfg{
+			Headers:         map[string]string{"Subject": "foo"},
+			Fetch:           true,
+			UseInternalDate: true,
+		},
+	}, nil)
+	require.NoError(t, err)
+	letters := st["foo_count_messages"].([]*letter)
+	require.Len(t, letters, 1)
+	assert.True(t, letters[0].time.Equal(time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)))
+	letters[0].time = time.Time{}
+	assert.Equal(t, []*letter{{Date: "2021-03-01T10:00:00Z", From: "foo@bar.com", Subject: "foo"}}, letters)
+}
+
+func Test_collectStatsShouldReconnectOnceWhenServerClosesConnection(t *testing.T) {
+	searches := 0
+	closing := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		searches++
+		if searches > 1 {
+			return nil, client.ErrNoMailboxSelected
+		}
+		return []uint32{1}, nil
+	}}
+	fresh := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		return []uint32{1, 2}, nil
+	}}
+	redials := 0
+	redial := func() (imapClient, error) {
+		redials++
+		return fresh, nil
+	}
+
+	st, err := collectStats(closing, statsConfig{
+		"a_count": &criteriaCfg{},
+		"b_count": &criteriaCfg{},
+		"c_count": &criteriaCfg{},
+	}, redial)
+	require.NoError(t, err)
+	assert.Equal(t, 1, redials)
+	assert.Len(t, st, 3)
+}
+
+func Test_collectStatsShouldReconnectOnlyOnce(t *testing.T) {
+	closing := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		return nil, errors.New("imap: connection closed")
+	}}
+	redials := 0
+	redial := func() (imapClient, error) {
+		redials++
+		return closing, nil
+	}
+
+	_, err := collectStats(closing, statsConfig{
+		"a_count": &criteriaCfg{},
+		"b_count": &criteriaCfg{},
+	}, redial)
+	assert.EqualError(t, err, "imap: connection closed")
+	assert.Equal(t, 1, redials)
+}
+
+func Test_collectStatsShouldGroupByWeekday(t *testing.T) {
+	withLocation(t, "UTC")
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{
+		"unseen_count": &criteriaCfg{GroupBy: groupByWeekday},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, st["unseen_count"])
+	assert.NotContains(t, st, "unseen_count_messages")
+	byWeekday := st["unseen_count_by_weekday"].(map[string]int)
+	assert.Equal(t, 1, byWeekday["Monday"])
+	assert.Equal(t, 1, byWeekday["Tuesday"])
+	assert.Len(t, byWeekday, 7)
+}
+
+func Test_duplicateCounter(t *testing.T) {
+	underTest := &duplicateCounter{}
+	for _, id := range []string{"<a@foo>", "<b@foo>", "<a@foo>", "", "", "<c@foo>", "<a@foo>", "<b@foo>"} {
+		underTest.add(id)
+	}
+	assert.Equal(t, 3, underTest.duplicates)
+}
+
+func Test_collectStatsShouldSelectOverriddenMailbox(t *testing.T) {
+	underTest := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		return []uint32{1}, nil
+	}}
+
+	st, err := collectStats(underTest, statsConfig{
+		"archived_count": &criteriaCfg{Mailbox: "Archive"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"archived_count": 1}, st)
+	assert.Equal(t, []string{"Archive", "INBOX"}, underTest.selected)
+
+	underTest.selected = nil
+	_, err = collectStats(underTest, statsConfig{
+		"inbox_count": &criteriaCfg{Mailbox: "INBOX"},
+		"other_count": &criteriaCfg{},
+	}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, underTest.selected)
+}
+
+func Test_collectStatsShouldSplitBySeen(t *testing.T) {
+	var searched []*imap.SearchCriteria
+	underTest := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		searched = append(searched, sc)
+		if len(sc.WithFlags) > 0 {
+			return []uint32{1, 2, 3}, nil
+		}
+		return []uint32{4}, nil
+	}}
+
+	st, err := collectStats(underTest, statsConfig{
+		"boss_count": &criteriaCfg{
+			Headers:     map[string]string{"From": "boss@bar.com"},
+			SplitBySeen: true,
+		},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"boss_count": 4, "boss_count_seen": 3, "boss_count_unseen": 1}, st)
+
+	require.Len(t, searched, 2)
+	assert.Equal(t, []string{imap.SeenFlag}, searched[0].WithFlags)
+	assert.Empty(t, searched[0].WithoutFlags)
+	assert.Empty(t, searched[1].WithFlags)
+	assert.Equal(t, []string{imap.SeenFlag}, searched[1].WithoutFlags)
+	for _, sc := range searched {
+		assert.Equal(t, "boss@bar.com", sc.Header.Get("From"))
+	}
+}
+
+func Test_collectStatsShouldUseStatusForDefaultCriteria(t *testing.T) {
+	c := newTestClient(t, "foo", "bar")
+
+	st, err := collectStats(c, statsConfig{"unseen_count": &criteriaCfg{}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"unseen_count": 2}, st)
+}
+
+func Test_collectStatsShouldFallBackToSearchIfStatusLacksUnseen(t *testing.T) {
+	searches := 0
+	underTest := &fakeClient{
+		search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+			searches++
+			assert.Equal(t, []string{imap.SeenFlag}, sc.WithoutFlags)
+			return []uint32{1, 2, 3}, nil
+		},
+		status: func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+			assert.Equal(t, "INBOX", name)
+			assert.Equal(t, []imap.StatusItem{imap.StatusUnseen}, items)
+			// some servers answer with only the items they like
+			mbox := imap.NewMailboxStatus(name, nil)
+			mbox.Items[imap.StatusMessages] = nil
+			mbox.Messages = 10
+			return mbox, nil
+		},
+	}
+
+	st, err := collectStats(underTest, statsConfig{"unseen_count": &criteriaCfg{}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"unseen_count": 3}, st)
+	assert.Equal(t, 1, searches)
+}
+
+func Test_collectStatsShouldFallBackToSearchIfStatusFails(t *testing.T) {
+	underTest := &fakeClient{
+		search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+			assert.Equal(t, []string{imap.SeenFlag}, sc.WithoutFlags)
+			return []uint32{1, 2}, nil
+		},
+		status: func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+			return nil, errors.New("STATUS is disabled")
+		},
+	}
+
+	st, err := collectStats(underTest, statsConfig{"unseen_count": &criteriaCfg{}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"unseen_count": 2}, st)
+}
+
+func Test_collectStatsShouldNotSearchIfStatusHasUnseen(t *testing.T) {
+	underTest := &fakeClient{
+		search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+			t.Fatal("unexpected search")
+			return nil, nil
+		},
+		status: func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+			mbox := imap.NewMailboxStatus(name, items)
+			mbox.Unseen = 5
+			return mbox, nil
+		},
+	}
+
+	st, err := collectStats(underTest, statsConfig{"unseen_count": &criteriaCfg{}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"unseen_count": 5}, st)
+}
+
+func Test_containsAllFold(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected bool
+		given    []string
+	}{
+		{"lower", true, []string{"invoice"}},
+		{"upper", true, []string{"INVOICE"}},
+		{"mixed", true, []string{"InVoIcE", "due"}},
+		{"one missing", false, []string{"invoice", "receipt"}},
+		{"none", true, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, containsAllFold("Your Invoice is DUE", tt.given))
+		})
+	}
+}
+
+func Test_collectStatsShouldRecheckBodyIgnoringCase(t *testing.T) {
+	*foldCaseArg = true
+	defer func() { *foldCaseArg = false }()
+
+	c := newTestClient(t)
+	for _, body := range []string{"Your INVOICE", "your invoice", "Invoice attached", "a receipt"} {
+		msg := "From: foo@bar.com\r\nSubject: bill\r\n\r\n" + body
+		require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)))
+	}
+	_, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+
+	st, err := collectStats(c, statsConfig{
+		"invoices": &criteriaCfg{Body: []string{"invoice"}, Fetch: true},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, st["invoices"])
+	assert.Len(t, st["invoices_messages"], 3)
+
+	unseen, err := c.Search(&imap.SearchCriteria{WithoutFlags: []string{imap.SeenFlag}})
+	require.NoError(t, err)
+	assert.Len(t, unseen, 4, "rechecking must not mark messages seen")
+}
+
+func Test_collectStatsShouldNotCountTruncatedCandidatesIgnoringCase(t *testing.T) {
+	*foldCaseArg = true
+	defer func() { *foldCaseArg = false }()
+
+	c := newTestClient(t)
+	for _, body := range []string{"Your INVOICE", "your invoice", "Invoice attached", "a receipt"} {
+		msg := "From: foo@bar.com\r\nSubject: bill\r\n\r\n" + body
+		require.NoError(t, c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(msg)))
+	}
+	_, err := c.Select("INBOX", false)
+	require.NoError(t, err)
+
+	st, err := collectStats(c, statsConfig{
+		"invoices": &criteriaCfg{Body: []string{"invoice"}, Fetch: true, FetchLimit: intRef(1)},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, st["invoices"], "the server count is kept over the fetch limit")
+	assert.Len(t, st["invoices_messages"], 1)
+}
+
+func Test_collectStatsShouldDecodeSubjectsInAnyCharset(t *testing.T) {
+	c := newTestClient(t,
+		"Hello",
+		"=?UTF-8?B?0J/RgNC40LLQtdGCLCDQvNC40YA=?=",
+		"=?ISO-8859-1?Q?Caf=E9_ouvert?=",
+		"=?windows-1251?B?z/Do4uXy?=",
+		"Re: =?utf-8?q?Caf=C3=A9?=",
+		"=?x-unknown?B?SGVsbG8=?=")
+
+	st, err := collectStats(c, statsConfig{
+		"all": &criteriaCfg{AnySeen: true, Fetch: true, Fields: []string{"subject"}},
+	}, nil)
+	require.NoError(t, err)
+
+	var subjects []string
+	for _, l := range st["all_messages"].([]*letter) {
+		subjects = append(subjects, l.Subject)
+	}
+	assert.ElementsMatch(t, []string{
+		"A little message, just for you",
+		"Hello",
+		"Привет, мир",
+		"Café ouvert",
+		"Привет",
+		"Re: Café",
+		"=?x-unknown?B?SGVsbG8=?=",
+	}, subjects)
+}
+
+func Test_writeStatsShouldKeepCacheIfEncodingFails(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func() { *writeCacheArg, *quietArg, outputTemplate = false, false, nil }()
+	require.NoError(t, writeStats(stats{"unseen_count": 1}))
+
+	require.NoError(t, loadOutputTemplateFrom(t, `{{sum .unseen_count}} {{sum .boss_count_messages}}`))
+	*quietArg = false
+	assert.Error(t, writeStats(stats{"unseen_count": 2, "boss_count_messages": []*letter{}}))
+
+	actual, err := ioutil.ReadFile(cacheFilename())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"unseen_count": 1}`, string(actual))
+	files, err := ioutil.ReadDir(cacheDir)
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	assert.Equal(t, []string{filepath.Base(cacheFilename()), filepath.Base(cacheFilename()) + lockSuffix}, names)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const formatNagios = "nagios"
+
+// states of a Nagios check, also its exit codes
+const (
+	nagiosOK = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkConfig is the config whose warning and critical levels -format nagios and waybar check stats against
+var checkConfig *config
+
+// nagiosCheck returns the state of stats and the status line of a Nagios check like
+// CRITICAL - boss_count 3 > 0 | boss_count=3;;0 unseen_count=12;50;100.
+// Criteria without critical level use their threshold as one. All numeric stats are
+// reported as perfdata, exact counts of capped stats as <key>_raw.
+func nagiosCheck(cfg *config, st stats) (int, string) {
+	state := nagiosOK
+	var problems, perfdata []string
+	checked := 0
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		var crits statsConfig
+		if cfg != nil {
+			crits = cfg.getStatsCfg(account, mailbox)
+		}
+		for k, v := range flatten(st) {
+			label := nagiosLabel(account, mailbox, k)
+			perf := fmt.Sprintf("%s=%s", label, prometheusValue(v))
+			if cr := crits[strings.TrimSuffix(k, rawSuffix)]; cr != nil {
+				perf += fmt.Sprintf(";%s;%s", nagiosRange(cr.thresholdOp(), cr.Warning), nagiosRange(cr.thresholdOp(), cr.critical()))
+			}
+			perfdata = append(perfdata, perf)
+		}
+		for k, cr := range crits {
+			if cr == nil || (cr.Warning == nil && cr.critical() == nil) {
+				continue
+			}
+			checked++
+			v, _ := rawStat(st, k)
+			n, ok := count(v)
+			if !ok {
+				continue
+			}
+			for _, it := range []struct {
+				state int
+				level *int
+			}{{nagiosCritical, cr.critical()}, {nagiosWarning, cr.Warning}} {
+				if it.level != nil && compare(n, cr.thresholdOp(), *it.level) {
+					problems = append(problems, fmt.Sprintf("%s %d %s %d", nagiosLabel(account, mailbox, k), n, cr.thresholdOp(), *it.level))
+					if it.state > state {
+						state = it.state
+					}
+					break
+				}
+			}
+		}
+	})
+	sort.Strings(problems)
+	sort.Strings(perfdata)
+	summary := fmt.Sprintf("%d stats within levels", checked)
+	if len(problems) > 0 {
+		summary = strings.Join(problems, ", ")
+	}
+	line := nagiosStates[state] + " - " + summary
+	if len(perfdata) > 0 {
+		line += " | " + strings.Join(perfdata, " ")
+	}
+	return state, line
+}
+
+// critical returns the critical level of a Nagios check, the threshold unless set
+func (cr *criteriaCfg) critical() *int {
+	if cr.Critical != nil {
+		return cr.Critical
+	}
+	return cr.Threshold
+}
+
+// nagiosLabel names a stat of an account and mailbox in perfdata, qualified only by
+// accounts and mailboxes the run is over. Labels with spaces, quotes or = are quoted.
+func nagiosLabel(account string, mailbox string, key string) string {
+	var path []string
+	if allAccounts() {
+		path = append(path, account)
+	}
+	if multiMailbox() {
+		path = append(path, mailbox)
+	}
+	label := strings.Join(append(path, key), "/")
+	if strings.ContainsAny(label, " '=") {
+		return "'" + strings.ReplaceAll(label, "'", "''") + "'"
+	}
+	return label
+}
+
+// nagiosRange turns a level compared by op into a Nagios range that alerts in the same cases
+func nagiosRange(op string, level *int) string {
+	if level == nil {
+		return ""
+	}
+	n := *level
+	switch op {
+	case ">=":
+		return fmt.Sprint(n - 1)
+	case "<":
+		return fmt.Sprintf("%d:", n)
+	case "<=":
+		return fmt.Sprintf("%d:", n+1)
+	case "==":
+		return fmt.Sprintf("@%d:%d", n, n)
+	}
+	return fmt.Sprint(n)
+}
+
+func writeNagios(w io.Writer, st stats) error {
+	_, line := nagiosCheck(checkConfig, st)
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
+
+// dieUnknown reports err as the UNKNOWN state of a Nagios check with -format nagios,
+// as Nagios shows the first line of stdout but not stderr
+func dieUnknown(err error) {
+	if *formatArg != formatNagios {
+		return
+	}
+	log.Printf("fatal: %T %s", err, err)
+	fmt.Printf("%s - %s\n", nagiosStates[nagiosUnknown], strings.SplitN(err.Error(), "\n", 2)[0])
+	os.Exit(nagiosUnknown)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_nagiosCheck(t *testing.T) {
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+
+	cfg, err := loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    INBOX:
+      unseen_count:
+        warning: 50
+        critical: 100
+      boss_count:
+        threshold: 0
+      news_count:
+        warning: 1
+        threshold_op: <
+      spam_count:
+        cap: 9
+        warning: 20
+`)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name          string
+		expectedState int
+		expectedLine  string
+		given         stats
+	}{
+		{"ok", nagiosOK,
+			"OK - 4 stats within levels | boss_count=0;;0 news_count=3;1:; spam_count=5;20; spam_count_raw=5;20; unseen_count=12;50;100",
+			stats{"unseen_count": 12, "boss_count": &fetchedStat{}, "news_count": 3, "spam_count": 5, "spam_count_raw": 5}},
+		{"warning", nagiosWarning,
+			"WARNING - news_count 0 < 1, spam_count 25 > 20 | boss_count=0;;0 news_count=0;1:; spam_count_raw=25;20; unseen_count=12;50;100",
+			stats{"unseen_count": 12, "boss_count": &fetchedStat{}, "news_count": 0, "spam_count": "9+", "spam_count_raw": 25}},
+		{"critical", nagiosCritical,
+			"CRITICAL - boss_count 1 > 0, unseen_count 101 > 100 | boss_count=1;;0 unseen_count=101;50;100",
+			stats{"unseen_count": 101, "boss_count": &fetchedStat{Count: 1}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			state, line := nagiosCheck(cfg, tt.given)
+			assert.Equal(t, tt.expectedState, state)
+			assert.Equal(t, tt.expectedLine, line)
+		})
+	}
+}
+
+func Test_nagiosCheckShouldQualifyLabels(t *testing.T) {
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "", "INBOX,Sent Mail"
+
+	_, line := nagiosCheck(nil, stats{
+		"foo@bar.com": stats{
+			"INBOX":     stats{"unseen_count": 1},
+			"Sent Mail": stats{"unseen_count": 2},
+		},
+	})
+
+	assert.Equal(t, "OK - 0 stats within levels | 'foo@bar.com/Sent Mail/unseen_count'=2 foo@bar.com/INBOX/unseen_count=1", line)
+}
+
+func Test_nagiosRange(t *testing.T) {
+	level := 5
+	assert.Equal(t, "5", nagiosRange(">", &level))
+	assert.Equal(t, "4", nagiosRange(">=", &level))
+	assert.Equal(t, "5:", nagiosRange("<", &level))
+	assert.Equal(t, "6:", nagiosRange("<=", &level))
+	assert.Equal(t, "@5:5", nagiosRange("==", &level))
+	assert.Equal(t, "", nagiosRange(">", nil))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// newestKey keeps dates of the newest fetched messages of new_only criteria
+// so that the next run lists only messages newer than them. It is kept in the cache only.
+const newestKey = "_newest"
+
+// prevNewest are dates of the newest fetched messages per key read from the cache of the previous run
+var prevNewest map[string]time.Time
+
+// newest returns dates of the newest fetched messages kept in the cache
+func (m cachedMailbox) newest() map[string]time.Time {
+	var dates map[string]time.Time
+	if b, found := m[newestKey]; found {
+		if err := json.Unmarshal(b, &dates); err != nil {
+			warnf("can not read newest messages from cache: %s", err)
+			return nil
+		}
+	}
+	return dates
+}
+
+// isNew tells whether m is newer than the newest message fetched for key k by the previous run
+func (cr *criteriaCfg) isNew(k string, m *imap.Message) bool {
+	prev, found := prevNewest[k]
+	return !cr.NewOnly || !found || cr.messageDate(m).After(prev)
+}
+
+// recordNewest keeps the date of the newest fetched message of key k for the next run.
+// The previous date is kept if nothing was fetched.
+func recordNewest(st stats, k string, newest time.Time) {
+	if prev, found := prevNewest[k]; found && !newest.After(prev) {
+		newest = prev
+	}
+	if newest.IsZero() {
+		return
+	}
+	mergeNewest(st, map[string]time.Time{k: newest})
+}
+
+// mergeNewest adds dates of the newest fetched messages to ones kept in st
+func mergeNewest(st stats, newest map[string]time.Time) {
+	dates, _ := st[newestKey].(map[string]time.Time)
+	if dates == nil {
+		dates = map[string]time.Time{}
+		st[newestKey] = dates
+	}
+	for k, d := range newest {
+		dates[k] = d
+	}
+}
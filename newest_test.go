@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withPrevNewest(t *testing.T, dates map[string]time.Time) {
+	orig := prevNewest
+	prevNewest = dates
+	t.Cleanup(func() { prevNewest = orig })
+}
+
+func Test_collectStatsShouldListOnlyMessagesNewerThanPrevNewest(t *testing.T) {
+	withPrevNewest(t, map[string]time.Time{
+		"foo_count": time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC),
+	})
+	c := newTestClient(t, "first", "second", "third")
+
+	st, err := collectStats(c, statsConfig{
+		"foo_count": &criteriaCfg{
+			Headers: map[string]string{"From": "foo@bar.com"},
+			Fetch:   true,
+			NewOnly: true,
+			Fields:  []string{"subject"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, st["foo_count"])
+	assert.Equal(t, []*letter{{Subject: "second"}, {Subject: "third"}}, st["foo_count_messages"])
+	newest := st[newestKey].(map[string]time.Time)
+	assert.True(t, time.Date(2021, 2, 3, 10, 0, 0, 0, time.UTC).Equal(newest["foo_count"]), "newest: %s", newest)
+}
+
+func Test_recordNewestShouldKeepPrevNewestIfNothingIsNewer(t *testing.T) {
+	prev := time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC)
+	withPrevNewest(t, map[string]time.Time{"foo_count": prev})
+
+	st := stats{}
+	recordNewest(st, "foo_count", time.Time{})
+	recordNewest(st, "bar_count", time.Time{})
+
+	assert.Equal(t, stats{newestKey: map[string]time.Time{"foo_count": prev}}, st)
+}
+
+func Test_cachedMailboxShouldReadNewestDates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	require.NoError(t, ioutil.WriteFile(path,
+		[]byte(`{"foo_count": 1, "_newest": {"foo_count": "2021-02-01T10:00:00Z"}}`), 0600))
+
+	cached, err := readCachedMailbox(path)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC).Equal(cached.newest()["foo_count"]))
+
+	cached, err = readCachedMailbox(filepath.Join(t.TempDir(), "no-cache"))
+	require.NoError(t, err)
+	assert.Nil(t, cached.newest())
+}
+
+func Test_readCachedMailboxShouldReadStatsNestedInCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	require.NoError(t, ioutil.WriteFile(path,
+		[]byte(`{"foo@bar.com": {"INBOX": {"_newest": {"foo_count": "2021-02-01T10:00:00Z"}}}}`), 0600))
+
+	cached, err := readCachedMailbox(path, "foo@bar.com", "INBOX")
+	require.NoError(t, err)
+	assert.True(t, time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC).Equal(cached.newest()["foo_count"]))
+
+	cached, err = readCachedMailbox(path, "other@bar.com", "INBOX")
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+func Test_newestShouldBeKeptInCacheOnly(t *testing.T) {
+	withTempCacheDir(t)
+	*writeCacheArg, *quietArg = true, true
+	defer func() { *writeCacheArg, *quietArg = false, false }()
+	newest := map[string]time.Time{"foo_count": time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC)}
+
+	require.NoError(t, writeStats(stats{"foo_count": 1, newestKey: newest}))
+
+	cached, err := readCachedMailbox(cacheFilename())
+	require.NoError(t, err)
+	assert.Equal(t, newest, cached.newest())
+
+	var buf bytes.Buffer
+	require.NoError(t, copyCache(&buf, ttlInfinite, true))
+	assert.JSONEq(t, `{"foo_count": 1}`, buf.String())
+
+	assert.Equal(t, stats{"INBOX": stats{"foo_count": 1}},
+		withoutCacheOnly(stats{"INBOX": stats{"foo_count": 1, newestKey: newest}}))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// oauthCommandPrefix makes -oauth-token a command printing the token rather than a file
+const oauthCommandPrefix = "!"
+
+// readOAuthToken reads an OAuth2 access token of an account from a file or from stdout
+// of a command after !. Like password commands, the command runs once per account
+// until forgetOAuthToken tells that its token expired.
+func readOAuthToken(account string, source string) (string, error) {
+	if strings.HasPrefix(source, oauthCommandPrefix) {
+		return readAccountCommand(account, "oauth token", strings.TrimPrefix(source, oauthCommandPrefix))
+	}
+	return readPasswordFile(source)
+}
+
+// forgetOAuthToken makes the next login of an account run its token command again
+func forgetOAuthToken(account string, source string) {
+	if strings.HasPrefix(source, oauthCommandPrefix) {
+		forgetAccountCommand(account, strings.TrimPrefix(source, oauthCommandPrefix))
+	}
+}
+
+// xoauth2Client is the XOAUTH2 SASL mechanism of Gmail and Office365; go-sasl only
+// implements the standardized OAUTHBEARER one which these servers do not all offer
+type xoauth2Client struct {
+	user  string
+	token string
+	// failure is the error the server sends as a challenge before rejecting the token
+	failure []byte
+}
+
+func (a *xoauth2Client) Start() (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	// an empty response makes the server complete the command with NO
+	a.failure = challenge
+	return []byte{}, nil
+}
+
+// authenticateXOAuth2 authenticates c with an OAuth2 access token.
+// A rejected token is reported like a failed login, an expired one is told apart.
+// The status of the failure does not tell it: 401 is also sent for revoked
+// or malformed tokens, so only failures saying so are considered expired.
+func authenticateXOAuth2(c *client.Client, user string, token string) error {
+	auth := &xoauth2Client{user: user, token: token}
+	err := c.Authenticate(auth)
+	if err == nil {
+		return nil
+	}
+	lErr := classifyLoginError(err)
+	msg := strings.ToLower(err.Error() + " " + string(auth.failure))
+	if strings.Contains(msg, "expired") {
+		var loginErr *loginError
+		if errors.As(lErr, &loginErr) {
+			loginErr.expired, loginErr.transient = true, false
+		}
+	}
+	return lErr
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xoauth2Server accepts token "good" like Gmail, tells "stale" expired and rejects others
+type xoauth2Server struct {
+	conn    server.Conn
+	be      *memory.Backend
+	failure error
+}
+
+func (s *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	if s.failure != nil {
+		return nil, true, s.failure
+	}
+	if response == nil {
+		// ask for the initial response
+		return []byte{}, false, nil
+	}
+	if strings.HasSuffix(string(response), "auth=Bearer stale\x01\x01") {
+		s.failure = errors.New("Token expired (Failure)")
+		return []byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`), false, nil
+	}
+	if !strings.HasSuffix(string(response), "auth=Bearer good\x01\x01") {
+		s.failure = errors.New("Invalid credentials (Failure)")
+		return []byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`), false, nil
+	}
+	user, err := s.be.Login(nil, memoryUser, memoryPassword)
+	if err != nil {
+		return nil, true, err
+	}
+	ctx := s.conn.Context()
+	ctx.State, ctx.User = imap.AuthenticatedState, user
+	return nil, true, nil
+}
+
+func newXOAuth2Client(t *testing.T) *client.Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	be := memory.New()
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	s.EnableAuth("XOAUTH2", func(conn server.Conn) sasl.Server { return &xoauth2Server{conn: conn, be: be} })
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := client.Dial(l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Logout() })
+	return c
+}
+
+func Test_authenticateXOAuth2(t *testing.T) {
+	c := newXOAuth2Client(t)
+
+	require.NoError(t, authenticateXOAuth2(c, "foo@bar.com", "good"))
+	assert.Equal(t, imap.ConnState(imap.AuthenticatedState), c.State())
+}
+
+func Test_authenticateXOAuth2ShouldTellExpiredToken(t *testing.T) {
+	c := newXOAuth2Client(t)
+
+	err := authenticateXOAuth2(c, "foo@bar.com", "stale")
+
+	assert.EqualError(t, err, "login failed: oauth token expired: Token expired (Failure)")
+	assert.Equal(t, exitTokenExpired, errorToExitCode(err))
+	assert.False(t, isTransientLoginError(err))
+}
+
+func Test_authenticateXOAuth2ShouldNotTellEveryRejectedTokenExpired(t *testing.T) {
+	c := newXOAuth2Client(t)
+
+	err := authenticateXOAuth2(c, "foo@bar.com", "revoked")
+
+	assert.EqualError(t, err, "login failed: Invalid credentials (Failure)")
+	assert.Equal(t, exitNoPerm, errorToExitCode(err))
+}
+
+func Test_readOAuthToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(path, []byte("ya29.file\n"), 0600))
+
+	actual, err := readOAuthToken("foo@bar.com", path)
+	require.NoError(t, err)
+	assert.Equal(t, "ya29.file", actual)
+
+	actual, err = readOAuthToken("foo@bar.com", "!echo ya29.command")
+	require.NoError(t, err)
+	assert.Equal(t, "ya29.command", actual)
+
+	_, err = readOAuthToken("foo@bar.com", "!exit 1")
+	assert.EqualError(t, err, `oauth token command "exit 1": exit code 1`)
+}
+
+func Test_readOAuthTokenShouldRunCommandOnceUntilTokenExpires(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	source := "!echo run >> " + runs + "; echo ya29.command"
+	countRuns := func() int {
+		b, err := ioutil.ReadFile(runs)
+		require.NoError(t, err)
+		return strings.Count(string(b), "run")
+	}
+
+	for i := 0; i < 2; i++ {
+		actual, err := readOAuthToken("foo@bar.com", source)
+		require.NoError(t, err)
+		assert.Equal(t, "ya29.command", actual)
+	}
+	assert.Equal(t, 1, countRuns())
+
+	forgetOAuthToken("foo@bar.com", source)
+	_, err := readOAuthToken("foo@bar.com", source)
+	require.NoError(t, err)
+	assert.Equal(t, 2, countRuns())
+}
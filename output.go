@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	hashKey = "_hash"
+
+	formatJSON = "json"
+	formatFlat = "flat"
+
+	// policies of -max-output-policy
+	outputTruncate = "truncate"
+	outputRefuse   = "refuse"
+
+	truncatedKey   = "_truncated"
+	outputErrorKey = "_error"
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case formatJSON, formatFlat, formatPrometheus, formatText, formatNagios, formatWaybar:
+		return nil
+	}
+	return fmt.Errorf("unknown format: %s", format)
+}
+
+func encodeStats(w io.Writer, st stats) error {
+	if outputTemplate != nil {
+		return outputTemplate.Execute(w, st)
+	}
+	switch *formatArg {
+	case formatFlat:
+		return json.NewEncoder(w).Encode(flatStats(st))
+	case formatPrometheus:
+		return writePrometheus(w, st)
+	case formatText:
+		return writeText(w, st)
+	case formatNagios:
+		return writeNagios(w, st)
+	case formatWaybar:
+		return writeWaybar(w, st)
+	default:
+		return json.NewEncoder(w).Encode(st)
+	}
+}
+
+// renderCached reads stats as written to the cache and writes them in -format
+func renderCached(r io.Reader, w io.Writer) error {
+	var st stats
+	if err := json.NewDecoder(r).Decode(&st); err != nil {
+		return fmt.Errorf("bad cached stats: %w", err)
+	}
+	return encodeStatsTerminated(w, withoutCacheOnly(st))
+}
+
+func validateTerminator() error {
+	if *noNewlineArg && *crlfArg {
+		return errors.New("-no-newline and -crlf are mutually exclusive")
+	}
+	return nil
+}
+
+// lineTerminator returns the terminator of written stats chosen by -no-newline or -crlf
+func lineTerminator() string {
+	switch {
+	case *noNewlineArg:
+		return ""
+	case *crlfArg:
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// encodeStatsTerminated encodes stats like encodeStats but ends them with lineTerminator
+func encodeStatsTerminated(w io.Writer, st stats) error {
+	var buf bytes.Buffer
+	if err := encodeStats(&buf, st); err != nil {
+		return err
+	}
+	b := append(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), lineTerminator()...)
+	_, err := w.Write(b)
+	return err
+}
+
+func validateOutputPolicy(policy string) error {
+	switch policy {
+	case outputTruncate, outputRefuse:
+		return nil
+	}
+	return fmt.Errorf("unknown max output policy: %s", policy)
+}
+
+// limitOutput applies -max-output-policy to stats encoding to more than -max-output-bytes.
+// If truncated stats are still too large, only an error marker is left.
+func limitOutput(st stats) (stats, error) {
+	max := *maxOutputBytesArg
+	if max <= 0 {
+		return st, nil
+	}
+	size, err := encodedSize(st)
+	if err != nil || size <= max {
+		return st, err
+	}
+	warnf("stats take %d bytes which exceeds -max-output-bytes %d", size, max)
+	if *maxOutputPolicyArg == outputTruncate {
+		truncated := withoutMessages(st)
+		truncated[truncatedKey] = true
+		n, err := encodedSize(truncated)
+		if err != nil || n <= max {
+			return truncated, err
+		}
+	}
+	return stats{outputErrorKey: fmt.Sprintf("output too large: %d bytes exceed the limit of %d", size, max)}, nil
+}
+
+func encodedSize(st stats) (int, error) {
+	var buf bytes.Buffer
+	if err := encodeStatsTerminated(&buf, st); err != nil {
+		return 0, err
+	}
+	return buf.Len(), nil
+}
+
+// withoutMessages returns a copy of st with fetched messages dropped and counts kept
+func withoutMessages(st stats) stats {
+	res := stats{}
+	for k, v := range st {
+		if _, ok := v.([]*letter); ok {
+			continue
+		}
+		if fetched, ok := v.(*fetchedStat); ok {
+			v = &fetchedStat{Count: fetched.Count, Messages: []*letter{}}
+		}
+		if nested, ok := v.(stats); ok {
+			v = withoutMessages(nested)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// flatStats flattens st with keys prefixed by account and mailbox
+// unless stats are nested under them already
+func flatStats(st stats) map[string]interface{} {
+	res := map[string]interface{}{}
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		for k, v := range flatten(st, account, mailbox) {
+			res[k] = v
+		}
+	})
+	return res
+}
+
+// eachMailboxStats calls fn with stats of each account and mailbox, whether they are
+// nested under accounts and mailboxes or not. Stats decoded from the cache are accepted too.
+func eachMailboxStats(st stats, fn func(account string, mailbox string, st stats)) {
+	mailboxes := func(account string, st stats) {
+		if !multiMailbox() {
+			fn(account, *mboxArg, st)
+			return
+		}
+		for _, name := range mailboxNames() {
+			if nested, ok := asStats(st[name]); ok {
+				fn(account, name, nested)
+			}
+		}
+	}
+	if !allAccounts() {
+		mailboxes(*userArg, st)
+		return
+	}
+	for acc, v := range st {
+		if nested, ok := asStats(v); ok {
+			mailboxes(acc, nested)
+		}
+	}
+}
+
+// asStats returns v as stats if they are nested, either collected or decoded from the cache
+func asStats(v interface{}) (stats, bool) {
+	switch val := v.(type) {
+	case stats:
+		return val, true
+	case map[string]interface{}:
+		return val, true
+	}
+	return nil, false
+}
+
+// flatten turns possibly nested stats into a single level map with dotted keys.
+// Only numeric values are kept, fetched messages are dropped.
+func flatten(st stats, prefix ...string) map[string]interface{} {
+	res := map[string]interface{}{}
+	flattenInto(res, strings.Join(prefix, "."), st)
+	return res
+}
+
+func flattenInto(res map[string]interface{}, key string, v interface{}) {
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + "." + k
+	}
+	switch val := v.(type) {
+	case stats:
+		for k, it := range val {
+			flattenInto(res, join(k), it)
+		}
+	case map[string]interface{}:
+		for k, it := range val {
+			flattenInto(res, join(k), it)
+		}
+	case map[string]int:
+		for k, it := range val {
+			res[join(k)] = it
+		}
+	case *fetchedStat:
+		res[key] = val.Count
+	case int, uint32, float64:
+		res[key] = val
+	}
+}
+
+// statsHash computes a hash of numeric stats that changes only if any of them
+// changes. Volatile metadata is not hashed.
+func statsHash(st stats) string {
+	flat := flatten(st)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		if k == hashKey || k == metaKey || strings.HasPrefix(k, metaKey+".") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%v\n", k, flat[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_flatten(t *testing.T) {
+	given := stats{
+		"unseen_count":             3,
+		"important_count":          1,
+		"important_count_error":    "timeout",
+		"important_count_messages": []*letter{{Subject: "foo"}},
+		"fetched_count":            &fetchedStat{Count: 2, Messages: []*letter{{Subject: "bar"}}},
+		metaKey:                    map[string]interface{}{"uidvalidity": uint32(42)},
+		summariesKey:               map[string]int{"total": 7},
+		"nested": stats{
+			"Sent": stats{"total": 5},
+		},
+	}
+
+	expected := map[string]interface{}{
+		"foo@bar.com.INBOX.unseen_count":      3,
+		"foo@bar.com.INBOX.important_count":   1,
+		"foo@bar.com.INBOX.fetched_count":     2,
+		"foo@bar.com.INBOX._meta.uidvalidity": uint32(42),
+		"foo@bar.com.INBOX.nested.Sent.total": 5,
+		"foo@bar.com.INBOX._summaries.total":  7,
+	}
+	assert.Equal(t, expected, flatten(given, "foo@bar.com", "INBOX"))
+}
+
+func Test_validateFormat(t *testing.T) {
+	assert.NoError(t, validateFormat(formatJSON))
+	assert.NoError(t, validateFormat(formatFlat))
+	assert.EqualError(t, validateFormat("xml"), "unknown format: xml")
+}
+
+func Test_encodeStatsTerminated(t *testing.T) {
+	var tests = []struct {
+		name      string
+		expected  string
+		noNewline bool
+		crlf      bool
+	}{
+		{"default", "{\"unseen_count\":3}\n", false, false},
+		{"no newline", "{\"unseen_count\":3}", true, false},
+		{"crlf", "{\"unseen_count\":3}\r\n", false, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			*noNewlineArg, *crlfArg = tt.noNewline, tt.crlf
+			defer func() { *noNewlineArg, *crlfArg = false, false }()
+
+			var buf bytes.Buffer
+			require.NoError(t, encodeStatsTerminated(&buf, stats{"unseen_count": 3}))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func Test_renderCachedShouldWriteStatsInChosenFormat(t *testing.T) {
+	defer func(format, user, mbox string) {
+		*formatArg, *userArg, *mboxArg = format, user, mbox
+	}(*formatArg, *userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+
+	const cached = `{"unseen_count":3,"boss_count":{"count":1,"messages":[{"subject":"hi"}]}}`
+	var tests = []struct {
+		format   string
+		expected string
+	}{
+		{formatJSON, `{"boss_count":{"count":1,"messages":[{"subject":"hi"}]},"unseen_count":3}` + "\n"},
+		{formatFlat, `{"foo@bar.com.INBOX.boss_count.count":1,"foo@bar.com.INBOX.unseen_count":3}` + "\n"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.format, func(t *testing.T) {
+			*formatArg = tt.format
+
+			var buf bytes.Buffer
+			require.NoError(t, renderCached(strings.NewReader(cached), &buf))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+
+	assert.Error(t, renderCached(strings.NewReader("not json"), &bytes.Buffer{}))
+}
+
+func Test_validateTerminator(t *testing.T) {
+	*noNewlineArg, *crlfArg = true, true
+	defer func() { *noNewlineArg, *crlfArg = false, false }()
+
+	assert.EqualError(t, validateTerminator(), "-no-newline and -crlf are mutually exclusive")
+}
+
+func Test_statsHash(t *testing.T) {
+	given := stats{
+		"unseen_count":       3,
+		"foo_count":          1,
+		"foo_count_messages": []*letter{{Subject: "foo"}},
+		metaKey:              map[string]interface{}{"uidvalidity": uint32(42)},
+	}
+	same := stats{
+		"foo_count":    1,
+		"unseen_count": 3,
+		metaKey:        map[string]interface{}{"uidvalidity": uint32(43)},
+	}
+	different := stats{
+		"unseen_count": 4,
+		"foo_count":    1,
+	}
+
+	assert.Len(t, statsHash(given), 64)
+	assert.Equal(t, statsHash(given), statsHash(same))
+	assert.NotEqual(t, statsHash(given), statsHash(different))
+
+	same[hashKey] = statsHash(same)
+	assert.Equal(t, statsHash(given), statsHash(same))
+}
+
+func Test_limitOutputShouldApplyPolicy(t *testing.T) {
+	withReport(t, "")
+	given := func() stats {
+		return stats{
+			"unseen_count":        2,
+			"boss_count":          1,
+			"boss_count_messages": []*letter{{Subject: strings.Repeat("x", 200)}},
+			"nested_count":        &fetchedStat{Count: 1, Messages: []*letter{{Subject: strings.Repeat("y", 200)}}},
+		}
+	}
+	var tests = []struct {
+		name     string
+		policy   string
+		max      int
+		expected stats
+	}{
+		{"fits", outputTruncate, 10000, given()},
+		{"unlimited", outputRefuse, 0, given()},
+		{"truncate", outputTruncate, 150, stats{
+			"unseen_count": 2,
+			"boss_count":   1,
+			"nested_count": &fetchedStat{Count: 1, Messages: []*letter{}},
+			truncatedKey:   true,
+		}},
+		{"truncate still too large", outputTruncate, 20, stats{
+			outputErrorKey: "output too large: 528 bytes exceed the limit of 20",
+		}},
+		{"refuse", outputRefuse, 150, stats{
+			outputErrorKey: "output too large: 528 bytes exceed the limit of 150",
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			*maxOutputBytesArg, *maxOutputPolicyArg = tt.max, tt.policy
+			defer func() { *maxOutputBytesArg, *maxOutputPolicyArg = 0, outputTruncate }()
+
+			actual, err := limitOutput(given())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_validateOutputPolicy(t *testing.T) {
+	assert.NoError(t, validateOutputPolicy(outputTruncate))
+	assert.NoError(t, validateOutputPolicy(outputRefuse))
+	assert.EqualError(t, validateOutputPolicy("drop"), "unknown max output policy: drop")
+}
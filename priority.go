@@ -0,0 +1,32 @@
+package main
+
+import "sort"
+
+// orderKey lists stat keys by priority of their criteria
+const orderKey = "_order"
+
+// addOrder lists keys of criteria reported in st under orderKey, the highest priority
+// first and keys of the same priority by name. Nothing is added unless a priority is set.
+func addOrder(st stats, cfg statsConfig) {
+	prioritized := false
+	keys := []string{}
+	for k, cr := range cfg {
+		if cr.Priority != 0 {
+			prioritized = true
+		}
+		if _, found := st[k]; found {
+			keys = append(keys, k)
+		}
+	}
+	if !prioritized {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := cfg[keys[i]].Priority, cfg[keys[j]].Priority
+		if pi != pj {
+			return pi > pj
+		}
+		return keys[i] < keys[j]
+	})
+	st[orderKey] = keys
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_addOrderShouldListKeysByPriority(t *testing.T) {
+	cfg := statsConfig{
+		"unseen_count": &criteriaCfg{},
+		"boss_count":   &criteriaCfg{Priority: 10},
+		"alerts_count": &criteriaCfg{Priority: 5},
+		"family_count": &criteriaCfg{Priority: 5},
+		"spam_count":   &criteriaCfg{Priority: -1},
+		"missing":      &criteriaCfg{Priority: 20},
+	}
+	st := stats{"unseen_count": 3, "boss_count": 1, "alerts_count": 0, "family_count": 2, "spam_count": 7}
+
+	addOrder(st, cfg)
+
+	assert.Equal(t, []string{"boss_count", "alerts_count", "family_count", "unseen_count", "spam_count"}, st[orderKey])
+}
+
+func Test_addOrderShouldNotAddOrderWithoutPriorities(t *testing.T) {
+	st := stats{"unseen_count": 3, "boss_count": 1}
+
+	addOrder(st, statsConfig{"unseen_count": &criteriaCfg{}, "boss_count": &criteriaCfg{}})
+
+	assert.NotContains(t, st, orderKey)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const formatPrometheus = "prometheus"
+
+var prometheusUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// prometheusSample is a value of a metric of an account and mailbox.
+// key is the path of a value nested in the stat like a group of group_by.
+type prometheusSample struct {
+	account string
+	mailbox string
+	key     string
+	value   interface{}
+}
+
+// writePrometheus writes numeric stats as gauges in Prometheus text exposition format,
+// e.g. imapstats_unseen_count{account="foo@bar.com",mailbox="INBOX"} 12.
+// Counts of fetched stats are written, their messages are not.
+func writePrometheus(w io.Writer, st stats) error {
+	metrics := map[string][]prometheusSample{}
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		for k, v := range flatten(st) {
+			path := strings.SplitN(k, ".", 2)
+			name := prometheusName(path[0])
+			sample := prometheusSample{account: account, mailbox: mailbox, value: v}
+			if len(path) > 1 {
+				sample.key = path[1]
+			}
+			metrics[name] = append(metrics[name], sample)
+		}
+	})
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s stat of a mailbox\n", name, appName)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		var lines []string
+		for _, s := range metrics[name] {
+			labels := fmt.Sprintf(`account="%s",mailbox="%s"`, prometheusLabel(s.account), prometheusLabel(s.mailbox))
+			if s.key != "" {
+				labels += fmt.Sprintf(`,key="%s"`, prometheusLabel(s.key))
+			}
+			lines = append(lines, fmt.Sprintf("%s{%s} %s\n", name, labels, prometheusValue(s.value)))
+		}
+		sort.Strings(lines)
+		if _, err := io.WriteString(w, strings.Join(lines, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prometheusName turns a stat key into a metric name; keys like _meta lose leading underscores
+// as names starting with __ are reserved
+func prometheusName(key string) string {
+	return appName + "_" + strings.TrimLeft(prometheusUnsafe.ReplaceAllString(key, "_"), "_")
+}
+
+func prometheusLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func prometheusValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
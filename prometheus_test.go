@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writePrometheusShouldWriteGauges(t *testing.T) {
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+
+	var buf bytes.Buffer
+	err := writePrometheus(&buf, stats{
+		"unseen_count":        3,
+		"boss_count":          &fetchedStat{Count: 1},
+		"boss_count_messages": []*letter{{Subject: "foo"}},
+		"unread ratio":        0.25,
+		"by_sender":           map[string]interface{}{"a@b.com": 2, "c\"d": 1},
+		metaKey:               map[string]interface{}{"uidvalidity": uint32(42)},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `# HELP imapstats_boss_count imapstats stat of a mailbox
+# TYPE imapstats_boss_count gauge
+imapstats_boss_count{account="foo@bar.com",mailbox="INBOX"} 1
+# HELP imapstats_by_sender imapstats stat of a mailbox
+# TYPE imapstats_by_sender gauge
+imapstats_by_sender{account="foo@bar.com",mailbox="INBOX",key="a@b.com"} 2
+imapstats_by_sender{account="foo@bar.com",mailbox="INBOX",key="c\"d"} 1
+# HELP imapstats_meta imapstats stat of a mailbox
+# TYPE imapstats_meta gauge
+imapstats_meta{account="foo@bar.com",mailbox="INBOX",key="uidvalidity"} 42
+# HELP imapstats_unread_ratio imapstats stat of a mailbox
+# TYPE imapstats_unread_ratio gauge
+imapstats_unread_ratio{account="foo@bar.com",mailbox="INBOX"} 0.25
+# HELP imapstats_unseen_count imapstats stat of a mailbox
+# TYPE imapstats_unseen_count gauge
+imapstats_unseen_count{account="foo@bar.com",mailbox="INBOX"} 3
+`, buf.String())
+}
+
+func Test_writePrometheusShouldLabelAccountsAndMailboxes(t *testing.T) {
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "", "INBOX,Work"
+
+	var buf bytes.Buffer
+	err := writePrometheus(&buf, stats{
+		"foo@bar.com": stats{
+			"INBOX": stats{"unseen_count": 1},
+			"Work":  stats{"unseen_count": 2},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `# HELP imapstats_unseen_count imapstats stat of a mailbox
+# TYPE imapstats_unseen_count gauge
+imapstats_unseen_count{account="foo@bar.com",mailbox="INBOX"} 1
+imapstats_unseen_count{account="foo@bar.com",mailbox="Work"} 2
+`, buf.String())
+}
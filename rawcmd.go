@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+// readOnlyCommands are commands -raw-command sends without -i-know-what-im-doing
+var readOnlyCommands = map[string]bool{
+	"CAPABILITY":   true,
+	"NOOP":         true,
+	"ID":           true,
+	"NAMESPACE":    true,
+	"LIST":         true,
+	"LSUB":         true,
+	"XLIST":        true,
+	"STATUS":       true,
+	"EXAMINE":      true,
+	"SEARCH":       true,
+	"UID SEARCH":   true,
+	"GETQUOTA":     true,
+	"GETQUOTAROOT": true,
+	"GETACL":       true,
+	"MYRIGHTS":     true,
+}
+
+// rawCommand is a command sent as typed by the user
+type rawCommand struct {
+	name string
+	args string
+}
+
+func (cmd *rawCommand) Command() *imap.Command {
+	var args []interface{}
+	if cmd.args != "" {
+		args = append(args, imap.RawString(cmd.args))
+	}
+	return &imap.Command{Name: cmd.name, Arguments: args}
+}
+
+// parseRawCommand parses a command like STATUS INBOX (MESSAGES).
+// Commands not known to be read-only are refused unless unsafe is set.
+func parseRawCommand(s string, unsafe bool) (*rawCommand, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("raw command must not be empty")
+	}
+	name := strings.ToUpper(fields[0])
+	kind := name
+	if name == "UID" && len(fields) > 1 {
+		kind += " " + strings.ToUpper(fields[1])
+	}
+	if !unsafe && !readOnlyCommands[kind] {
+		return nil, fmt.Errorf("%s is not known to be read-only; pass -i-know-what-im-doing to send it anyway", kind)
+	}
+	args := strings.TrimSpace(strings.TrimSpace(s)[len(fields[0]):])
+	return &rawCommand{name: name, args: args}, nil
+}
+
+// runRawCommand sends cmd and writes all responses to it, the tagged one last
+func runRawCommand(rc rawCommander, cmd *rawCommand, w io.Writer) error {
+	iw := imap.NewWriter(w)
+	status, err := rc.Execute(cmd, responses.HandlerFunc(func(resp imap.Resp) error {
+		if data, ok := resp.(*imap.DataResp); ok {
+			resp = &imap.DataResp{Tag: data.Tag, Fields: atomFields(data.Fields)}
+		}
+		if r, ok := resp.(imap.WriterTo); ok {
+			return r.WriteTo(iw)
+		}
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	return status.WriteTo(iw)
+}
+
+// atomFields turns parsed strings back into atoms so that they are written as the server sent them.
+// Strings that can not be atoms are left to be quoted.
+func atomFields(fields []interface{}) []interface{} {
+	res := make([]interface{}, len(fields))
+	for i, f := range fields {
+		switch f := f.(type) {
+		case string:
+			if f != "" && !strings.ContainsAny(f, " \t\"()\\{") {
+				res[i] = imap.RawString(f)
+				continue
+			}
+		case []interface{}:
+			res[i] = atomFields(f)
+			continue
+		}
+		res[i] = f
+	}
+	return res
+}
+
+// sendRawCommand logs in, examines -mailbox and sends the -raw-command
+func sendRawCommand(w io.Writer) error {
+	cmd, err := parseRawCommand(*rawCommandArg, *unsafeRawCommandArg)
+	if err != nil {
+		return err
+	}
+	c, err := login()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	if _, err := c.Select(*mboxArg, true); err != nil {
+		return err
+	}
+	return runRawCommand(c, cmd, w)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoClient answers any command with an untagged response repeating it
+type echoClient struct {
+	rawFakeClient
+}
+
+func (c *echoClient) Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error) {
+	cmd := cmdr.Command()
+	fields := append([]interface{}{imap.RawString(cmd.Name)}, cmd.Arguments...)
+	if err := h.Handle(&imap.DataResp{Fields: fields}); err != nil {
+		return nil, err
+	}
+	return &imap.StatusResp{Tag: "a1", Type: imap.StatusRespOk, Info: cmd.Name + " completed"}, nil
+}
+
+func Test_runRawCommandShouldPrintAllResponses(t *testing.T) {
+	cmd, err := parseRawCommand("status INBOX (MESSAGES UNSEEN)", false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, runRawCommand(&echoClient{}, cmd, &buf))
+	assert.Equal(t, "* STATUS INBOX (MESSAGES UNSEEN)\r\na1 OK STATUS completed\r\n", buf.String())
+}
+
+func Test_parseRawCommand(t *testing.T) {
+	var tests = []struct {
+		given    string
+		unsafe   bool
+		expected *rawCommand
+	}{
+		{"CAPABILITY", false, &rawCommand{name: "CAPABILITY"}},
+		{"  uid search  UNSEEN ", false, &rawCommand{name: "UID", args: "search  UNSEEN"}},
+		{"STORE 1 +FLAGS (\\Seen)", true, &rawCommand{name: "STORE", args: "1 +FLAGS (\\Seen)"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			actual, err := parseRawCommand(tt.given, tt.unsafe)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+
+	_, err := parseRawCommand("uid store 1 +FLAGS (\\Deleted)", false)
+	assert.EqualError(t, err, "UID STORE is not known to be read-only; pass -i-know-what-im-doing to send it anyway")
+	_, err = parseRawCommand(" ", true)
+	assert.Error(t, err)
+}
+
+func Test_runRawCommandShouldWorkWithServer(t *testing.T) {
+	c := newTestClient(t)
+	cmd, err := parseRawCommand("STATUS INBOX (MESSAGES)", false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, runRawCommand(c, cmd, &buf))
+	assert.Contains(t, buf.String(), "* STATUS INBOX (MESSAGES 1)\r\n")
+	assert.Contains(t, buf.String(), " OK STATUS completed\r\n")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/emersion/go-imap"
+)
+
+// modes of recent_mode
+const (
+	recentStatus = "status"
+	recentSearch = "search"
+	recentIgnore = "ignore"
+)
+
+// recentMode returns recent_mode of cr defaulting to status
+func (cr *criteriaCfg) recentMode() string {
+	if cr.RecentMode == "" {
+		return recentStatus
+	}
+	return cr.RecentMode
+}
+
+// recentOnly tells whether cr has no criteria besides recent ones, so that
+// its count can be taken from STATUS
+func (cr *criteriaCfg) recentOnly() bool {
+	return reflect.DeepEqual(cr.searchFields(), criteriaCfg{
+		Recent:     cr.Recent,
+		RecentMode: cr.RecentMode,
+		Mailbox:    cr.Mailbox,
+	})
+}
+
+// searchFields returns a copy of cr without fields that do not affect which messages
+// are searched or fetched, e.g. thresholds, caching or the order of stats
+func (cr *criteriaCfg) searchFields() criteriaCfg {
+	res := *cr
+	res.Timeout, res.TTL, res.Priority, res.Cap = 0, 0, 0, 0
+	res.Threshold, res.ThresholdOp, res.Warning, res.Critical = nil, "", nil, nil
+	res.Use, res.Args = "", nil
+	return res
+}
+
+// collectRecent reports the count of recent messages according to recent_mode.
+// It returns false if the count has to be searched for.
+func collectRecent(s *searcher, st stats, k string, cr *criteriaCfg) (bool, error) {
+	switch cr.recentMode() {
+	case recentIgnore:
+		return true, nil
+	case recentSearch:
+		return false, nil
+	}
+	mailbox := *mboxArg
+	if cr.Mailbox != "" {
+		mailbox = cr.Mailbox
+	}
+	s.wait()
+	s.limit.take()
+	var mbox *imap.MailboxStatus
+	err := withMailboxRetry(mailbox, func() (err error) {
+		mbox, err = s.c.Status(mailbox, []imap.StatusItem{imap.StatusRecent})
+		return
+	})
+	if err != nil {
+		return false, err
+	}
+	if _, ok := mbox.Items[imap.StatusRecent]; !ok {
+		return false, fmt.Errorf("%s: server did not report RECENT in STATUS", mailbox)
+	}
+	st[k] = int(mbox.Recent)
+	return true, nil
+}
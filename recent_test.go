@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_collectStatsShouldTakeRecentCountsFromChosenSource(t *testing.T) {
+	var tests = []struct {
+		mode           string
+		expected       stats
+		expectedSource string
+	}{
+		{"", stats{"recent_count": 3}, "status"},
+		{recentStatus, stats{"recent_count": 3}, "status"},
+		{recentSearch, stats{"recent_count": 2}, "search"},
+		{recentIgnore, stats{}, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			var source string
+			c := &fakeClient{
+				search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+					source = "search"
+					assert.Equal(t, []string{imap.RecentFlag}, sc.WithFlags)
+					assert.Empty(t, sc.WithoutFlags)
+					return []uint32{1, 2}, nil
+				},
+				status: func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+					source = "status"
+					assert.Equal(t, []imap.StatusItem{imap.StatusRecent}, items)
+					st := imap.NewMailboxStatus(name, items)
+					st.Recent = 3
+					return st, nil
+				},
+			}
+
+			st, err := collectStats(c, statsConfig{
+				"recent_count": &criteriaCfg{Recent: true, RecentMode: tt.mode},
+			}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, st)
+			assert.Equal(t, tt.expectedSource, source)
+		})
+	}
+}
+
+func Test_criteriaCfgLintShouldRejectRecentStatusWithOtherCriteria(t *testing.T) {
+	cr := &criteriaCfg{Recent: true, Headers: map[string]string{"From": "boss@corp.com"}}
+	assert.Equal(t, []configProblem{{location: "k",
+		msg: "recent counts from status can not be combined with other criteria; set recent_mode: search"},
+	}, cr.lint("k", true))
+
+	cr.RecentMode = recentSearch
+	assert.Empty(t, cr.lint("k", true))
+}
+
+func Test_criteriaCfgLintShouldAcceptRecentStatusWithOutputSettings(t *testing.T) {
+	cr := &criteriaCfg{
+		Recent:      true,
+		Mailbox:     "Archive",
+		Timeout:     time.Second,
+		TTL:         time.Minute,
+		Priority:    1,
+		Threshold:   intRef(5),
+		ThresholdOp: ">=",
+		Warning:     intRef(2),
+		Critical:    intRef(5),
+		Cap:         99,
+	}
+	assert.Empty(t, cr.lint("k", true))
+}
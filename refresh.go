@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+)
+
+const (
+	// refreshLogSuffix names the file next to the cache that background refreshes log to
+	refreshLogSuffix = ".refresh.log"
+	// refreshLockSuffix names the file next to the cache locked while a background refresh runs
+	refreshLockSuffix = ".refresh.lock"
+)
+
+var errRefreshing = errors.New("cache is refreshed by another run")
+
+// needsRefresh tells whether -refresh has to refresh the cache read with a given error.
+// A cache read within -ttl is fresh; without -ttl, every read refreshes it for the next one.
+func needsRefresh(readErr error) bool {
+	return readErr != nil || cacheTTL() == ttlInfinite
+}
+
+// lockRefresh takes the lock of background refreshes of the cache without waiting
+func lockRefresh() (*os.File, error) {
+	f, err := os.OpenFile(cacheFilename()+refreshLockSuffix, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLockFile(f); err != nil {
+		f.Close()
+		if err == errCacheLocked {
+			return nil, errRefreshing
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// refreshArgs returns flags of this run turned into a run refreshing the cache
+func refreshArgs() []string {
+	skip := map[string]bool{"read-cache": true, "refresh": true, "write-cache": true, "q": true}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if !skip[f.Name] {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return append(args, "-write-cache", "-q")
+}
+
+// startRefresh starts this program with -write-cache -q in a new session, so that it
+// refreshes the cache after this run exits. Its log goes to a file next to the cache.
+// The refresh holds the refresh lock until it exits, so that reads meanwhile do not start others.
+func startRefresh() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(profileCacheDir(), defaultDirPerms); err != nil {
+		return err
+	}
+	lock, err := lockRefresh()
+	if err == errRefreshing {
+		debugf("not refreshing cache: %s", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the refresh holds its own descriptor of the lock
+	defer lock.Close()
+	logFile, err := os.OpenFile(cacheFilename()+refreshLogSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cachePerms)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, refreshArgs()...)
+	cmd.Stderr = logFile
+	detachRefresh(cmd, lock)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	debugf("refreshing cache in background process %d", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// detachRefresh leaves cmd as is: there are no sessions to start it in,
+// and the refresh lock is not taken without flock anyway
+func detachRefresh(cmd *exec.Cmd, lock *os.File) {}
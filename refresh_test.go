@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_refreshArgsShouldTurnReadIntoWriteCache(t *testing.T) {
+	defer func() {
+		*readCacheArg, *refreshArg, *userArg, *ttlArg = false, false, "", ""
+	}()
+	for name, value := range map[string]string{
+		"read-cache": "true",
+		"refresh":    "true",
+		"user":       "foo@bar.com",
+		"ttl":        "5m",
+	} {
+		require.NoError(t, flag.Set(name, value))
+	}
+
+	var actual []string
+	for _, arg := range refreshArgs() {
+		// flags of go test are set too
+		if !strings.HasPrefix(arg, "-test.") {
+			actual = append(actual, arg)
+		}
+	}
+	assert.Equal(t, []string{"-ttl=5m", "-user=foo@bar.com", "-write-cache", "-q"}, actual)
+}
+
+func Test_needsRefresh(t *testing.T) {
+	defer func() { *ttlArg = "" }()
+	readErr := errors.New("too old")
+
+	assert.True(t, needsRefresh(nil))
+	assert.True(t, needsRefresh(readErr))
+
+	*ttlArg = "5m"
+	assert.False(t, needsRefresh(nil))
+	assert.True(t, needsRefresh(readErr))
+}
@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachRefresh makes cmd run in a new session, so that it is not stopped with
+// the terminal of this run, and hands it the refresh lock to hold until it exits
+func detachRefresh(cmd *exec.Cmd, lock *os.File) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{lock}
+}
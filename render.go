@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// outputTemplate renders stats instead of -format if -template is set
+var outputTemplate *template.Template
+
+// templateFuncs are helpers available to -template besides the builtin ones
+var templateFuncs = template.FuncMap{
+	"count": templateCount,
+	"sum":   templateSum,
+	"date":  templateDate,
+}
+
+// loadOutputTemplate parses a text/template file stats are rendered with
+func loadOutputTemplate(filename string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(filename)).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("bad -template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// templateCount returns the count of a stat whether its messages are fetched or not
+func templateCount(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return float64(val), nil
+	case uint32:
+		return float64(val), nil
+	case float64:
+		return val, nil
+	case *fetchedStat:
+		return float64(val.Count), nil
+	case map[string]interface{}:
+		// fetched stat decoded from the cache
+		return templateCount(val["count"])
+	}
+	return 0, fmt.Errorf("not a count: %v", v)
+}
+
+// templateSum sums counts of stats, e.g. {{sum .boss_count .work_count}}
+func templateSum(vs ...interface{}) (float64, error) {
+	var res float64
+	for _, v := range vs {
+		n, err := templateCount(v)
+		if err != nil {
+			return 0, err
+		}
+		res += n
+	}
+	return res, nil
+}
+
+// templateDate formats the date of a letter with a Go layout, e.g. {{date "Jan 2" .}}.
+// -date-format may drop parts of dates, so they are not parsed back from Date of
+// letters; only RFC3339 ones like Date of cached letters by default are, e.g. {{date "Jan 2" .Date}}.
+func templateDate(layout string, v interface{}) (string, error) {
+	var t time.Time
+	switch val := v.(type) {
+	case *letter:
+		t = val.time
+	case map[string]interface{}:
+		// letter decoded from the cache
+		return templateDate(layout, val["date"])
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, val); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("not a letter or RFC3339 date: %v", v)
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Format(layout), nil
+}
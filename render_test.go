@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadOutputTemplateFrom(t *testing.T, text string) error {
+	filename := filepath.Join(t.TempDir(), "stats.tmpl")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(text), 0600))
+	tmpl, err := loadOutputTemplate(filename)
+	if err != nil {
+		return err
+	}
+	outputTemplate = tmpl
+	return nil
+}
+
+func Test_encodeStatsShouldRenderTemplate(t *testing.T) {
+	defer func() { outputTemplate = nil }()
+	require.NoError(t, loadOutputTemplateFrom(t,
+		`mail: {{sum .unseen_count .boss_count}} boss: {{count .boss_count}}{{range .boss_count_messages}}
+{{date "Jan 2" .Date}} {{.Subject}}{{end}}`))
+
+	var buf bytes.Buffer
+	err := encodeStats(&buf, stats{
+		"unseen_count":        3,
+		"boss_count":          &fetchedStat{Count: 1},
+		"boss_count_messages": []*letter{{Date: "2021-06-10T15:00:00Z", Subject: "foo"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "mail: 4 boss: 1\nJun 10 foo", buf.String())
+}
+
+func Test_templateDateShouldFormatLettersWithoutParsingDateFormat(t *testing.T) {
+	defer func(orig string) { *dateFormatArg = orig }(*dateFormatArg)
+	*dateFormatArg = "Jan 2"
+	defer func() { outputTemplate = nil }()
+	require.NoError(t, loadOutputTemplateFrom(t, `{{range .boss_count_messages}}{{date "2006-01-02" .}} {{.Date}}{{end}}`))
+
+	given := &imap.Message{Envelope: &imap.Envelope{Date: time.Date(2021, 6, 10, 15, 0, 0, 0, time.UTC)}}
+	var buf bytes.Buffer
+	require.NoError(t, encodeStats(&buf, stats{"boss_count_messages": []*letter{newLetter(given, &criteriaCfg{})}}))
+	assert.Equal(t, "2021-06-10 Jun 10", buf.String())
+
+	// cached letters keep RFC3339 dates of the default -date-format only
+	require.NoError(t, loadOutputTemplateFrom(t, `{{range .boss_count_messages}}{{date "2006-01-02" .}}{{end}}`))
+	buf.Reset()
+	require.NoError(t, renderCached(bytes.NewBufferString(
+		`{"boss_count_messages": [{"date": "2021-06-10T15:00:00Z"}]}`), &buf))
+	assert.Equal(t, "2021-06-10\n", buf.String())
+}
+
+func Test_encodeStatsShouldRenderTemplateOfCachedStats(t *testing.T) {
+	defer func() { outputTemplate = nil }()
+	require.NoError(t, loadOutputTemplateFrom(t, `{{sum .unseen_count .boss_count .missing}}`))
+
+	var buf bytes.Buffer
+	err := renderCached(bytes.NewBufferString(`{"unseen_count": 3, "boss_count": {"count": 2, "messages": []}}`), &buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "5\n", buf.String())
+}
+
+func Test_loadOutputTemplateShouldFail(t *testing.T) {
+	err := loadOutputTemplateFrom(t, `{{.unseen_count`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad -template: ")
+
+	_, err = loadOutputTemplate(filepath.Join(t.TempDir(), "missing.tmpl"))
+	assert.Error(t, err)
+}
+
+func Test_encodeStatsShouldFailOnNotCountInSum(t *testing.T) {
+	defer func() { outputTemplate = nil }()
+	require.NoError(t, loadOutputTemplateFrom(t, `{{sum .boss_count_messages}}`))
+
+	err := encodeStats(&bytes.Buffer{}, stats{"boss_count_messages": []*letter{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runReport describes what happened during a run, separately from the stats
+type runReport struct {
+	mu sync.Mutex
+
+	Started   time.Time                 `json:"started"`
+	Duration  float64                   `json:"duration_seconds"`
+	Accounts  []string                  `json:"accounts"`
+	Mailboxes []string                  `json:"mailboxes"`
+	Criteria  map[string]criteriaReport `json:"criteria"` // by account/mailbox/key
+	Warnings  []string                  `json:"warnings"`
+	Errors    []string                  `json:"errors"`
+	Success   bool                      `json:"success"`
+
+	accounts  map[string]bool
+	mailboxes map[string]bool
+}
+
+type criteriaReport struct {
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// report collects the report of the current run
+var report = newRunReport()
+
+func newRunReport() *runReport {
+	return &runReport{
+		Started:   now(),
+		Accounts:  []string{},
+		Mailboxes: []string{},
+		Criteria:  map[string]criteriaReport{},
+		Warnings:  []string{},
+		Errors:    []string{},
+		accounts:  map[string]bool{},
+		mailboxes: map[string]bool{},
+	}
+}
+
+func (r *runReport) touch(account string, mailbox string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.accounts[account] {
+		r.accounts[account] = true
+		r.Accounts = append(r.Accounts, account)
+		sort.Strings(r.Accounts)
+	}
+	if !r.mailboxes[mailbox] {
+		r.mailboxes[mailbox] = true
+		r.Mailboxes = append(r.Mailboxes, mailbox)
+		sort.Strings(r.Mailboxes)
+	}
+}
+
+// timing records how long collecting stat key of a mailbox of an account took
+func (r *runReport) timing(account string, mailbox string, key string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cr := criteriaReport{Duration: d.Seconds()}
+	if err != nil {
+		cr.Error = err.Error()
+	}
+	r.Criteria[strings.Join([]string{account, mailbox, key}, "/")] = cr
+}
+
+func (r *runReport) warning(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, msg)
+}
+
+func (r *runReport) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duration = now().Sub(r.Started).Seconds()
+	r.Success = err == nil
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// strictErr fails the run under -strict if any warning was reported
+func (r *runReport) strictErr() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !*strictArg || len(r.Warnings) == 0 {
+		return nil
+	}
+	return fmt.Errorf("-strict: %d warning(s) reported, the first one: %s", len(r.Warnings), r.Warnings[0])
+}
+
+// warnf logs a warning and records it in the run report
+func warnf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	log.Print("WARN " + msg)
+	report.warning(msg)
+}
+
+// writeFileAtomic makes sure readers never see a partially written file
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// saveReport finishes the run report with err and writes it to -report path if set
+func saveReport(err error) error {
+	report.finish(err)
+	if *reportArg == "" {
+		return nil
+	}
+	report.mu.Lock()
+	b, jsonErr := json.MarshalIndent(report, "", "  ")
+	report.mu.Unlock()
+	if jsonErr != nil {
+		return jsonErr
+	}
+	return writeFileAtomic(*reportArg, b, 0600)
+}
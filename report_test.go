@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withReport(t *testing.T, path string) {
+	report = newRunReport()
+	*reportArg = path
+	t.Cleanup(func() {
+		report = newRunReport()
+		*reportArg = ""
+	})
+}
+
+func readReport(t *testing.T, path string) map[string]interface{} {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var res map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &res))
+	return res
+}
+
+func Test_saveReportShouldContainTimingsAndWarnings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	withReport(t, path)
+	*bestEffortArg = true
+	defer func() { *bestEffortArg = false }()
+
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "foo@bar.com", "INBOX"
+	report.touch("foo@bar.com", "INBOX")
+	_, err := collectStats(slowOnSubject("slow"), statsConfig{
+		"fast_count": &criteriaCfg{},
+		"slow_count": &criteriaCfg{
+			Headers: map[string]string{"Subject": "slow"},
+			Timeout: 10 * time.Millisecond,
+		},
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, saveReport(nil))
+
+	actual := readReport(t, path)
+	assert.Equal(t, true, actual["success"])
+	assert.Equal(t, []interface{}{"foo@bar.com"}, actual["accounts"])
+	assert.Equal(t, []interface{}{"INBOX"}, actual["mailboxes"])
+	assert.Equal(t, []interface{}{"slow_count: search timed out after 10ms"}, actual["warnings"])
+	assert.Empty(t, actual["errors"])
+
+	criteria := actual["criteria"].(map[string]interface{})
+	require.Contains(t, criteria, "foo@bar.com/INBOX/fast_count")
+	require.Contains(t, criteria, "foo@bar.com/INBOX/slow_count")
+	slow := criteria["foo@bar.com/INBOX/slow_count"].(map[string]interface{})
+	assert.GreaterOrEqual(t, slow["duration_seconds"].(float64), 0.01)
+}
+
+func Test_timingShouldKeepSameKeysOfMailboxesApart(t *testing.T) {
+	withReport(t, "")
+
+	report.timing("foo@bar.com", "INBOX", "boss_count", time.Second, nil)
+	report.timing("foo@bar.com", "Archive", "boss_count", 2*time.Second, errors.New("boom"))
+
+	assert.Equal(t, map[string]criteriaReport{
+		"foo@bar.com/INBOX/boss_count":   {Duration: 1},
+		"foo@bar.com/Archive/boss_count": {Duration: 2, Error: "boom"},
+	}, report.Criteria)
+}
+
+func Test_saveReportShouldRecordFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	withReport(t, path)
+
+	require.NoError(t, saveReport(errors.New("boom")))
+
+	actual := readReport(t, path)
+	assert.Equal(t, false, actual["success"])
+	assert.Equal(t, []interface{}{"boom"}, actual["errors"])
+}
+
+func Test_saveReportShouldDoNothingWithoutPath(t *testing.T) {
+	withReport(t, "")
+	assert.NoError(t, saveReport(nil))
+}
+
+func Test_strictErrShouldFailOnTruncationWarning(t *testing.T) {
+	withReport(t, "")
+	defer func(max int, policy string, strict bool) {
+		*maxOutputBytesArg, *maxOutputPolicyArg, *strictArg = max, policy, strict
+	}(*maxOutputBytesArg, *maxOutputPolicyArg, *strictArg)
+	*maxOutputBytesArg, *maxOutputPolicyArg = 30, outputTruncate
+
+	st := stats{
+		"foo_count":          1,
+		"foo_count_messages": []*letter{{Subject: "a long enough subject to truncate"}},
+	}
+	_, err := limitOutput(st)
+	require.NoError(t, err)
+
+	*strictArg = false
+	assert.NoError(t, report.strictErr())
+
+	*strictArg = true
+	assert.EqualError(t, report.strictErr(),
+		"-strict: 1 warning(s) reported, the first one: stats take 87 bytes which exceeds -max-output-bytes 30")
+}
+
+func Test_strictErrShouldPassWithoutWarnings(t *testing.T) {
+	withReport(t, "")
+	defer func() { *strictArg = false }()
+	*strictArg = true
+
+	assert.NoError(t, report.strictErr())
+}
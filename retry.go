@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// go-imap drops response codes from command errors, so rate limiting can
+// only be recognised by the text servers put into the response.
+var rateLimitMarkers = []string{
+	"too many simultaneous connections", // gmail
+	"exceeded command or bandwidth limits",
+	"throttl",
+	"rate limit",
+}
+
+// sleep is a variable to be replaced in tests
+var sleep = time.Sleep
+
+func isRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, marker := range rateLimitMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitBackoff doubles the base delay on each attempt and adds up to 50%
+// of random jitter so that parallel runs do not hit the server in lockstep.
+func rateLimitBackoff(attempt int) time.Duration {
+	d := *rateLimitDelayArg << uint(attempt)
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// withRateLimitRetry calls fn until it either succeeds, fails with an error
+// unrelated to rate limiting or -rate-limit-retries is exhausted.
+// Transient login failures are retried the same way; permanent ones are not.
+func withRateLimitRetry(fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		rateLimited := isRateLimited(err)
+		if !rateLimited && !isTransientLoginError(err) {
+			return err
+		}
+		if attempt >= *rateLimitRetriesArg {
+			if !rateLimited {
+				return fmt.Errorf("login is still failing after %d retries: %w", attempt, err)
+			}
+			return fmt.Errorf("server is still rate limiting after %d retries: %w", attempt, err)
+		}
+		d := rateLimitBackoff(attempt)
+		if rateLimited {
+			warnf("rate limited by server: %s; retrying in %s", err, d)
+		} else {
+			warnf("%s; retrying in %s", err, d)
+		}
+		sleep(d)
+	}
+}
+
+// isNetError tells whether err is a network failure like a refused connection, a timeout
+// or a connection dropped while logging in, as opposed to a rejected login
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		(err != nil && strings.HasPrefix(err.Error(), "imap: connection closed"))
+}
+
+// withNetRetry calls fn until it either succeeds, fails with an error other than
+// a network one or -retries is exhausted. The delay starts at -retry-delay and doubles.
+// The error of the last attempt is returned as is to keep its exit code.
+func withNetRetry(fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !isNetError(err) || attempt >= *retriesArg {
+			return err
+		}
+		d := *retryDelayArg << uint(attempt)
+		warnf("connection failed: %s; retrying in %s", err, d)
+		sleep(d)
+	}
+}
+
+const (
+	mailboxRetries    = 2
+	mailboxRetryDelay = time.Second
+)
+
+// transientMailboxPattern matches NO responses to SELECT or STATUS that may succeed on retry,
+// e.g. while the server rebuilds the mailbox index. Whole phrases are matched, so that
+// a failure merely mentioning e.g. an index is not retried.
+var transientMailboxPattern = regexp.MustCompile(`(?i)\b(try again later|temporar(y|ily)|being re-?indexed|re-?indexing|(mailbox|folder) (is )?(in use|busy|locked))\b`)
+
+// missingMailboxPattern matches NO responses telling the mailbox does not exist; these are never retried
+var missingMailboxPattern = regexp.MustCompile(`(?i)\b(no such (mailbox|folder)|(mailbox|folder) (does not|doesn't) exist|(nonexistent|unknown) (mailbox|folder)|(mailbox|folder) (could )?not (be )?found)\b`)
+
+func isTransientMailboxError(err error) bool {
+	if err == nil || isConnClosed(err) {
+		return false
+	}
+	msg := err.Error()
+	return !missingMailboxPattern.MatchString(msg) && transientMailboxPattern.MatchString(msg)
+}
+
+// withMailboxRetry retries SELECT or STATUS a few times on transient NO responses.
+// Unlike withRateLimitRetry it keeps the connection.
+func withMailboxRetry(name string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt >= mailboxRetries || !isTransientMailboxError(err) {
+			return err
+		}
+		warnf("%s: %s; retrying in %s", name, err, mailboxRetryDelay)
+		sleep(mailboxRetryDelay)
+	}
+}
+
+// selectMailbox selects a mailbox retrying on transient failures
+func selectMailbox(c imapClient, name string) (mbox *imap.MailboxStatus, err error) {
+	err = withMailboxRetry(name, func() (err error) {
+		mbox, err = c.Select(name, false)
+		return
+	})
+	return
+}
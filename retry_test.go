@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubSleep(t *testing.T) *[]time.Duration {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+	return &slept
+}
+
+func Test_isRateLimited(t *testing.T) {
+	var tests = []struct {
+		expected bool
+		given    error
+	}{
+		{false, nil},
+		{false, errors.New("Invalid credentials (Failure)")},
+		{true, errors.New("Too many simultaneous connections. (Failure)")},
+		{true, errors.New("Account exceeded command or bandwidth limits.")},
+		{true, errors.New("Request is throttled")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprint(tt.given), func(t *testing.T) {
+			assert.Equal(t, tt.expected, isRateLimited(tt.given))
+		})
+	}
+}
+
+func Test_withRateLimitRetryShouldBackOffOnRateLimit(t *testing.T) {
+	slept := stubSleep(t)
+	*rateLimitRetriesArg = 3
+	defer func() { *rateLimitRetriesArg = 0 }()
+
+	calls := 0
+	err := withRateLimitRetry(func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("Too many simultaneous connections. (Failure)")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, *slept, 2)
+	assert.GreaterOrEqual(t, int64((*slept)[0]), int64(*rateLimitDelayArg))
+	assert.GreaterOrEqual(t, int64((*slept)[1]), int64(2**rateLimitDelayArg))
+}
+
+func Test_withRateLimitRetryShouldNotRetryOtherErrors(t *testing.T) {
+	slept := stubSleep(t)
+	*rateLimitRetriesArg = 3
+	defer func() { *rateLimitRetriesArg = 0 }()
+
+	calls := 0
+	err := withRateLimitRetry(func() error {
+		calls++
+		return errors.New("Invalid credentials (Failure)")
+	})
+	assert.EqualError(t, err, "Invalid credentials (Failure)")
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, *slept)
+}
+
+func Test_withRateLimitRetryShouldGiveUpAfterRetries(t *testing.T) {
+	slept := stubSleep(t)
+	*rateLimitRetriesArg = 2
+	defer func() { *rateLimitRetriesArg = 0 }()
+
+	err := withRateLimitRetry(func() error {
+		return errors.New("Too many simultaneous connections. (Failure)")
+	})
+	assert.EqualError(t, err,
+		"server is still rate limiting after 2 retries: Too many simultaneous connections. (Failure)")
+	assert.Len(t, *slept, 2)
+}
+
+func Test_isTransientMailboxError(t *testing.T) {
+	var tests = []struct {
+		expected bool
+		given    error
+	}{
+		{false, nil},
+		{true, errors.New("Mailbox is being reindexed, try again later")},
+		{true, errors.New("Mailbox in use")},
+		{true, errors.New("Temporary failure, please retry")},
+		{false, errors.New("No such mailbox, try again later")},
+		{false, errors.New("Mailbox doesn't exist: Archiv")},
+		{false, errors.New("Invalid mailbox name: index")},
+		{false, errors.New("Server is busybox")},
+		{false, errors.New("Permission denied, try again with another user")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprint(tt.given), func(t *testing.T) {
+			assert.Equal(t, tt.expected, isTransientMailboxError(tt.given))
+		})
+	}
+}
+
+func Test_selectMailboxShouldRetryTransientFailures(t *testing.T) {
+	slept := stubSleep(t)
+	withReport(t, "")
+	underTest := &fakeClient{selectErrs: []error{errors.New("Mailbox is being reindexed, try again later")}}
+
+	mbox, err := selectMailbox(underTest, "Archive")
+	require.NoError(t, err)
+	assert.Equal(t, "Archive", mbox.Name)
+	assert.Equal(t, []string{"Archive", "Archive"}, underTest.selected)
+	assert.Equal(t, []time.Duration{mailboxRetryDelay}, *slept)
+}
+
+func Test_selectMailboxShouldNotRetryMissingMailbox(t *testing.T) {
+	slept := stubSleep(t)
+	underTest := &fakeClient{selectErrs: []error{errors.New("No such mailbox, try again later")}}
+
+	_, err := selectMailbox(underTest, "Archiv")
+	assert.EqualError(t, err, "No such mailbox, try again later")
+	assert.Len(t, underTest.selected, 1)
+	assert.Empty(t, *slept)
+}
+
+func Test_selectMailboxShouldGiveUpAfterRetries(t *testing.T) {
+	stubSleep(t)
+	withReport(t, "")
+	busy := errors.New("Mailbox in use")
+	underTest := &fakeClient{selectErrs: []error{busy, busy, busy, busy}}
+
+	_, err := selectMailbox(underTest, "INBOX")
+	assert.Equal(t, busy, err)
+	assert.Len(t, underTest.selected, mailboxRetries+1)
+}
+
+func Test_withNetRetryShouldRetryNetworkErrorsOnly(t *testing.T) {
+	slept := stubSleep(t)
+	withReport(t, "")
+	*retriesArg, *retryDelayArg = 2, time.Second
+	defer func() { *retriesArg = 0 }()
+
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	calls := 0
+	err := withNetRetry(func() error {
+		calls++
+		return netErr
+	})
+	assert.Equal(t, netErr, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *slept)
+
+	calls = 0
+	err = withNetRetry(func() error {
+		calls++
+		if calls == 1 {
+			return io.EOF
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = withNetRetry(func() error {
+		calls++
+		return classifyLoginError(errors.New("Invalid credentials (Failure)"))
+	})
+	assert.EqualError(t, err, "login failed: Invalid credentials (Failure)")
+	assert.Equal(t, 1, calls)
+}
+
+func Test_classifyLoginErrorShouldKeepNetworkErrors(t *testing.T) {
+	err := errors.New("imap: connection closed")
+	assert.Equal(t, err, classifyLoginError(err))
+	_, isLoginErr := loginExitCode(classifyLoginError(err))
+	assert.False(t, isLoginErr)
+}
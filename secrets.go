@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readSecrets reads a YAML or JSON file mapping accounts to their passwords.
+// The file must not be accessible by group or others.
+func readSecrets(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("secrets file %s must not be accessible by group or others: mode %#o", path, perm)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secrets := map[string]string{}
+	if err := yaml.Unmarshal(b, &secrets); err != nil {
+		return nil, fmt.Errorf("secrets file %s: %w", path, err)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withSecrets(t *testing.T, content string, perm uint32) string {
+	path := filepath.Join(t.TempDir(), "secrets")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	require.NoError(t, os.Chmod(path, os.FileMode(perm)))
+	*secretsArg = path
+	t.Cleanup(func() { *secretsArg = "" })
+	return path
+}
+
+func Test_readPasswordShouldSelectPerAccount(t *testing.T) {
+	var tests = []struct {
+		name    string
+		content string
+	}{
+		{"yaml", "foo@bar.com: secret1\nbaz@bar.com: \"s3cr:t\"\n"},
+		{"json", `{"foo@bar.com": "secret1", "baz@bar.com": "s3cr:t"}`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			path := withSecrets(t, tt.content, 0600)
+
+			actual, err := readPassword("foo@bar.com")
+			require.NoError(t, err)
+			assert.Equal(t, "secret1", actual)
+
+			actual, err = readPassword("baz@bar.com")
+			require.NoError(t, err)
+			assert.Equal(t, "s3cr:t", actual)
+
+			_, err = readPassword("nobody@bar.com")
+			assert.EqualError(t, err, "no password for nobody@bar.com in "+path)
+		})
+	}
+}
+
+func Test_readSecretsShouldRejectOpenPermissions(t *testing.T) {
+	path := withSecrets(t, "foo@bar.com: secret1\n", 0644)
+
+	_, err := readPassword("foo@bar.com")
+	assert.EqualError(t, err, "secrets file "+path+" must not be accessible by group or others: mode 0644")
+}
+
+func Test_readPasswordShouldPreferEnvOverFiles(t *testing.T) {
+	defer func(l map[string]loginCfg) { logins = l }(logins)
+	withSecrets(t, "foo@bar.com: secret1\nbaz@bar.com: secret2\n", 0600)
+	require.NoError(t, os.Setenv("IMAPSTATS_TEST_PASS", " s3cret\n"))
+	defer os.Unsetenv("IMAPSTATS_TEST_PASS")
+	*passEnvArg = "IMAPSTATS_TEST_PASS"
+	defer func() { *passEnvArg = "" }()
+	logins = map[string]loginCfg{"baz@bar.com": {PassEnv: "IMAPSTATS_TEST_NO_PASS"}}
+
+	actual, err := readPassword("foo@bar.com")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", actual)
+
+	_, err = readPassword("baz@bar.com")
+	assert.EqualError(t, err, "no password in environment variable IMAPSTATS_TEST_NO_PASS")
+}
+
+func Test_readPasswordShouldRunPassCommand(t *testing.T) {
+	defer func() { *passCommandArg = "" }()
+	withSecrets(t, "foo@bar.com: secret1\n", 0600)
+
+	*passCommandArg = "echo ' s3cret '"
+	actual, err := readPassword("foo@bar.com")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", actual)
+
+	*passCommandArg = "exit 3"
+	_, err = readPassword("foo@bar.com")
+	assert.EqualError(t, err, `pass command "exit 3": exit code 3`)
+
+	*passCommandArg = "true"
+	_, err = readPassword("foo@bar.com")
+	assert.EqualError(t, err, `pass command "true": empty output`)
+}
+
+func Test_readPasswordShouldRunPassCommandOncePerAccount(t *testing.T) {
+	defer func() { *passCommandArg = "" }()
+	runs := filepath.Join(t.TempDir(), "runs")
+	*passCommandArg = "echo run >> " + runs + "; echo s3cret"
+
+	for _, account := range []string{"foo@bar.com", "foo@bar.com", "baz@bar.com", "foo@bar.com"} {
+		actual, err := readPassword(account)
+		require.NoError(t, err)
+		assert.Equal(t, "s3cret", actual)
+	}
+
+	b, err := ioutil.ReadFile(runs)
+	require.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(b))
+}
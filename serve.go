@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// shutdownTimeout bounds waiting for requests in progress once -serve is stopped
+const shutdownTimeout = 10 * time.Second
+
+func validateServe() error {
+	if *serveArg == "" {
+		return nil
+	}
+	if *daemonArg {
+		return errors.New("-serve and -daemon are mutually exclusive")
+	}
+	if *ttlArg == "" {
+		return errors.New("-serve needs -ttl to tell when cached stats are stale")
+	}
+	if *formatArg != formatJSON || *templateArg != "" {
+		return errors.New("-serve caches stats as JSON: -format must be json without -template")
+	}
+	return nil
+}
+
+// statsServer serves stats over HTTP from the cache while it is fresher than -ttl
+// and fetches them otherwise. Fetches are serialized.
+type statsServer struct {
+	fetch func() (stats, error)
+
+	// mu is held while stats are read or fetched
+	mu sync.Mutex
+
+	// healthMu guards the outcome of fetches apart from mu, so that /healthz
+	// answers during fetches. fetched tells whether stats were fetched at all,
+	// lastErr is the error of the last fetch.
+	healthMu sync.Mutex
+	fetched  bool
+	lastErr  error
+}
+
+func (s *statsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", s.serveStats)
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/healthz", s.serveHealth)
+	return mux
+}
+
+// current returns cached stats if they are fresh, otherwise fetches them
+func (s *statsServer) current() (stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cached bytes.Buffer
+	if err := copyCache(&cached, cacheTTL(), true); err == nil {
+		var st stats
+		if err := json.NewDecoder(&cached).Decode(&st); err == nil {
+			return st, nil
+		}
+	}
+	return s.refresh()
+}
+
+// refresh fetches stats and writes them like a single run with -write-cache -q
+func (s *statsServer) refresh() (stats, error) {
+	report = newRunReport()
+	st, err := s.fetch()
+	if saveErr := saveReport(err); saveErr != nil {
+		log.Printf("failed to save report: %s", saveErr)
+	}
+	if err == nil {
+		err = writeOutputs(st)
+	}
+	s.healthMu.Lock()
+	s.fetched, s.lastErr = true, err
+	s.healthMu.Unlock()
+	if err != nil {
+		log.Printf("failed to fetch stats: %s", err)
+		return nil, err
+	}
+	return withoutCacheOnly(st), nil
+}
+
+func (s *statsServer) serveStats(w http.ResponseWriter, r *http.Request) {
+	st, err := s.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func (s *statsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	st, err := s.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheus(w, st)
+}
+
+// serveHealth responds with 200 only if the last fetch succeeded
+func (s *statsServer) serveHealth(w http.ResponseWriter, r *http.Request) {
+	s.healthMu.Lock()
+	fetched, err := s.fetched, s.lastErr
+	s.healthMu.Unlock()
+	switch {
+	case !fetched:
+		http.Error(w, "no stats fetched yet", http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// runServer serves stats at addr until SIGINT or SIGTERM. Stats are fetched once at start,
+// so that /healthz tells whether the server can be reached, and connections are kept
+// logged in between fetches like with -daemon. The address is bound before that fetch,
+// so that a taken one fails at once.
+func runServer(cfg *config, filter *statsFilter, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	origWrite, origQuiet := *writeCacheArg, *quietArg
+	*writeCacheArg, *quietArg = true, true
+	keptConns = map[string]*client.Client{}
+	defer func() {
+		for _, c := range keptConns {
+			c.Logout()
+		}
+		keptConns, *writeCacheArg, *quietArg = nil, origWrite, origQuiet
+	}()
+
+	s := &statsServer{fetch: func() (stats, error) { return fetchPostprocessed(cfg, filter) }}
+	s.mu.Lock()
+	s.refresh()
+	s.mu.Unlock()
+
+	srv := &http.Server{Handler: s.handler()}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		sig := <-stop
+		log.Printf("%s received, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	log.Printf("serving stats at %s", addr)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	// requests in progress may still use kept connections
+	<-shutdown
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withStatsServer serves stats fetched by fetch like -serve -ttl ttl does
+func withStatsServer(t *testing.T, ttl string, fetch func() (stats, error)) *httptest.Server {
+	withTempCacheDir(t)
+	origUser, origMbox, origTTL, origWrite, origQuiet := *userArg, *mboxArg, *ttlArg, *writeCacheArg, *quietArg
+	*userArg, *mboxArg, *ttlArg, *writeCacheArg, *quietArg = "foo@bar.com", "INBOX", ttl, true, true
+	t.Cleanup(func() {
+		*userArg, *mboxArg, *ttlArg, *writeCacheArg, *quietArg = origUser, origMbox, origTTL, origWrite, origQuiet
+	})
+	srv := httptest.NewServer((&statsServer{fetch: fetch}).handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(b)
+}
+
+func Test_statsServerShouldServeFreshStatsFromCache(t *testing.T) {
+	fetches := 0
+	srv := withStatsServer(t, "1h", func() (stats, error) {
+		fetches++
+		return stats{"unseen_count": 3}, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		code, body := get(t, srv.URL+"/stats")
+		assert.Equal(t, http.StatusOK, code)
+		assert.JSONEq(t, `{"unseen_count":3}`, body)
+	}
+	code, body := get(t, srv.URL+"/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `imapstats_unseen_count{account="foo@bar.com",mailbox="INBOX"} 3`)
+
+	assert.Equal(t, 1, fetches)
+	assert.FileExists(t, cacheFilename())
+}
+
+func Test_statsServerShouldFetchStaleStats(t *testing.T) {
+	fetches := 0
+	srv := withStatsServer(t, "0", func() (stats, error) {
+		fetches++
+		return stats{"unseen_count": fetches}, nil
+	})
+
+	get(t, srv.URL+"/stats")
+	_, body := get(t, srv.URL+"/stats")
+
+	assert.JSONEq(t, `{"unseen_count":2}`, body)
+}
+
+func Test_statsServerHealthShouldTellWhetherLastFetchSucceeded(t *testing.T) {
+	var fetchErr error
+	srv := withStatsServer(t, "0", func() (stats, error) {
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return stats{"unseen_count": 1}, nil
+	})
+
+	code, _ := get(t, srv.URL+"/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	get(t, srv.URL+"/stats")
+	code, _ = get(t, srv.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	fetchErr = errors.New("boom")
+	code, body := get(t, srv.URL+"/stats")
+	assert.Equal(t, http.StatusBadGateway, code)
+	assert.Contains(t, body, "boom")
+	code, _ = get(t, srv.URL+"/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func Test_validateServe(t *testing.T) {
+	defer func(serve, ttl, format string, daemon bool) {
+		*serveArg, *ttlArg, *formatArg, *daemonArg = serve, ttl, format, daemon
+	}(*serveArg, *ttlArg, *formatArg, *daemonArg)
+	*serveArg, *ttlArg, *formatArg, *daemonArg = ":8080", "5m", formatJSON, false
+
+	assert.NoError(t, validateServe())
+
+	*formatArg = formatText
+	assert.Error(t, validateServe())
+
+	*formatArg, *ttlArg = formatJSON, ""
+	assert.Error(t, validateServe())
+}
+
+func Test_statsServerHealthShouldAnswerDuringFetch(t *testing.T) {
+	started, done := make(chan struct{}), make(chan struct{})
+	srv := withStatsServer(t, "0", func() (stats, error) {
+		close(started)
+		<-done
+		return stats{"unseen_count": 1}, nil
+	})
+	fetched := make(chan struct{})
+	go func() {
+		defer close(fetched)
+		get(t, srv.URL+"/stats")
+	}()
+	<-started
+
+	code, body := get(t, srv.URL+"/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "no stats fetched yet")
+
+	close(done)
+	<-fetched
+	code, _ = get(t, srv.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func Test_runServerShouldFailOnTakenAddressBeforeFetching(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	assert.Error(t, runServer(&config{}, nil, l.Addr().String()))
+	assert.Nil(t, keptConns)
+}
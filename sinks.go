@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// execStats pipes encoded stats to stdin of a shell command
+func execStats(command string, st stats) error {
+	var buf bytes.Buffer
+	if err := encodeStats(&buf, st); err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = &buf
+	// stdout is reserved for stats
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("exec %q: exit code %d", command, exitErr.ExitCode())
+	}
+	return err
+}
+
+// graphitePrefix is the root of metric paths sent to Graphite
+const graphitePrefix = appName
+
+var graphiteUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// graphiteLines renders numeric stats in Graphite plaintext protocol
+// as <prefix>.<account>.<mailbox>.<key> <value> <timestamp> lines
+func graphiteLines(account string, mailbox string, st stats, ts int64) []string {
+	flat := flatten(st)
+	lines := make([]string, 0, len(flat))
+	for k, v := range flat {
+		parts := []string{graphitePrefix, account, mailbox}
+		parts = append(parts, strings.Split(k, ".")...)
+		for i := range parts {
+			parts[i] = graphiteUnsafe.ReplaceAllString(parts[i], "_")
+		}
+		lines = append(lines, fmt.Sprintf("%s %v %d\n", strings.Join(parts, "."), v, ts))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// sendGraphite writes numeric stats to a Graphite server over TCP
+func sendGraphite(addr string, st stats) error {
+	conn, err := net.DialTimeout("tcp", addr, imapTimeout())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var lines []string
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		lines = append(lines, graphiteLines(account, mailbox, st, now().Unix())...)
+	})
+	for _, line := range lines {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return fmt.Errorf("graphite %s: %w", addr, err)
+		}
+	}
+	return conn.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_execStatsShouldPipeStatsToCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "received.json")
+
+	err := execStats("cat > "+out, stats{"unseen_count": 3})
+	require.NoError(t, err)
+
+	actual, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"unseen_count": 3}`, string(actual))
+}
+
+func Test_execStatsShouldReportExitCode(t *testing.T) {
+	err := execStats("cat > /dev/null; exit 3", stats{"unseen_count": 3})
+	assert.EqualError(t, err, `exec "cat > /dev/null; exit 3": exit code 3`)
+}
+
+func Test_sendGraphiteShouldWriteLines(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+	now = func() time.Time { return time.Unix(1623337200, 0) }
+	defer func() { now = time.Now }()
+	*userArg, *mboxArg = "foo@bar.com", "[Gmail]/All Mail"
+	defer func() { *userArg, *mboxArg = "", "INBOX" }()
+
+	err = sendGraphite(l.Addr().String(), stats{
+		"unseen_count":        3,
+		"boss_count":          &fetchedStat{Count: 1},
+		"boss_count_messages": []*letter{{Subject: "foo"}},
+		"unread ratio":        0.25,
+		metaKey:               map[string]interface{}{"uidvalidity": uint32(42)},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"imapstats.foo_bar_com._Gmail_All_Mail._meta.uidvalidity 42 1623337200",
+		"imapstats.foo_bar_com._Gmail_All_Mail.boss_count 1 1623337200",
+		"imapstats.foo_bar_com._Gmail_All_Mail.unread_ratio 0.25 1623337200",
+		"imapstats.foo_bar_com._Gmail_All_Mail.unseen_count 3 1623337200",
+	}, <-received)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are multipliers of size suffixes, case-insensitive
+var sizeUnits = map[string]uint64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+}
+
+// parseSize parses message sizes like 5M or 200k into bytes; units are powers of 1024
+func parseSize(s string) (uint32, error) {
+	num := strings.TrimRight(s, "bBkKmMgG")
+	unit, found := sizeUnits[strings.ToLower(s[len(num):])]
+	n, err := strconv.ParseUint(num, 10, 32)
+	if !found || err != nil {
+		return 0, fmt.Errorf("bad size %q: want a number of bytes with optional k, M or G suffix", s)
+	}
+	if n*unit > math.MaxUint32 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return uint32(n * unit), nil
+}
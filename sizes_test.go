@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSize(t *testing.T) {
+	var tests = []struct {
+		given    string
+		expected uint32
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512b", 512},
+		{"200k", 200 << 10},
+		{"5M", 5 << 20},
+		{"2g", 2 << 30},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.given, func(t *testing.T) {
+			actual, err := parseSize(tt.given)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+	for _, bad := range []string{"", "M", "5X", "-1k", "1.5M", "5MB", "4G"} {
+		_, err := parseSize(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func Test_criteriaCfgToIMAPShouldSetSizes(t *testing.T) {
+	actual := (&criteriaCfg{LargerThan: "5M", SmallerThan: "10M"}).toIMAP()
+	assert.Equal(t, uint32(5<<20), actual.Larger)
+	assert.Equal(t, uint32(10<<20), actual.Smaller)
+
+	actual = (&criteriaCfg{LargerThan: "0"}).toIMAP()
+	assert.Zero(t, actual.Larger)
+	assert.Zero(t, actual.Smaller)
+}
+
+func Test_configValidateShouldNameCriteriaWithBadSize(t *testing.T) {
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		"foo@bar.com": {"INBOX": {"big_count": &criteriaCfg{LargerThan: "5 megs"}}},
+	}}
+	assert.EqualError(t, cfg.validate(), `bad config: accounts.foo@bar.com.INBOX.big_count: `+
+		`larger_than: bad size "5 megs": want a number of bytes with optional k, M or G suffix`)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+const sortCap = "SORT"
+
+// searchNewest is like searchIDs but returns ids of the newest messages first using SORT,
+// see RFC 5256, where supported. sorted tells whether ids are sorted. arrival sorts by
+// the date the server received messages instead of their Date header.
+func searchNewest(c imapClient, sc *imap.SearchCriteria, keys []interface{}, arrival bool) (ids []uint32, sorted bool, err error) {
+	rc, ok := c.(rawCommander)
+	if !ok {
+		ids, err = c.Search(sc)
+		return ids, false, err
+	}
+	supported, err := rc.Support(sortCap)
+	if err != nil {
+		return nil, false, err
+	}
+	if !supported {
+		ids, err = searchIDs(c, sc, keys)
+		return ids, false, err
+	}
+	key := "DATE"
+	if arrival {
+		key = "ARRIVAL"
+	}
+	h := &sortHandler{}
+	if err := executeSearch(rc, &sortCommand{searchCommand{criteria: sc, keys: keys}, key}, h); err != nil {
+		return nil, false, err
+	}
+	return h.ids, true, nil
+}
+
+// sortCommand is a SORT command of messages matching a search newest first by key
+type sortCommand struct {
+	searchCommand
+	key string
+}
+
+func (cmd *sortCommand) Command() *imap.Command {
+	args := []interface{}{
+		[]interface{}{imap.RawString("REVERSE"), imap.RawString(cmd.key)},
+		imap.RawString(cmd.charset),
+	}
+	args = append(args, cmd.criteria.Format()...)
+	args = append(args, cmd.keys...)
+	return &imap.Command{Name: sortCap, Arguments: args}
+}
+
+// sortHandler reads ids of SORT responses in the order the server sorted them
+type sortHandler struct {
+	ids []uint32
+}
+
+func (h *sortHandler) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != sortCap {
+		return responses.ErrUnhandled
+	}
+	for _, f := range fields {
+		id, err := imap.ParseNumber(f)
+		if err != nil {
+			return err
+		}
+		h.ids = append(h.ids, id)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_searchNewestShouldSortOnCapableServers(t *testing.T) {
+	underTest := &rawFakeClient{caps: map[string]bool{sortCap: true}, fields: []interface{}{"SORT", "5", "1", "3"}}
+	sc := &imap.SearchCriteria{WithoutFlags: []string{imap.SeenFlag}}
+
+	ids, sorted, err := searchNewest(underTest, sc, nil, false)
+	require.NoError(t, err)
+	assert.True(t, sorted)
+	assert.Equal(t, []uint32{5, 1, 3}, ids)
+
+	_, _, err = searchNewest(underTest, sc, nil, true)
+	require.NoError(t, err)
+	require.Len(t, underTest.commands, 2)
+	assert.Equal(t, "SORT", underTest.commands[0].Name)
+	assert.Equal(t, []interface{}{
+		[]interface{}{imap.RawString("REVERSE"), imap.RawString("DATE")}, imap.RawString("UTF-8"),
+		imap.RawString("UNSEEN"),
+	}, underTest.commands[0].Arguments)
+	assert.Equal(t, []interface{}{imap.RawString("REVERSE"), imap.RawString("ARRIVAL")},
+		underTest.commands[1].Arguments[0])
+}
+
+func Test_searchNewestShouldSearchWithoutSort(t *testing.T) {
+	underTest := &rawFakeClient{caps: map[string]bool{}}
+
+	ids, sorted, err := searchNewest(underTest, &imap.SearchCriteria{}, []interface{}{imap.RawString("YOUNGER"), uint32(60)}, false)
+	require.NoError(t, err)
+	assert.False(t, sorted)
+	assert.Equal(t, []uint32{1, 2}, ids)
+	require.Len(t, underTest.commands, 1)
+	assert.Equal(t, "SEARCH", underTest.commands[0].Name)
+}
+
+func Test_sortHandlerShouldFailOnBadIDs(t *testing.T) {
+	underTest := &sortHandler{}
+
+	assert.Error(t, underTest.Handle(searchResp("SORT", "1", "x")))
+}
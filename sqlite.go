@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	// registers sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS stats (
+	ts      INTEGER NOT NULL,
+	account TEXT    NOT NULL,
+	mailbox TEXT    NOT NULL,
+	key     TEXT    NOT NULL,
+	value   INTEGER NOT NULL
+)`
+
+// openStatsDB opens or creates an SQLite database to keep stats history in
+func openStatsDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows only one writer anyway; this also keeps :memory: databases
+	// from being different on each pooled connection
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// insertStats stores numeric stats of a run as one row per stat
+func insertStats(db *sql.DB, ts time.Time, account string, mailbox string, st stats) error {
+	flat := flatten(st)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		if k == metaKey || strings.HasPrefix(k, metaKey+".") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		_, err := tx.Exec("INSERT INTO stats (ts, account, mailbox, key, value) VALUES (?, ?, ?, ?, ?)",
+			ts.Unix(), account, mailbox, k, flat[k])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func writeStatsDB(path string, st stats) error {
+	db, err := openStatsDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return insertStats(db, now(), *userArg, *mboxArg, st)
+}
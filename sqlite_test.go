@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_insertStats(t *testing.T) {
+	db, err := openStatsDB(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	ts := time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC)
+	err = insertStats(db, ts, "foo@bar.com", "INBOX", stats{
+		"unseen_count":       3,
+		"foo_count":          &fetchedStat{Count: 1},
+		"foo_count_messages": []*letter{{Subject: "foo"}},
+		metaKey:              map[string]interface{}{"uidvalidity": uint32(42)},
+	})
+	require.NoError(t, err)
+
+	rows, err := db.Query("SELECT ts, account, mailbox, key, value FROM stats ORDER BY key")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	type row struct {
+		ts      int64
+		account string
+		mailbox string
+		key     string
+		value   int
+	}
+	var actual []row
+	for rows.Next() {
+		var r row
+		require.NoError(t, rows.Scan(&r.ts, &r.account, &r.mailbox, &r.key, &r.value))
+		actual = append(actual, r)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []row{
+		{ts.Unix(), "foo@bar.com", "INBOX", "foo_count", 1},
+		{ts.Unix(), "foo@bar.com", "INBOX", "unseen_count", 3},
+	}, actual)
+}
@@ -0,0 +1,76 @@
+package main
+
+const summariesKey = "_summaries"
+
+// summarySelector picks a stat of a given account and mailbox.
+// Empty account or mailbox matches any.
+type summarySelector struct {
+	Account string `yaml:"account"`
+	Mailbox string `yaml:"mailbox"`
+	Key     string `yaml:"key"`
+}
+
+func (s *summarySelector) matches(account string, mailbox string) bool {
+	return (s.Account == "" || s.Account == account) &&
+		(s.Mailbox == "" || s.Mailbox == mailbox)
+}
+
+// results holds stats of all fetched mailboxes by account and mailbox
+type results map[string]map[string]stats
+
+// add adds stats of an account as fetchStats collects them, nested under
+// mailbox names if several mailboxes are collected
+func (res results) add(account string, st stats) {
+	mboxes := map[string]stats{}
+	if !multiMailbox() {
+		mboxes[*mboxArg] = st
+	}
+	for _, name := range mailboxNames() {
+		if nested, ok := st[name].(stats); ok && multiMailbox() {
+			mboxes[name] = nested
+		}
+	}
+	res[account] = mboxes
+}
+
+// addSummaries puts configured summaries of all collected stats under _summaries
+// of the stats written by a run, next to accounts or mailboxes they sum up
+func (c *config) addSummaries(st stats, res results) {
+	if len(c.Summaries) > 0 {
+		st[summariesKey] = c.summarize(res)
+	}
+}
+
+// count extracts a numeric count from a stat value
+func count(v interface{}) (int, bool) {
+	switch val := v.(type) {
+	case int:
+		return val, true
+	case *fetchedStat:
+		return val.Count, true
+	}
+	return 0, false
+}
+
+// summarize sums up stats picked by selectors of each configured summary
+func (c *config) summarize(res results) map[string]int {
+	sums := map[string]int{}
+	for name, selectors := range c.Summaries {
+		sum := 0
+		for _, sel := range selectors {
+			for account, mboxes := range res {
+				for mailbox, st := range mboxes {
+					if !sel.matches(account, mailbox) {
+						continue
+					}
+					v, _ := rawStat(st, sel.Key)
+					if n, ok := count(v); ok {
+						sum += n
+					}
+				}
+			}
+		}
+		sums[name] = sum
+	}
+	return sums
+}
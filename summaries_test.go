@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configSummarize(t *testing.T) {
+	cfg := &config{
+		Summaries: map[string][]summarySelector{
+			"total_unseen": {
+				{Key: "unseen_count"},
+			},
+			"important": {
+				{Account: "foo@bar.com", Mailbox: "INBOX", Key: "boss_count"},
+				{Account: "baz@bar.com", Key: "important_count"},
+			},
+			"missing": {
+				{Key: "no_such_count"},
+			},
+		},
+	}
+	given := results{
+		"foo@bar.com": {
+			"INBOX": stats{"unseen_count": 3, "boss_count": 1},
+			"Work":  stats{"unseen_count": 2, "boss_count": 10},
+		},
+		"baz@bar.com": {
+			"INBOX": stats{
+				"unseen_count":    5,
+				"important_count": &fetchedStat{Count: 4},
+			},
+		},
+	}
+
+	expected := map[string]int{
+		"total_unseen": 10,
+		"important":    5,
+		"missing":      0,
+	}
+	assert.Equal(t, expected, cfg.summarize(given))
+}
+
+func Test_fetchAccountsShouldSummarizeAllAccountsAndMailboxes(t *testing.T) {
+	withTempCacheDir(t)
+	withReport(t, "")
+	defer func(user, maildir, mbox string) {
+		*userArg, *maildirArg, *mboxArg = user, maildir, mbox
+	}(*userArg, *maildirArg, *mboxArg)
+	*userArg, *maildirArg, *mboxArg = "", "testdata/maildir", "INBOX,Archive"
+
+	boss := &criteriaCfg{AnySeen: true, Headers: map[string]string{"From": "boss@corp.com"}}
+	cfg := &config{
+		Accounts: map[string]map[string]statsConfig{
+			"foo@bar.com": {
+				"INBOX":   {"flagged_count": &criteriaCfg{WithFlags: []string{imap.FlaggedFlag}}},
+				"Archive": {"boss_count": boss},
+			},
+			"baz@bar.com": {
+				"INBOX": {"boss_count": boss},
+			},
+		},
+		Summaries: map[string][]summarySelector{
+			"total_unseen": {{Key: "unseen_count"}},
+			"total_boss":   {{Key: "boss_count"}},
+			"foo_flagged":  {{Account: "foo@bar.com", Key: "flagged_count"}},
+		},
+	}
+	filter, err := parseFilter("*_count>100")
+	require.NoError(t, err)
+
+	st, err := fetchAccounts(cfg, filter, fetchStats)
+	require.NoError(t, err)
+
+	// summaries are taken before filtering
+	assert.Equal(t, map[string]int{
+		"total_unseen": 2 + 0 + 2 + 0,
+		"total_boss":   1 + 2,
+		"foo_flagged":  1,
+	}, st[summariesKey])
+	assert.Equal(t, stats{"INBOX": stats{}, "Archive": stats{}}, withoutCacheOnly(st)["foo@bar.com"])
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateParam matches ${name} parameters in template values
+var templateParam = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expandTemplates replaces criteria referencing a template with the template
+// instantiated with the given args
+func (c *config) expandTemplates() error {
+	accounts := make([]string, 0, len(c.Accounts))
+	for acc := range c.Accounts {
+		accounts = append(accounts, acc)
+	}
+	sort.Strings(accounts)
+	for _, acc := range accounts {
+		mboxes := make([]string, 0, len(c.Accounts[acc]))
+		for mbox := range c.Accounts[acc] {
+			mboxes = append(mboxes, mbox)
+		}
+		sort.Strings(mboxes)
+		for _, mbox := range mboxes {
+			cfg := c.Accounts[acc][mbox]
+			for _, key := range sortedKeys(cfg) {
+				if cfg[key] == nil || cfg[key].Use == "" {
+					continue
+				}
+				expanded, err := c.instantiate(cfg[key])
+				if err != nil {
+					return fmt.Errorf("%w: accounts.%s.%s.%s: %s", errBadConfig, acc, mbox, key, err)
+				}
+				cfg[key] = expanded
+			}
+		}
+	}
+	return nil
+}
+
+func (c *config) instantiate(cr *criteriaCfg) (*criteriaCfg, error) {
+	if !reflect.DeepEqual(cr, &criteriaCfg{Use: cr.Use, Args: cr.Args}) {
+		return nil, fmt.Errorf("use can not be combined with other criteria")
+	}
+	tmpl, found := c.Templates[cr.Use]
+	if !found {
+		return nil, fmt.Errorf("unknown template %q", cr.Use)
+	}
+	node, err := substituteArgs(&tmpl, cr.Args)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", cr.Use, err)
+	}
+	var res criteriaCfg
+	if err := node.Decode(&res); err != nil {
+		return nil, fmt.Errorf("template %q: %w", cr.Use, err)
+	}
+	if res.Use != "" {
+		return nil, fmt.Errorf("template %q: templates can not use other templates", cr.Use)
+	}
+	return &res, nil
+}
+
+// substituteArgs returns a copy of n with ${name} parameters replaced by args
+func substituteArgs(n *yaml.Node, args map[string]string) (*yaml.Node, error) {
+	res := *n
+	if n.Kind == yaml.ScalarNode {
+		var missing string
+		res.Value = templateParam.ReplaceAllStringFunc(n.Value, func(p string) string {
+			name := templateParam.FindStringSubmatch(p)[1]
+			v, found := args[name]
+			if !found && missing == "" {
+				missing = name
+			}
+			return v
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("missing arg %q", missing)
+		}
+		if res.Value != n.Value && n.Style == 0 {
+			// let plain scalars be resolved again, e.g. "${seen}" to a bool
+			res.Tag = ""
+		}
+		return &res, nil
+	}
+	res.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c, err := substituteArgs(child, args)
+		if err != nil {
+			return nil, err
+		}
+		res.Content[i] = c
+	}
+	return &res, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const templatesConfig = `
+templates:
+  from_sender:
+    headers:
+      From: ${sender}
+    body:
+      - ${word}
+      - literal
+    any_seen: ${any}
+accounts:
+  foo@bar.com:
+    INBOX:
+      from_boss:
+        use: from_sender
+        args:
+          sender: boss@corp.com
+          word: urgent
+          any: "true"
+`
+
+func Test_expandTemplatesShouldInstantiateTemplate(t *testing.T) {
+	var cfg config
+	require.NoError(t, yaml.Unmarshal([]byte(templatesConfig), &cfg))
+
+	require.NoError(t, cfg.expandTemplates())
+
+	expected := &criteriaCfg{
+		Headers: map[string]string{"From": "boss@corp.com"},
+		Body:    []string{"urgent", "literal"},
+		AnySeen: true,
+	}
+	assert.Equal(t, expected, cfg.Accounts["foo@bar.com"]["INBOX"]["from_boss"])
+	assert.Empty(t, cfg.lint())
+}
+
+func Test_expandTemplatesShouldFail(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+		given    *criteriaCfg
+	}{
+		{"unknown template",
+			`bad config: accounts.foo@bar.com.INBOX.k: unknown template "nope"`,
+			&criteriaCfg{Use: "nope"}},
+		{"missing arg",
+			`bad config: accounts.foo@bar.com.INBOX.k: template "from_sender": missing arg "word"`,
+			&criteriaCfg{Use: "from_sender", Args: map[string]string{"sender": "a", "any": "true"}}},
+		{"combined with criteria",
+			`bad config: accounts.foo@bar.com.INBOX.k: use can not be combined with other criteria`,
+			&criteriaCfg{Use: "from_sender", Fetch: true}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			require.NoError(t, yaml.Unmarshal([]byte(templatesConfig), &cfg))
+			cfg.Accounts["foo@bar.com"]["INBOX"] = statsConfig{"k": tt.given}
+
+			assert.EqualError(t, cfg.expandTemplates(), tt.expected)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	formatText = "text"
+
+	messagesSuffix = "_messages"
+)
+
+// writeText writes numeric stats as key value lines sorted by key for shell scripts
+// and status bars. Fetched messages are listed indented under the key of their count.
+func writeText(w io.Writer, st stats) error {
+	flat := flatten(st)
+	messages := map[string][]*letter{}
+	textMessagesInto(messages, "", st)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s\n", k, prometheusValue(flat[k]))
+		for _, l := range messages[k] {
+			fmt.Fprintf(&b, "  %s\n", textLetter(l))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// textMessagesInto collects fetched messages by the flattened key of their count
+func textMessagesInto(res map[string][]*letter, key string, v interface{}) {
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + "." + k
+	}
+	switch val := v.(type) {
+	case stats:
+		for k, it := range val {
+			textMessagesInto(res, join(k), it)
+		}
+	case map[string]interface{}:
+		for k, it := range val {
+			textMessagesInto(res, join(k), it)
+		}
+	case *fetchedStat:
+		res[key] = val.Messages
+	case []*letter:
+		res[strings.TrimSuffix(key, messagesSuffix)] = val
+	}
+}
+
+func textLetter(l *letter) string {
+	var fields []string
+	for _, f := range []string{l.Date, l.From, l.Subject} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeTextShouldWriteSortedKeyValueLines(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeText(&buf, stats{
+		"unseen_count":        3,
+		"boss_count":          1,
+		"boss_count_messages": []*letter{{Date: "2021-06-10", From: "boss@bar.com", Subject: "foo"}},
+		"work_count":          &fetchedStat{Count: 1, Messages: []*letter{{Subject: "bar"}}},
+		"unread_ratio":        0.25,
+		"by_sender":           map[string]int{"a@b.com": 2},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `boss_count 1
+  2021-06-10 boss@bar.com foo
+by_sender.a@b.com 2
+unread_ratio 0.25
+unseen_count 3
+work_count 1
+  bar
+`, buf.String())
+}
+
+func Test_writeTextShouldPrefixNestedStats(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeText(&buf, stats{
+		"INBOX": stats{"unseen_count": 1},
+		"Work":  map[string]interface{}{"unseen_count": 2.0},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "INBOX.unseen_count 1\nWork.unseen_count 2\n", buf.String())
+}
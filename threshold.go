@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// exitThreshold tells that a stat breached its threshold, e.g. for monitoring probes.
+// It does not collide with sysexits.h codes used for failures.
+const exitThreshold = 10
+
+// thresholdOp returns the comparison a stat breaches its threshold by, > by default
+func (cr *criteriaCfg) thresholdOp() string {
+	if cr.ThresholdOp == "" {
+		return ">"
+	}
+	return cr.ThresholdOp
+}
+
+// breaches returns stats of st that breached thresholds of their criteria like
+// foo@bar.com/INBOX/boss_count: 3 > 0. Capped stats are compared by their exact value.
+func (c *config) breaches(st stats) []string {
+	var res []string
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		for k, cr := range c.getStatsCfg(account, mailbox) {
+			if cr == nil || cr.Threshold == nil {
+				continue
+			}
+			v, _ := rawStat(st, k)
+			n, ok := count(v)
+			if ok && compare(n, cr.thresholdOp(), *cr.Threshold) {
+				res = append(res, fmt.Sprintf("%s/%s/%s: %d %s %d", account, mailbox, k, n, cr.thresholdOp(), *cr.Threshold))
+			}
+		}
+	})
+	sort.Strings(res)
+	return res
+}
+
+// exitOnBreaches exits with exitThreshold once stats are written if any breached its threshold.
+// With -format nagios it exits with the state of the check instead, with -format waybar
+// it does not as the state is its class and bars treat failed commands as errors.
+func exitOnBreaches(cfg *config, st stats) {
+	switch *formatArg {
+	case formatNagios:
+		state, _ := nagiosCheck(cfg, st)
+		os.Exit(state)
+	case formatWaybar:
+		return
+	}
+	if breached := cfg.breaches(st); len(breached) > 0 {
+		log.Printf("threshold breached: %s", strings.Join(breached, ", "))
+		os.Exit(exitThreshold)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configBreaches(t *testing.T) {
+	cfg, err := loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    INBOX:
+      unseen_count:
+        threshold: 5
+      boss_count:
+        threshold: 0
+      news_count:
+        threshold: 10
+        threshold_op: <=
+      spam_count:
+        cap: 9
+        threshold: 50
+`)
+	require.NoError(t, err)
+	defer func(user string) { *userArg = user }(*userArg)
+	*userArg = "foo@bar.com"
+
+	given := stats{
+		"unseen_count":   5,
+		"boss_count":     &fetchedStat{Count: 1},
+		"news_count":     3,
+		"spam_count":     "9+",
+		"spam_count_raw": 100,
+	}
+
+	assert.Equal(t, []string{
+		"foo@bar.com/INBOX/boss_count: 1 > 0",
+		"foo@bar.com/INBOX/news_count: 3 <= 10",
+		"foo@bar.com/INBOX/spam_count: 100 > 50",
+	}, cfg.breaches(given))
+
+	given["boss_count"] = &fetchedStat{}
+	given["news_count"] = 11
+	given["spam_count_raw"] = 9
+	assert.Empty(t, cfg.breaches(given))
+}
+
+func Test_criteriaCfgLintShouldCheckThresholdOp(t *testing.T) {
+	threshold := 1
+	assert.Empty(t, (&criteriaCfg{Threshold: &threshold, ThresholdOp: ">="}).lint("foo", true))
+	assert.Len(t, (&criteriaCfg{Threshold: &threshold, ThresholdOp: "!="}).lint("foo", true), 1)
+	assert.Len(t, (&criteriaCfg{ThresholdOp: ">"}).lint("foo", true), 1)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// tlsConfig returns TLS settings to connect to addr with. Certificates of -ca-cert are
+// trusted in addition to system ones, e.g. for servers with a certificate of a private CA.
+func tlsConfig(addr string) (*tls.Config, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	cfg := &tls.Config{ServerName: host, InsecureSkipVerify: *insecureSkipVerifyArg}
+	if *caCertArg == "" {
+		return cfg, nil
+	}
+	b, err := ioutil.ReadFile(*caCertArg)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM certificates in %s", *caCertArg)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
+// dialStartTLS connects to addr in plain text and upgrades the connection with STARTTLS
+// before anything else is sent. Servers not advertising STARTTLS are not logged in to.
+func dialStartTLS(dialer client.Dialer, addr string, cfg *tls.Config) (*client.Client, error) {
+	c, err := client.DialWithDialer(dialer, addr)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.SupportStartTLS()
+	if err != nil {
+		c.Logout()
+		return nil, err
+	}
+	if !ok {
+		c.Logout()
+		return nil, fmt.Errorf("%s does not support STARTTLS", addr)
+	}
+	if err := c.StartTLS(cfg); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("STARTTLS with %s: %w", addr, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCert returns a self-signed certificate of 127.0.0.1
+func newTestCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "imapstats test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+// startPlainServer starts an in-memory IMAP server offering STARTTLS with cert if it is given
+func startPlainServer(t *testing.T, cert *tls.Certificate) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := server.New(memory.New())
+	if cert != nil {
+		s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// withCACert trusts cert as -ca-cert
+func withCACert(t *testing.T, cert *x509.Certificate) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	require.NoError(t, ioutil.WriteFile(path, b, 0600))
+	*caCertArg = path
+	t.Cleanup(func() { *caCertArg = "" })
+}
+
+func Test_dialStartTLSShouldUpgradeConnection(t *testing.T) {
+	cert, parsed := newTestCert(t)
+	addr := startPlainServer(t, &cert)
+	withCACert(t, parsed)
+	cfg, err := tlsConfig(addr)
+	require.NoError(t, err)
+
+	c, err := dialStartTLS(&net.Dialer{Timeout: imapTimeout()}, addr, cfg)
+	require.NoError(t, err)
+	defer c.Logout()
+
+	assert.True(t, c.IsTLS())
+	require.NoError(t, c.Login(memoryUser, memoryPassword))
+}
+
+func Test_dialStartTLSShouldFailWithoutStartTLS(t *testing.T) {
+	addr := startPlainServer(t, nil)
+	cfg, err := tlsConfig(addr)
+	require.NoError(t, err)
+
+	_, err = dialStartTLS(&net.Dialer{Timeout: imapTimeout()}, addr, cfg)
+
+	assert.EqualError(t, err, addr+" does not support STARTTLS")
+}
+
+func Test_dialStartTLSShouldVerifyCertificate(t *testing.T) {
+	cert, _ := newTestCert(t)
+	addr := startPlainServer(t, &cert)
+	cfg, err := tlsConfig(addr)
+	require.NoError(t, err)
+
+	_, err = dialStartTLS(&net.Dialer{Timeout: imapTimeout()}, addr, cfg)
+	assert.Error(t, err)
+
+	*insecureSkipVerifyArg = true
+	defer func() { *insecureSkipVerifyArg = false }()
+	cfg, err = tlsConfig(addr)
+	require.NoError(t, err)
+
+	c, err := dialStartTLS(&net.Dialer{Timeout: imapTimeout()}, addr, cfg)
+	require.NoError(t, err)
+	c.Logout()
+}
+
+func Test_tlsConfigShouldFailOnBadCACert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a certificate"), 0600))
+	*caCertArg = path
+	defer func() { *caCertArg = "" }()
+
+	_, err := tlsConfig("imap.bar.com:993")
+	assert.EqualError(t, err, "no PEM certificates in "+path)
+}
+
+func Test_dialAndLoginShouldTrustCACert(t *testing.T) {
+	cert, parsed := newTestCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	s := server.New(memory.New())
+	go s.Serve(l)
+	defer s.Close()
+	withCACert(t, parsed)
+
+	c, err := dialAndLogin("foo@bar.com", loginCfg{Addr: l.Addr().String(), User: memoryUser}, memoryPassword)
+	require.NoError(t, err)
+	assert.Equal(t, imapTimeout(), c.Timeout)
+	c.Logout()
+}
@@ -0,0 +1,48 @@
+package main
+
+import "github.com/emersion/go-imap"
+
+// keys of mailbox totals added by -include-totals
+const (
+	totalCountKey  = "total_count"
+	recentCountKey = "recent_count"
+	uidNextKey     = "uid_next"
+)
+
+// uidValidityKey is UIDVALIDITY of the mailbox added to stats of every mailbox
+const uidValidityKey = "uid_validity"
+
+// addTotals adds message totals of the selected mailbox as reported by SELECT.
+// They are ints like counts of criteria, so that summaries and computed stats can use them.
+// Configured stats keep their keys: a total colliding with one is skipped.
+func addTotals(st stats, mbox *imap.MailboxStatus, cfg statsConfig) {
+	totals := []struct {
+		key   string
+		value int
+	}{
+		{totalCountKey, int(mbox.Messages)},
+		{recentCountKey, int(mbox.Recent)},
+		{uidNextKey, int(mbox.UidNext)},
+	}
+	for _, t := range totals {
+		addMailboxStat(st, cfg, "total", t.key, t.value)
+	}
+}
+
+// addUIDValidity adds UIDVALIDITY of the selected mailbox to its stats and keeps it
+// in the cache, so that cached stats are known invalid once it changes
+func addUIDValidity(st stats, mbox *imap.MailboxStatus, cfg statsConfig) {
+	addMailboxStat(st, cfg, "UIDVALIDITY", uidValidityKey, int(mbox.UidValidity))
+	mergeCacheMeta(st, &cacheMeta{UIDValidity: mbox.UidValidity})
+}
+
+// addMailboxStat adds a stat of the selected mailbox as reported by SELECT.
+// Configured stats keep their keys: a stat colliding with one is skipped.
+func addMailboxStat(st stats, cfg statsConfig, what string, key string, value int) {
+	_, configured := cfg[key]
+	if _, collected := st[key]; configured || collected {
+		warnf("%s: not adding %s %s: a configured stat has the key", *mboxArg, what, key)
+		return
+	}
+	st[key] = value
+}
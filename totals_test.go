@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_addTotalsShouldNotOverrideConfiguredStats(t *testing.T) {
+	mbox := imap.NewMailboxStatus("INBOX", nil)
+	mbox.Messages, mbox.Recent, mbox.UidNext, mbox.UidValidity = 42, 2, 100, 7
+
+	st := stats{"unseen_count": 3}
+	addTotals(st, mbox, statsConfig{"unseen_count": &criteriaCfg{}})
+	assert.Equal(t, stats{"unseen_count": 3, "total_count": 42, "recent_count": 2, "uid_next": 100}, st)
+
+	st = stats{"unseen_count": 3, "recent_count": 1}
+	addTotals(st, mbox, statsConfig{"unseen_count": &criteriaCfg{}, "recent_count": &criteriaCfg{Recent: true}})
+	assert.Equal(t, stats{"unseen_count": 3, "total_count": 42, "recent_count": 1, "uid_next": 100}, st)
+}
+
+func Test_addUIDValidityShouldKeepItInCache(t *testing.T) {
+	mbox := imap.NewMailboxStatus("INBOX", nil)
+	mbox.UidValidity = 7
+
+	st := stats{"unseen_count": 3}
+	addUIDValidity(st, mbox, statsConfig{"unseen_count": &criteriaCfg{}})
+	assert.Equal(t, stats{"unseen_count": 3, "uid_validity": 7, cacheMetaKey: &cacheMeta{UIDValidity: 7}}, st)
+
+	st = stats{"uid_validity": 1}
+	addUIDValidity(st, mbox, statsConfig{"uid_validity": &criteriaCfg{}})
+	assert.Equal(t, stats{"uid_validity": 1, cacheMetaKey: &cacheMeta{UIDValidity: 7}}, st)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// eachCriteria calls fn with top level criteria of all accounts and mailboxes in a stable order
+func (c *config) eachCriteria(fn func(location string, cr *criteriaCfg) error) error {
+	accounts := make([]string, 0, len(c.Accounts))
+	for acc := range c.Accounts {
+		accounts = append(accounts, acc)
+	}
+	sort.Strings(accounts)
+	for _, acc := range accounts {
+		mboxes := make([]string, 0, len(c.Accounts[acc]))
+		for mbox := range c.Accounts[acc] {
+			mboxes = append(mboxes, mbox)
+		}
+		sort.Strings(mboxes)
+		for _, mbox := range mboxes {
+			cfg := c.Accounts[acc][mbox]
+			for _, key := range sortedKeys(cfg) {
+				if cfg[key] == nil {
+					continue
+				}
+				if err := fn(fmt.Sprintf("accounts.%s.%s.%s", acc, mbox, key), cfg[key]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadUIDFiles reads UIDs of criteria with uids_file; relative paths are resolved against dir
+func (c *config) loadUIDFiles(dir string) error {
+	return c.eachCriteria(func(location string, cr *criteriaCfg) error {
+		if cr.UIDsFile == "" {
+			return nil
+		}
+		path := cr.UIDsFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		uids, err := readUIDs(path)
+		if err != nil {
+			return fmt.Errorf("%w: %s: uids_file: %s", errBadConfig, location, err)
+		}
+		cr.uids = uids
+		return nil
+	})
+}
+
+// readUIDs reads a file with UIDs separated by whitespace or commas.
+// Empty lines and lines starting with # are skipped.
+func readUIDs(path string) (*imap.SeqSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := &imap.SeqSet{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil || uid == 0 {
+				return nil, fmt.Errorf("%s:%d: bad uid %q", path, line, field)
+			}
+			res.AddNum(uint32(uid))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if res.Empty() {
+		return nil, fmt.Errorf("%s: no uids found", path)
+	}
+	return res, nil
+}
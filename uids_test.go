@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadUIDFilesShouldConstrainSearch(t *testing.T) {
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		"foo@bar.com": {"INBOX": {"reconciled_count": &criteriaCfg{UIDsFile: "uids.txt"}}},
+	}}
+
+	require.NoError(t, cfg.loadUIDFiles("testdata"))
+
+	sc := cfg.Accounts["foo@bar.com"]["INBOX"]["reconciled_count"].toIMAP()
+	expected, _ := imap.ParseSeqSet("1001:1002,1005,2000:2001")
+	assert.Equal(t, expected.String(), sc.Uid.String())
+}
+
+func Test_readUIDsShouldValidateFormat(t *testing.T) {
+	var tests = []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{"not a number", "1\n2\nfoo\n", `uids:3: bad uid "foo"`},
+		{"zero", "0", `uids:1: bad uid "0"`},
+		{"range", "1:5", `uids:1: bad uid "1:5"`},
+		{"empty", "# nothing\n", "uids: no uids found"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "uids")
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.given), 0600))
+
+			_, err := readUIDs(path)
+			require.Error(t, err)
+			assert.Equal(t, filepath.Dir(path)+"/"+tt.expected, err.Error())
+		})
+	}
+}
+
+func Test_loadUIDFilesShouldFailWithLocation(t *testing.T) {
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		"foo@bar.com": {"INBOX": {"k": &criteriaCfg{UIDsFile: "no-such-file"}}},
+	}}
+
+	err := cfg.loadUIDFiles("testdata")
+	assert.EqualError(t, err,
+		"bad config: accounts.foo@bar.com.INBOX.k: uids_file: open testdata/no-such-file: no such file or directory")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// warmTarget is a mailbox of an account to warm the cache of
+type warmTarget struct {
+	account string
+	mailbox string
+}
+
+// warmTargets returns every configured mailbox of every configured account in order.
+// Without configured accounts -mailbox of -user is warmed.
+func warmTargets(cfg *config) ([]warmTarget, error) {
+	var res []warmTarget
+	for _, acc := range cfg.accountNames() {
+		mailboxes := make([]string, 0, len(cfg.Accounts[acc]))
+		for mbox := range cfg.Accounts[acc] {
+			mailboxes = append(mailboxes, mbox)
+		}
+		if len(mailboxes) == 0 {
+			mailboxes = append(mailboxes, *mboxArg)
+		}
+		sort.Strings(mailboxes)
+		for _, mbox := range mailboxes {
+			res = append(res, warmTarget{account: acc, mailbox: mbox})
+		}
+	}
+	if len(res) == 0 {
+		if *userArg == "" {
+			return nil, errors.New("no accounts configured: set -user or list accounts in config")
+		}
+		res = append(res, warmTarget{account: *userArg, mailbox: *mboxArg})
+	}
+	return res, nil
+}
+
+// warmCaches refreshes caches of all configured mailboxes of all configured accounts
+// as if each of them was fetched with -write-cache -q, logging in to each account with
+// its login options. Failures are reported after all mailboxes are tried.
+func warmCaches(cfg *config, filter *statsFilter, fetch func(*config) (stats, error)) error {
+	targets, err := warmTargets(cfg)
+	if err != nil {
+		return err
+	}
+	origUser, origMbox, origWrite, origQuiet := *userArg, *mboxArg, *writeCacheArg, *quietArg
+	defer func() {
+		*userArg, *mboxArg, *writeCacheArg, *quietArg = origUser, origMbox, origWrite, origQuiet
+	}()
+	*writeCacheArg, *quietArg = true, true
+
+	failed := 0
+	for _, it := range targets {
+		*userArg, *mboxArg = it.account, it.mailbox
+		if err := warmMailbox(cfg, filter, fetch); err != nil {
+			failed++
+			warnf("failed to warm %s/%s: %s", it.account, it.mailbox, err)
+			continue
+		}
+		log.Printf("refreshed %s/%s", it.account, it.mailbox)
+	}
+	log.Printf("warmed %d of %d mailbox(es)", len(targets)-failed, len(targets))
+	if failed > 0 {
+		return fmt.Errorf("failed to warm %d of %d mailbox(es)", failed, len(targets))
+	}
+	return nil
+}
+
+func warmMailbox(cfg *config, filter *statsFilter, fetch func(*config) (stats, error)) error {
+	st, err := fetch(cfg)
+	if err != nil {
+		return err
+	}
+	st, err = postprocessFetched(cfg, st, filter)
+	if err != nil {
+		return err
+	}
+	return writeStats(st)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_warmCachesShouldWriteCacheOfEveryMailboxOfEveryAccount(t *testing.T) {
+	withTempCacheDir(t)
+	withReport(t, "")
+	defer func(user string) { *userArg = user }(*userArg)
+	*userArg = ""
+	cfg := &config{Accounts: map[string]map[string]statsConfig{
+		"foo@bar.com": {
+			"INBOX":   {"boss_count": &criteriaCfg{}},
+			"Work":    {},
+			"Archive": {},
+		},
+		"other@bar.com": {"INBOX": {}},
+	}}
+
+	var fetched []string
+	err := warmCaches(cfg, nil, func(*config) (stats, error) {
+		fetched = append(fetched, *userArg+"/"+*mboxArg)
+		if *mboxArg == "Archive" {
+			return nil, errors.New("boom")
+		}
+		return stats{"unseen_count": len(fetched)}, nil
+	})
+
+	assert.EqualError(t, err, "failed to warm 1 of 4 mailbox(es)")
+	assert.Equal(t, []string{
+		"foo@bar.com/Archive", "foo@bar.com/INBOX", "foo@bar.com/Work", "other@bar.com/INBOX",
+	}, fetched)
+	for name, expected := range map[string]string{
+		"foo@bar.com.INBOX":   `{"unseen_count":2}`,
+		"foo@bar.com.Work":    `{"unseen_count":3}`,
+		"other@bar.com.INBOX": `{"unseen_count":4}`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(cacheDir, name))
+		require.NoError(t, err)
+		assert.JSONEq(t, expected, string(b))
+	}
+	assert.NoFileExists(t, filepath.Join(cacheDir, "foo@bar.com.Archive"))
+	assert.Equal(t, "INBOX", *mboxArg)
+	assert.Equal(t, "", *userArg)
+	assert.False(t, *writeCacheArg)
+	assert.Len(t, report.Warnings, 1)
+}
+
+func Test_warmCachesShouldWarmMailboxOfUserWithoutAccounts(t *testing.T) {
+	withTempCacheDir(t)
+	withReport(t, "")
+	defer func(user string) { *userArg = user }(*userArg)
+
+	*userArg = ""
+	err := warmCaches(&config{}, nil, func(*config) (stats, error) { return stats{}, nil })
+	assert.EqualError(t, err, "no accounts configured: set -user or list accounts in config")
+
+	*userArg = "foo@bar.com"
+	require.NoError(t, warmCaches(&config{}, nil, func(*config) (stats, error) { return stats{}, nil }))
+	assert.FileExists(t, filepath.Join(cacheDir, "foo@bar.com.INBOX"))
+}
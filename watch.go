@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+const idleCap = "IDLE"
+
+// connChanges watch changes of the selected mailbox the server reported to kept connections
+var connChanges map[*client.Client]*mailboxWatch
+
+// mailboxWatch signals new mail, expunges and flag changes reported to a connection
+type mailboxWatch struct {
+	changed chan struct{}
+	// reset takes requests to forget changes reported so far; the request is closed once done
+	reset chan chan struct{}
+}
+
+// forget drops changes reported so far, e.g. ones collected by the last cycle
+func (w *mailboxWatch) forget() {
+	done := make(chan struct{})
+	w.reset <- done
+	<-done
+}
+
+// noIdleLogged tells that -daemon already logged falling back to polling
+var noIdleLogged bool
+
+func validateIdle() error {
+	if !*idleArg {
+		return nil
+	}
+	if allAccounts() || multiMailbox() {
+		return errors.New("-idle watches a single mailbox: set -user and one -mailbox")
+	}
+	return nil
+}
+
+// watchChanges watches changes of the mailbox reported to c of -daemon -idle in connChanges.
+// go-imap reads Updates without synchronization, so it is called before c runs any command.
+// Updates are read until c logs out, as an unread update blocks the whole client.
+func watchChanges(c *client.Client) {
+	if connChanges == nil || !*idleArg {
+		return
+	}
+	w := &mailboxWatch{changed: make(chan struct{}, 1), reset: make(chan chan struct{})}
+	updates := make(chan client.Update, 16)
+	c.Updates = updates
+	go func() {
+		for {
+			select {
+			case u := <-updates:
+				switch u.(type) {
+				case *client.MailboxUpdate, *client.MessageUpdate, *client.ExpungeUpdate:
+					select {
+					case w.changed <- struct{}{}:
+					default:
+					}
+				}
+			case done := <-w.reset:
+				// updates of finished commands are queued already
+				for drained := false; !drained; {
+					select {
+					case <-updates:
+					case <-w.changed:
+					default:
+						drained = true
+					}
+				}
+				close(done)
+			case <-c.LoggedOut():
+				return
+			}
+		}
+	}()
+	connChanges[c] = w
+}
+
+// idleOnKept idles on the kept connection of -user with -idle. The returned channel receives
+// once the server reports a change or idling fails, so that the next cycle logs in again;
+// end stops idling. Without idling the channel is nil and never receives.
+func idleOnKept() (<-chan struct{}, func()) {
+	c := keptConns[*userArg]
+	w := connChanges[c]
+	if !*idleArg || c == nil || w == nil {
+		return nil, func() {}
+	}
+	if ok, err := c.Support(idleCap); err != nil || !ok {
+		if !noIdleLogged {
+			log.Printf("server does not support IDLE, polling every %s", *intervalArg)
+			noIdleLogged = true
+		}
+		return nil, func() {}
+	}
+	// changes reported before idling, e.g. to NOOP of connect, were collected by the cycle
+	w.forget()
+	changed := w.changed
+	account := *userArg
+	// IDLE lasts until the mailbox changes, so -timeout of commands does not apply to it
+	timeout := c.Timeout
+	c.Timeout = 0
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Idle(stop, nil); err != nil {
+			log.Printf("IDLE of %s failed: %s", account, err)
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed, func() {
+		close(stop)
+		<-done
+		c.Timeout = timeout
+	}
+}
+
+// nextCycle waits for the next cycle of -daemon and tells whether to stop instead.
+// With -idle a cycle also starts once the mailbox changes; ticks still start them
+// as criteria relative to now like older_than change without mail.
+func nextCycle(tick <-chan time.Time, stop <-chan os.Signal) bool {
+	changed, endIdle := idleOnKept()
+	defer endIdle()
+	select {
+	case sig := <-stop:
+		log.Printf("%s received, logging out", sig)
+		return true
+	case <-tick:
+	case <-changed:
+	}
+	return false
+}
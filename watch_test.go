@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// updatingBackend is the test backend pushing given updates to clients
+type updatingBackend struct {
+	testBackend
+	updates chan backend.Update
+}
+
+func (b updatingBackend) Updates() <-chan backend.Update {
+	return b.updates
+}
+
+// idleExtension advertises IDLE the server supports without advertising it
+type idleExtension struct{}
+
+func (idleExtension) Capabilities(server.Conn) []string { return []string{idleCap} }
+
+func (idleExtension) Command(string) server.HandlerFactory { return nil }
+
+// withKeptConn keeps a connection to a test server with INBOX selected like -daemon -idle does
+func withKeptConn(t *testing.T, advertiseIdle bool) (*client.Client, chan backend.Update) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	be := updatingBackend{testBackend{memory.New()}, make(chan backend.Update)}
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	if advertiseIdle {
+		s.Enable(idleExtension{})
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	origUser, origIdle := *userArg, *idleArg
+	*userArg, *idleArg = "foo@bar.com", true
+	connChanges, noIdleLogged = map[*client.Client]*mailboxWatch{}, false
+	t.Cleanup(func() {
+		*userArg, *idleArg = origUser, origIdle
+		keptConns, connChanges = nil, nil
+	})
+
+	c, err := client.Dial(l.Addr().String())
+	require.NoError(t, err)
+	watchChanges(c)
+	t.Cleanup(func() { c.Logout() })
+	require.NoError(t, c.Login("username", "password"))
+	_, err = c.Select("INBOX", false)
+	require.NoError(t, err)
+	keptConns = map[string]*client.Client{*userArg: c}
+	return c, be.updates
+}
+
+func Test_nextCycleShouldStartOnMailboxChanges(t *testing.T) {
+	c, updates := withKeptConn(t, true)
+
+	res := make(chan bool)
+	go func() { res <- nextCycle(nil, nil) }()
+
+	status := imap.NewMailboxStatus("INBOX", []imap.StatusItem{imap.StatusMessages})
+	status.Messages = 2
+	timeout := time.After(5 * time.Second)
+	for {
+		// updates are dropped until the client idles
+		select {
+		case updates <- &backend.MailboxUpdate{Update: backend.NewUpdate("username", "INBOX"), MailboxStatus: status}:
+		case stopped := <-res:
+			assert.False(t, stopped)
+			assert.NoError(t, c.Noop())
+			return
+		case <-timeout:
+			t.Fatal("no cycle started on a mailbox change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_nextCycleShouldStopIdlingOnSignal(t *testing.T) {
+	c, _ := withKeptConn(t, true)
+
+	stop := make(chan os.Signal, 1)
+	stop <- syscall.SIGTERM
+
+	assert.True(t, nextCycle(nil, stop))
+	assert.NoError(t, c.Noop())
+}
+
+func Test_nextCycleShouldIgnoreChangesReportedBeforeIdling(t *testing.T) {
+	c, _ := withKeptConn(t, true)
+	// like connect does; SELECT reported the mailbox already
+	require.NoError(t, c.Noop())
+
+	tick := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tick <- time.Now()
+	}()
+	started := time.Now()
+	assert.False(t, nextCycle(tick, nil))
+	assert.GreaterOrEqual(t, int64(time.Since(started)), int64(100*time.Millisecond))
+}
+
+func Test_nextCycleShouldIdleLongerThanCommandTimeout(t *testing.T) {
+	c, _ := withKeptConn(t, true)
+	c.Timeout = 50 * time.Millisecond
+
+	tick := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		tick <- time.Now()
+	}()
+	started := time.Now()
+	assert.False(t, nextCycle(tick, nil))
+
+	assert.GreaterOrEqual(t, int64(time.Since(started)), int64(300*time.Millisecond))
+	assert.Equal(t, 50*time.Millisecond, c.Timeout)
+	assert.NoError(t, c.Noop())
+}
+
+func Test_nextCycleShouldPollWithoutIdle(t *testing.T) {
+	withKeptConn(t, false)
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+
+	assert.False(t, nextCycle(tick, nil))
+	assert.True(t, noIdleLogged)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const formatWaybar = "waybar"
+
+// waybarOutput is the JSON a custom module of waybar or polybar scripts read
+type waybarOutput struct {
+	Text    string `json:"text"`
+	Tooltip string `json:"tooltip,omitempty"`
+	Class   string `json:"class,omitempty"`
+}
+
+// waybarMarkup escapes text as waybar renders tooltips as Pango markup
+var waybarMarkup = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// writeWaybar writes the default stat summed over accounts and mailboxes as text,
+// subjects of fetched messages as tooltip and the state of warning and critical
+// levels as class, so that bars can style mailboxes that need attention.
+func writeWaybar(w io.Writer, st stats) error {
+	total := 0
+	eachMailboxStats(st, func(account string, mailbox string, st stats) {
+		v, _ := rawStat(st, *defaultStatKeyArg)
+		if n, ok := count(v); ok {
+			total += n
+		}
+	})
+	messages := map[string][]*letter{}
+	textMessagesInto(messages, "", st)
+	keys := make([]string, 0, len(messages))
+	for k := range messages {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var subjects []string
+	for _, k := range keys {
+		for _, l := range messages[k] {
+			subjects = append(subjects, waybarMarkup.Replace(l.Subject))
+		}
+	}
+	out := waybarOutput{
+		Text:    strconv.Itoa(total),
+		Tooltip: strings.Join(subjects, "\n"),
+	}
+	if state, _ := nagiosCheck(checkConfig, st); state != nagiosOK {
+		out.Class = strings.ToLower(nagiosStates[state])
+	}
+	return json.NewEncoder(w).Encode(out)
+}
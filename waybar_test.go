@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeWaybar(t *testing.T) {
+	defer func(user, mbox string) { *userArg, *mboxArg = user, mbox }(*userArg, *mboxArg)
+	*userArg, *mboxArg = "", "INBOX,Work"
+	defer func(cfg *config) { checkConfig = cfg }(checkConfig)
+
+	var err error
+	checkConfig, err = loadConfigFrom(t, `
+accounts:
+  foo@bar.com:
+    Work:
+      boss_count:
+        fetch: true
+        threshold: 0
+`)
+	require.NoError(t, err)
+
+	given := stats{
+		"foo@bar.com": stats{
+			"INBOX": stats{"unseen_count": 3},
+			"Work": stats{
+				"unseen_count": 2,
+				"boss_count":   &fetchedStat{Count: 2, Messages: []*letter{{Subject: "Q&A"}, {Subject: "<urgent>"}}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeWaybar(&buf, given))
+	assert.JSONEq(t, `{"text":"5","tooltip":"Q&amp;A\n&lt;urgent&gt;","class":"critical"}`, buf.String())
+
+	given["foo@bar.com"].(stats)["Work"] = stats{"unseen_count": 0, "boss_count": &fetchedStat{}}
+	buf.Reset()
+	require.NoError(t, writeWaybar(&buf, given))
+	assert.JSONEq(t, `{"text":"3"}`, buf.String())
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+const withinCap = "WITHIN"
+
+// supportsWithin tells whether the server advertises the WITHIN extension, see RFC 5032
+func supportsWithin(c imapClient) (bool, error) {
+	rc, ok := c.(rawCommander)
+	if !ok {
+		return false, nil
+	}
+	return rc.Support(withinCap)
+}
+
+// hasWithin tells whether cr restricts messages by their age
+func (cr *criteriaCfg) hasWithin() bool {
+	return cr.Younger > 0 || cr.Older > 0
+}
+
+// withinKeys returns YOUNGER and OLDER search keys of cr replacing
+// the day based approximation that toIMAP put into sc
+func (cr *criteriaCfg) withinKeys(sc *imap.SearchCriteria) []interface{} {
+	var keys []interface{}
+	if cr.Younger > 0 {
+		sc.Since = time.Time{}
+		keys = append(keys, imap.RawString("YOUNGER"), uint32(cr.Younger/time.Second))
+	}
+	if cr.Older > 0 {
+		sc.Before = time.Time{}
+		keys = append(keys, imap.RawString("OLDER"), uint32(cr.Older/time.Second))
+	}
+	return keys
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawFakeClient is a fakeClient of a server with given capabilities that runs raw commands
+type rawFakeClient struct {
+	fakeClient
+	caps map[string]bool
+	// fields of the response to commands; SEARCH 1 2 by default
+	fields []interface{}
+
+	commands []*imap.Command
+}
+
+func (c *rawFakeClient) Support(cap string) (bool, error) {
+	return c.caps[cap], nil
+}
+
+func (c *rawFakeClient) State() imap.ConnState {
+	return imap.SelectedState
+}
+
+func (c *rawFakeClient) Execute(cmdr imap.Commander, h responses.Handler) (*imap.StatusResp, error) {
+	c.commands = append(c.commands, cmdr.Command())
+	fields := c.fields
+	if fields == nil {
+		fields = []interface{}{"SEARCH", "1", "2"}
+	}
+	if err := h.Handle(&imap.DataResp{Tag: "*", Fields: fields}); err != nil {
+		return nil, err
+	}
+	return &imap.StatusResp{Type: imap.StatusRespOk}, nil
+}
+
+func Test_collectStatsShouldSearchWithinOnCapableServers(t *testing.T) {
+	underTest := &rawFakeClient{caps: map[string]bool{withinCap: true}}
+
+	st, err := collectStats(underTest, statsConfig{
+		"recent_count": &criteriaCfg{Younger: time.Hour, Older: 10 * time.Minute},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"recent_count": 2}, st)
+
+	require.Len(t, underTest.commands, 1)
+	args := underTest.commands[0].Arguments
+	assert.Equal(t, []interface{}{
+		imap.RawString("YOUNGER"), uint32(3600), imap.RawString("OLDER"), uint32(600),
+	}, args[len(args)-4:])
+	assert.NotContains(t, args, imap.RawString("SINCE"))
+	assert.NotContains(t, args, imap.RawString("BEFORE"))
+}
+
+func Test_collectStatsShouldFallBackToDatesWithoutWithin(t *testing.T) {
+	now = func() time.Time { return time.Date(2021, 6, 10, 15, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	var actual *imap.SearchCriteria
+	underTest := &fakeClient{search: func(sc *imap.SearchCriteria) ([]uint32, error) {
+		actual = sc
+		return []uint32{1}, nil
+	}}
+
+	st, err := collectStats(underTest, statsConfig{
+		"recent_count": &criteriaCfg{Younger: time.Hour, Older: 10 * time.Minute},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, stats{"recent_count": 1}, st)
+	assert.Equal(t, time.Date(2021, 6, 10, 14, 0, 0, 0, time.UTC), actual.Since)
+	assert.Equal(t, time.Date(2021, 6, 10, 14, 50, 0, 0, time.UTC), actual.Before)
+
+	raw := &rawFakeClient{caps: map[string]bool{}}
+	_, err = collectStats(raw, statsConfig{
+		"recent_count": &criteriaCfg{Younger: time.Hour},
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, raw.commands, 1)
+	assert.Contains(t, raw.commands[0].Arguments, imap.RawString("SINCE"))
+	assert.NotContains(t, raw.commands[0].Arguments, imap.RawString("YOUNGER"))
+}
+
+func Test_criteriaCfgLintShouldWarnAboutWithinInClauses(t *testing.T) {
+	cr := &criteriaCfg{
+		Or:  []criteriaCfg{{Younger: time.Hour}, {Seen: true}},
+		Not: &criteriaCfg{Older: time.Hour},
+	}
+	assert.Equal(t, []configProblem{
+		{location: "k.or[0]", msg: "younger and older are rounded to whole days inside OR or NOT clauses", advisory: true},
+		{location: "k.not", msg: "younger and older are rounded to whole days inside OR or NOT clauses", advisory: true},
+	}, cr.lint("k", true))
+}